@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adrialopezbou/bookings-go/internal/handlers"
+	"github.com/adrialopezbou/bookings-go/internal/ical"
+)
+
+// syncCalendars imports every external calendar, stopping early when ctx is done. A calendar that
+// can't be imported doesn't stop the others, and makes the run fail once they are done
+func syncCalendars(ctx context.Context) error {
+	feeds, err := handlers.Repo.DB.AllCalendarFeeds()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, feed := range feeds {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := ical.Sync(handlers.Repo.DB, feed)
+		if err != nil {
+			app.ErrorLog.Printf("importing calendar %d (%s): %s", feed.ID, feed.Name, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d calendars could not be imported", failed, len(feeds))
+	}
+	return nil
+}
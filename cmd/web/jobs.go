@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/handlers"
+	"github.com/adrialopezbou/bookings-go/internal/jobs"
+)
+
+// jobRunsKept is how long the runs of the jobs are kept for the jobs page
+const jobRunsKept = 30 * 24 * time.Hour
+
+// newJobs returns the runner of the background jobs, with every job registered
+func newJobs() (*jobs.Runner, error) {
+	runner := &jobs.Runner{
+		Store:    handlers.Repo.DB,
+		InfoLog:  app.InfoLog,
+		ErrorLog: app.ErrorLog,
+	}
+
+	registrations := []struct {
+		name     string
+		schedule string
+		run      jobs.Func
+	}{
+		{"calendar-sync", "*/15 * * * *", syncCalendars},
+		{"guest-messages", "5 * * * *", sendGuestMessages},
+		{"waitlist", "*/5 * * * *", notifyWaitlist},
+		{"release-holds", "* * * * *", releaseHolds},
+		{"purge-job-runs", "30 3 * * *", purgeJobRuns},
+	}
+
+	for _, r := range registrations {
+		err := runner.Register(r.name, r.schedule, r.run)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return runner, nil
+}
+
+// listenForJobs runs the background jobs on their schedules until ctx is done, and then closes the
+// channel it returns once the runs in progress are done
+func listenForJobs(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.Jobs.Run(ctx)
+	}()
+	return done
+}
+
+// sendGuestMessages queues the guest messages that are due
+func sendGuestMessages(ctx context.Context) error {
+	sent, err := handlers.Repo.SendGuestMessages(time.Now())
+	if err != nil {
+		return err
+	}
+	if sent > 0 {
+		app.InfoLog.Printf("queued %d guest messages", sent)
+	}
+	return nil
+}
+
+// notifyWaitlist offers the rooms that freed up to the guests waiting for them. Rooms free up when
+// reservations are cancelled or deleted, dates are unblocked and calendars are synced, so the
+// waitlist is checked often rather than after each of those
+func notifyWaitlist(ctx context.Context) error {
+	offered, err := handlers.Repo.NotifyWaitlist(time.Now())
+	if err != nil {
+		return err
+	}
+	if offered > 0 {
+		app.InfoLog.Printf("offered rooms to %d guests on the waitlist", offered)
+	}
+	return nil
+}
+
+// releaseHolds lets go of the rooms held for guests who stopped booking them
+func releaseHolds(ctx context.Context) error {
+	released, err := handlers.Repo.DB.ReleaseExpiredHolds(time.Now())
+	if err != nil {
+		return err
+	}
+	if released > 0 {
+		app.InfoLog.Printf("released %d expired holds", released)
+	}
+	return nil
+}
+
+// purgeJobRuns deletes the runs of the jobs older than jobRunsKept
+func purgeJobRuns(ctx context.Context) error {
+	_, err := handlers.Repo.DB.DeleteJobRunsBefore(time.Now().Add(-jobRunsKept))
+	return err
+}
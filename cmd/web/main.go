@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/adrialopezbou/bookings-go/internal/config"
 	"github.com/adrialopezbou/bookings-go/internal/driver"
 	"github.com/adrialopezbou/bookings-go/internal/handlers"
 	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
 	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/openapi"
+	"github.com/adrialopezbou/bookings-go/internal/payments"
 	"github.com/adrialopezbou/bookings-go/internal/render"
 
 	"net/http"
@@ -19,56 +27,123 @@ import (
 	"github.com/alexedwards/scs/v2"
 )
 
-const portNumber = ":8080"
-
 var app config.AppConfig
 
 var session *scs.SessionManager
 
 // main is the main application function
 func main() {
-	db, err := run()
-	if err != nil {
+	db, err := run(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
 		log.Fatal(err)
 	}
 
-	defer db.SQL.Close()
-	defer close(app.MailChan)
-	listenForMail()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	background, stopBackground := context.WithCancel(context.Background())
 
-	app.InfoLog.Println("Starting mail listener...")
-	listenForMail()
+	app.InfoLog.Println("Starting mail workers...")
+	mailDone := listenForMail(background)
 
-	fmt.Println(fmt.Sprint("Starting application on port", portNumber))
+	app.InfoLog.Println("Starting background jobs...")
+	jobsDone := listenForJobs(background)
+
+	fmt.Println(fmt.Sprint("Starting application on port ", app.Port))
 
 	srv := &http.Server {
-		Addr: portNumber,
+		Addr: app.Addr(),
 		Handler: routes(&app),
 	}
 
-	err = srv.ListenAndServe()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+		app.ErrorLog.Println(err)
+	case <-ctx.Done():
+		app.InfoLog.Println("Shutting down...")
+	}
+	// a second signal stops the application right away
+	stop()
+
+	shutdown(srv, db, stopBackground, jobsDone, mailDone)
+
 	if err != nil {
-		log.Fatal(err)
+		os.Exit(1)
+	}
+}
+
+// shutdown stops the application in order: it stops accepting connections and waits for the requests
+// in flight, stops the background work and waits for it to finish, like the emails being sent, and
+// closes the database. Mail not sent yet stays in the outbox for the next start. Each wait is bounded
+// by the shutdown timeout
+func shutdown(srv *http.Server, db *driver.DB, stopBackground context.CancelFunc, done ...<-chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		app.ErrorLog.Println("requests still running at shutdown:", err)
+		srv.Close()
+	}
+
+	stopBackground()
+	timeout := time.After(app.ShutdownTimeout)
+	for _, d := range done {
+		select {
+		case <-d:
+		case <-timeout:
+			app.ErrorLog.Println("background work still running at shutdown")
+		}
+	}
+
+	err = db.SQL.Close()
+	if err != nil {
+		app.ErrorLog.Println(err)
 	}
+	app.InfoLog.Println("Stopped")
 }
 
-func run() (*driver.DB, error) {
+// run sets the application up with the settings in args, the environment and the config file
+func run(args []string) (*driver.DB, error) {
 	// what am I going to put in the session
 	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(models.RoomRestriction{})
+	gob.Register(map[string]int{})
 	
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
+	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
+	err := app.Load(args)
+	if err != nil {
+		return nil, err
+	}
+	app.InfoLog.Printf("Effective config:\n%s", app.Effective())
 
-	// change this to true when in production
-	app.InProduction = false
+	app.Payments = payments.NewFakeGateway(app.PaymentsWebhookSecret)
 
-	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	app.MailTemplates, err = mailer.ParseTemplates(app.MailTemplatesDir)
+	if err != nil {
+		log.Println("cannot parse the email templates")
+		return nil, err
+	}
+	app.Mailer = newMailer()
+
+	validator, err := openapi.NewValidator()
+	if err != nil {
+		log.Println("cannot load the api document")
+		return nil, err
+	}
+	app.OpenAPI = validator
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -80,7 +155,7 @@ func run() (*driver.DB, error) {
 
 	// connect to database
 	log.Println("Connecting to database...")
-	db, err := driver.ConnectSQL("host=localhost port=5432 dbname=bookings user=postgres password=1234")
+	db, err := driver.ConnectSQL(app.DSN())
 	if err != nil {
 		log.Fatal("Cannot connect to database! Dying...")
 	}
@@ -93,10 +168,15 @@ func run() (*driver.DB, error) {
 	}
 
 	app.TemplateCache = tc
-	app.UseCache = false
 
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
+
+	app.Jobs, err = newJobs()
+	if err != nil {
+		log.Println("cannot register the background jobs")
+		return nil, err
+	}
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 
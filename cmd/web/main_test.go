@@ -1,10 +1,71 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/driver"
+)
 
 func TestRun(t *testing.T) {
-	_, err := run()
+	_, err := run([]string{"-mail-templates", "./../../email-templates"})
 	if err != nil {
 		t.Errorf("failed run()")
 	}
-}
\ No newline at end of file
+}
+
+func TestShutdown(t *testing.T) {
+	app.InfoLog = log.New(ioutil.Discard, "", 0)
+	app.ErrorLog = log.New(ioutil.Discard, "", 0)
+	app.ShutdownTimeout = 5 * time.Second
+
+	// a request is in flight when shutting down
+	started := make(chan struct{})
+	requestDone := false
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		requestDone = true
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	// and so is an email being sent in the background
+	background, stopBackground := context.WithCancel(context.Background())
+	backgroundDone := make(chan struct{})
+	sent := false
+	go func() {
+		defer close(backgroundDone)
+		<-background.Done()
+		time.Sleep(50 * time.Millisecond)
+		sent = true
+	}()
+
+	sqlDB, err := sql.Open("pgx", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown(srv, &driver.DB{SQL: sqlDB}, stopBackground, backgroundDone)
+
+	if !requestDone {
+		t.Error("expected the request in flight to finish")
+	}
+	if !sent {
+		t.Error("expected the background work to finish")
+	}
+	if err := sqlDB.Ping(); err == nil || err.Error() != "sql: database is closed" {
+		t.Errorf("expected the database to be closed but got %v", err)
+	}
+}
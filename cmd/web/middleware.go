@@ -17,6 +17,13 @@ func NoSurf(next http.Handler) http.Handler {
 		Secure: app.InProduction,
 		SameSite: http.SameSiteLaxMode,
 	})
+
+	// the payment provider signs its webhooks instead
+	csrfHandler.ExemptPath("/payments/webhook")
+
+	// api clients authenticate with api keys, not cookies
+	csrfHandler.ExemptGlob("/api/*")
+
 	return csrfHandler
 }
 
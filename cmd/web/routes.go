@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/adrialopezbou/bookings-go/internal/config"
 	"github.com/adrialopezbou/bookings-go/internal/handlers"
+	"github.com/adrialopezbou/bookings-go/internal/rbac"
 	"net/http"
 
 	"github.com/go-chi/chi"
@@ -22,30 +23,142 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
+	mux.Post("/make-reservation/hold", handlers.Repo.PostReservationHold)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
+	mux.Get("/manage-reservation/{token}", handlers.Repo.ManageReservation)
+	mux.Post("/manage-reservation/{token}/dates", handlers.Repo.ManagePostDates)
+	mux.Post("/manage-reservation/{token}/contact", handlers.Repo.ManagePostContact)
+	mux.Post("/manage-reservation/{token}/cancel", handlers.Repo.ManagePostCancel)
+
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
 
+	mux.Get("/waitlist", handlers.Repo.ShowWaitlist)
+	mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+	mux.Get("/waitlist/offer/{token}", handlers.Repo.WaitlistOffer)
+
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
 
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	mux.Get("/rooms/{slug}/calendar.ics", handlers.Repo.RoomCalendar)
+
+	// the room pages used to live at fixed urls
+	mux.Get("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently).ServeHTTP)
+	mux.Get("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently).ServeHTTP)
+
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/set-password/{token}", handlers.Repo.ShowSetPassword)
+	mux.Post("/user/set-password/{token}", handlers.Repo.PostSetPassword)
+	mux.Get("/user/forgot-password", handlers.Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", handlers.Repo.ShowResetPassword)
+	mux.Post("/user/reset-password/{token}", handlers.Repo.PostResetPassword)
+	mux.Get("/user/verify-email/{token}", handlers.Repo.VerifyEmailLink)
+	mux.Get("/user/two-factor", handlers.Repo.ShowTwoFactorLogin)
+	mux.Post("/user/two-factor", handlers.Repo.PostTwoFactorLogin)
+
+	mux.Get("/api/openapi.json", handlers.Repo.OpenAPISpec)
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(handlers.Repo.RequireAPIKey)
+		mux.Use(handlers.Repo.ValidateAPIRequest)
+		mux.NotFound(handlers.Repo.APINotFound)
+		mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/availability", handlers.Repo.APIAvailability)
+		mux.Post("/reservations", handlers.Repo.APIPostReservation)
+		mux.Get("/reservations/{id}", handlers.Repo.APIReservation)
+		mux.Delete("/reservations/{id}", handlers.Repo.APICancelReservation)
+	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(Auth)
+		mux.Use(handlers.Repo.LoadUser)
 
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Get("/profile", handlers.Repo.AdminProfile)
+		mux.Post("/profile", handlers.Repo.AdminPostProfile)
+		mux.Post("/profile/verify-email", handlers.Repo.AdminResendVerification)
+		mux.Get("/two-factor", handlers.Repo.AdminTwoFactor)
+		mux.Post("/two-factor", handlers.Repo.AdminPostTwoFactor)
+		mux.Post("/two-factor/recovery-codes", handlers.Repo.AdminPostRecoveryCodes)
+		mux.Post("/two-factor/disable", handlers.Repo.AdminDisableTwoFactor)
+
+		mux.With(can(rbac.ViewReservations)).Get("/reservations-new", handlers.Repo.AdminNewReservations)
+		mux.With(can(rbac.ViewReservations)).Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.With(can(rbac.ViewReservations)).Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
+		mux.With(can(rbac.EditReservations)).Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.With(can(rbac.EditReservations)).Post("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
+		mux.With(can(rbac.DeleteReservations)).Post("/delete-reservation/{src}/{id}", handlers.Repo.AdminDeleteReservation)
+
+		mux.With(can(rbac.ViewReservations)).Get("/reservation-calendar", handlers.Repo.AdminReservationsCalendar)
+		mux.With(can(rbac.BlockDates)).Post("/reservation-calendar", handlers.Repo.AdminPostReservationsCalendar)
+
+		mux.With(can(rbac.ViewReservations)).Get("/waitlist", handlers.Repo.AdminWaitlist)
+		mux.With(can(rbac.EditReservations)).Post("/waitlist/{id}/remove", handlers.Repo.AdminRemoveWaitlistEntry)
+		mux.With(can(rbac.EditReservations)).Post("/waitlist/{id}/requeue", handlers.Repo.AdminRequeueWaitlistEntry)
+
+		mux.With(can(rbac.ViewRooms)).Get("/rooms", handlers.Repo.AdminRooms)
+		mux.With(can(rbac.ViewRooms)).Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+		mux.With(can(rbac.EditRooms)).Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+		mux.With(can(rbac.EditRooms)).Post("/rooms/{id}/retire", handlers.Repo.AdminRetireRoom)
+		mux.With(can(rbac.EditRooms)).Post("/rooms/{id}/restore", handlers.Repo.AdminRestoreRoom)
+		mux.With(can(rbac.ViewRooms)).Get("/rooms/{id}/rates", handlers.Repo.AdminRoomRates)
+		mux.With(can(rbac.EditRates)).Post("/rooms/{id}/rates", handlers.Repo.AdminPostSeasonalRate)
+		mux.With(can(rbac.EditRates)).Post("/rooms/{id}/rates/{rate_id}/delete", handlers.Repo.AdminDeleteSeasonalRate)
+		mux.With(can(rbac.EditRates)).Post("/rooms/{id}/discounts", handlers.Repo.AdminPostStayDiscount)
+		mux.With(can(rbac.EditRates)).Post("/rooms/{id}/discounts/{discount_id}/delete", handlers.Repo.AdminDeleteStayDiscount)
+		mux.With(can(rbac.ViewRooms)).Get("/rooms/{id}/calendars", handlers.Repo.AdminRoomCalendars)
+		mux.With(can(rbac.ManageCalendars)).Post("/rooms/{id}/calendars", handlers.Repo.AdminPostCalendarFeed)
+		mux.With(can(rbac.ManageCalendars)).Post("/rooms/{id}/calendars/token", handlers.Repo.AdminResetCalendarToken)
+		mux.With(can(rbac.ManageCalendars)).Post("/rooms/{id}/calendars/{feed_id}/sync", handlers.Repo.AdminSyncCalendarFeed)
+		mux.With(can(rbac.ManageCalendars)).Post("/rooms/{id}/calendars/{feed_id}/delete", handlers.Repo.AdminDeleteCalendarFeed)
+
+		mux.With(can(rbac.ManageGuestMessages)).Get("/guest-messages", handlers.Repo.AdminGuestMessages)
+		mux.With(can(rbac.ManageGuestMessages)).Get("/guest-messages/{id}", handlers.Repo.AdminShowGuestMessage)
+		mux.With(can(rbac.ManageGuestMessages)).Post("/guest-messages/{id}", handlers.Repo.AdminPostGuestMessage)
+		mux.With(can(rbac.ManageGuestMessages)).Post("/guest-messages/{id}/delete", handlers.Repo.AdminDeleteGuestMessage)
+
+		mux.With(can(rbac.ManageAPIKeys)).Get("/api-keys", handlers.Repo.AdminAPIKeys)
+		mux.With(can(rbac.ManageAPIKeys)).Post("/api-keys", handlers.Repo.AdminPostAPIKey)
+		mux.With(can(rbac.ManageAPIKeys)).Post("/api-keys/{id}/revoke", handlers.Repo.AdminRevokeAPIKey)
+
+		mux.With(can(rbac.ViewAuditLog)).Get("/audit-log", handlers.Repo.AdminAuditLog)
+		mux.With(can(rbac.ViewAuditLog)).Get("/audit-log.csv", handlers.Repo.AdminAuditLogCSV)
+
+		// queued mail holds password reset and invitation links, so only owners see it
+		mux.With(can(rbac.ManageMail)).Get("/mail", handlers.Repo.AdminMail)
+		mux.With(can(rbac.ManageMail)).Get("/mail/{id}", handlers.Repo.AdminShowMail)
+		mux.With(can(rbac.ManageMail)).Post("/mail/{id}/resend", handlers.Repo.AdminResendMail)
+
+		mux.With(can(rbac.ViewJobs)).Get("/jobs", handlers.Repo.AdminJobs)
+
+		mux.With(can(rbac.ManageUsers)).Get("/users", handlers.Repo.AdminUsers)
+		mux.With(can(rbac.ManageUsers)).Get("/users/{id}", handlers.Repo.AdminShowUser)
+		mux.With(can(rbac.ManageUsers)).Post("/users/{id}", handlers.Repo.AdminPostUser)
+		mux.With(can(rbac.ManageUsers)).Post("/users/{id}/invite", handlers.Repo.AdminResendInvitation)
+		mux.With(can(rbac.ManageUsers)).Post("/users/{id}/deactivate", handlers.Repo.AdminDeactivateUser)
+		mux.With(can(rbac.ManageUsers)).Post("/users/{id}/activate", handlers.Repo.AdminActivateUser)
+		mux.With(can(rbac.ManageUsers)).Post("/users/{id}/delete", handlers.Repo.AdminDeleteUser)
+		mux.With(can(rbac.ManageUsers)).Post("/users/{id}/reset-two-factor", handlers.Repo.AdminResetTwoFactor)
+		mux.With(can(rbac.ManageUsers)).Post("/users/{id}/unlock", handlers.Repo.AdminUnlockUser)
 	})
 
 	return mux
+}
+
+// can is short for the middleware that checks the permission of an admin route
+func can(p rbac.Permission) func(http.Handler) http.Handler {
+	return handlers.Repo.RequirePermission(p)
 }
\ No newline at end of file
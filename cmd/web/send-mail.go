@@ -1,57 +1,58 @@
 package main
 
 import (
-	"io/ioutil"
-	"strings"
+	"context"
+	"io"
 	"time"
 
-	"github.com/adrialopezbou/bookings-go/internal/models"
-	mail "github.com/xhit/go-simple-mail/v2"
+	"github.com/adrialopezbou/bookings-go/internal/handlers"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/outbox"
 )
 
-func listenForMail() {
-	go func() {
-		for {
-			msg := <- app.MailChan
-			sendMsg(msg)
-		}
-	}()
-}
+// mailPollInterval is how often the mail workers look for mail that is due
+const mailPollInterval = 5 * time.Second
 
-func sendMsg(m models.MailData) {
-	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
-	server.KeepAlive = false
-	server.ConnectTimeout= 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-	
-	client, err := server.Connect()
-	if err != nil {
-		app.ErrorLog.Println(err)
+// newMailer returns the mailer of the configured mail backend
+func newMailer() mailer.Mailer {
+	if app.MailBackend == "file" {
+		return &mailer.File{Dir: app.MailDir, Templates: app.MailTemplates}
 	}
 
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-	if m.Template == "" {
-		email.SetBody(mail.TextHTML, m.Content)
-	} else {
-		data, err := ioutil.ReadFile("./email-templates/" + m.Template)
-		if err != nil {
-			app.ErrorLog.Println(err)
-		}
-
-		mailTemplate := string(data)
-		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
-
+	return &mailer.SMTP{
+		Host:       app.SMTPHost,
+		Port:       app.SMTPPort,
+		Username:   app.SMTPUsername,
+		Password:   app.SMTPPassword,
+		Encryption: app.SMTPEncryption,
+		Templates:  app.MailTemplates,
+		MaxIdle:    app.MailWorkers,
 	}
-	
+}
 
-	err = email.Send(client)
-	if err != nil {
-		app.ErrorLog.Println(err)
-	} else {
-		app.InfoLog.Println("Email send!")
+// listenForMail sends the mail of the outbox with a pool of workers until ctx is done, and then
+// closes the channel it returns once the emails being sent are done
+func listenForMail(ctx context.Context) <-chan struct{} {
+	pool := &outbox.Pool{
+		Store:        handlers.Repo.DB,
+		Mailer:       app.Mailer,
+		Workers:      app.MailWorkers,
+		PollInterval: mailPollInterval,
+		InfoLog:      app.InfoLog,
+		ErrorLog:     app.ErrorLog,
 	}
-}
\ No newline at end of file
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx)
+
+		if closer, ok := app.Mailer.(io.Closer); ok {
+			err := closer.Close()
+			if err != nil {
+				app.ErrorLog.Println("cannot close the mailer:", err)
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,134 @@
+// Package audit describes the entries of the audit log and the changes they record
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin       = "login"
+	ActionLoginFailed = "login-failed"
+	ActionLogout      = "logout"
+	ActionLockout     = "lockout"
+	ActionUnlock      = "unlock"
+	ActionSetPassword = "set-password"
+	ActionRevoke      = "revoke"
+	ActionCreate      = "create"
+	ActionUpdate      = "update"
+	ActionDelete      = "delete"
+	ActionProcess     = "process"
+	ActionCancel      = "cancel"
+	ActionBlock       = "block"
+	ActionUnblock     = "unblock"
+	ActionResend      = "resend"
+
+	// the rates, discounts and calendars of a room are recorded as actions on the room
+	ActionAddRate           = "add-rate"
+	ActionDeleteRate        = "delete-rate"
+	ActionAddDiscount       = "add-discount"
+	ActionDeleteDiscount    = "delete-discount"
+	ActionAddCalendar       = "add-calendar"
+	ActionDeleteCalendar    = "delete-calendar"
+	ActionResetCalendarLink = "reset-calendar-link"
+)
+
+// Entities the audit log records actions on
+const (
+	EntityUser         = "user"
+	EntityReservation  = "reservation"
+	EntityRoom         = "room"
+	EntityAPIKey       = "api-key"
+	EntityMail         = "mail"
+	EntityGuestMessage = "guest-message"
+	EntityWaitlist     = "waitlist-entry"
+)
+
+// Entities lists the entities, for filtering the log
+var Entities = []string{
+	EntityReservation,
+	EntityRoom,
+	EntityUser,
+	EntityAPIKey,
+	EntityMail,
+	EntityGuestMessage,
+	EntityWaitlist,
+}
+
+// hidden are the fields that are never written to the log, because they are secret, change with
+// every update or repeat what other fields say
+var hidden = map[string]bool{
+	"Password":    true,
+	"TOTPSecret":  true,
+	"KeyHash":     true,
+	"TokenHash":   true,
+	"ICalToken":   true,
+	"CreatedAt":   true,
+	"UpdatedAt":   true,
+	"Room":        true,
+	"OfferedRoom": true,
+	"Quote":       true,
+}
+
+// Change is the value of a field before and after an action
+type Change struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff returns the fields that differ between before and after, sorted by name. Either can be nil,
+// for entities that are created or deleted, and otherwise they are structs of the same type
+func Diff(before, after interface{}) ([]Change, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range b {
+		names = append(names, name)
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, name := range names {
+		if hidden[name] || reflect.DeepEqual(b[name], a[name]) {
+			continue
+		}
+		changes = append(changes, Change{Field: name, Before: b[name], After: a[name]})
+	}
+
+	return changes, nil
+}
+
+// fields returns the fields of v as they are encoded in JSON
+func fields(v interface{}) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if v == nil {
+		return m, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
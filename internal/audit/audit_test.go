@@ -0,0 +1,36 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+)
+
+type room struct {
+	Name     string
+	Capacity int
+	Password string
+}
+
+var diffTests = []struct {
+	name     string
+	before   interface{}
+	after    interface{}
+	expected []Change
+}{
+	{"update", room{"Suite", 2, "a"}, room{"Suite", 3, "b"}, []Change{{"Capacity", 2.0, 3.0}}},
+	{"no change", room{"Suite", 2, "a"}, room{"Suite", 2, "a"}, nil},
+	{"create", nil, room{"Suite", 2, "a"}, []Change{{"Capacity", nil, 2.0}, {"Name", nil, "Suite"}}},
+	{"delete", room{"Suite", 2, "a"}, nil, []Change{{"Capacity", 2.0, nil}, {"Name", "Suite", nil}}},
+}
+
+func TestDiff(t *testing.T) {
+	for _, e := range diffTests {
+		changes, err := Diff(e.before, e.after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(changes, e.expected) {
+			t.Errorf("for %s, expected %v but got %v", e.name, e.expected, changes)
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package config
 
 import (
+	"flag"
+	"fmt"
 	"html/template"
 	"log"
+	"strings"
+	"time"
 
-	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/jobs"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/openapi"
+	"github.com/adrialopezbou/bookings-go/internal/payments"
 	"github.com/alexedwards/scs/v2"
 )
 
@@ -16,5 +23,78 @@ type AppConfig struct {
 	ErrorLog      *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	Payments      payments.Gateway
+	Mailer        mailer.Mailer
+	MailTemplates *mailer.Templates
+	// Jobs runs the background jobs, like importing calendars, on their schedules
+	Jobs *jobs.Runner
+	// DepositPercent is the share of the total taken when booking: 0 for none, 100 for full prepayment
+	DepositPercent int
+	// CancellationDays is how many days before arrival guests can still cancel or move their stay online
+	CancellationDays int
+	// BaseURL is the address of the site, for the links sent to guests by mail
+	BaseURL string
+	// LinkSecret signs the links that let guests manage their reservation
+	LinkSecret []byte
+	// OpenAPI checks api requests against the published api document
+	OpenAPI *openapi.Validator
+	// RequireTwoFactor makes every admin user enrol in two-factor authentication before using the admin area
+	RequireTwoFactor bool
+	// Port is the port the web server listens on
+	Port int
+	// DBHost, DBPort, DBName, DBUser, DBPassword and DBSSLMode say how to connect to Postgres
+	DBHost     string
+	DBPort     int
+	DBName     string
+	DBUser     string
+	DBPassword string
+	DBSSLMode  string
+	// MailBackend is how mail is sent: "smtp" through the smtp server, or "file" to .eml files in
+	// MailDir for development
+	MailBackend string
+	MailDir     string
+	// MailTemplatesDir is the directory of the email templates
+	MailTemplatesDir string
+	// SMTPHost, SMTPPort, SMTPUsername and SMTPPassword say where mail is sent. The username and
+	// password are only used when the username is set
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// SMTPEncryption is mailer.EncryptionNone, mailer.EncryptionSTARTTLS or mailer.EncryptionTLS
+	SMTPEncryption string
+	// PaymentsWebhookSecret checks the signature of the webhooks of the payment gateway
+	PaymentsWebhookSecret string
+	// MailWorkers is how many emails of the outbox are sent at the same time
+	MailWorkers int
+	// ShutdownTimeout is how long shutting down waits for the requests in flight, and then for the
+	// background work like the emails being sent
+	ShutdownTimeout time.Duration
+
+	// settings are the flags the settings above were loaded from, and secrets the names of the ones
+	// never printed
+	settings *flag.FlagSet
+	secrets  map[string]bool
+}
+
+// Addr is the address the web server listens on
+func (c *AppConfig) Addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+// DSN is the connection string of the database
+func (c *AppConfig) DSN() string {
+	dsn := fmt.Sprintf("host=%s port=%d dbname=%s user=%s sslmode=%s",
+		dsnValue(c.DBHost), c.DBPort, dsnValue(c.DBName), dsnValue(c.DBUser), dsnValue(c.DBSSLMode))
+	if c.DBPassword != "" {
+		dsn += " password=" + dsnValue(c.DBPassword)
+	}
+	return dsn
+}
+
+// dsnValue quotes s for a connection string
+func dsnValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
 }
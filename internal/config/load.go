@@ -0,0 +1,306 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the names of the environment variables settings are read from, so the
+// db-password setting is read from BOOKINGS_DB_PASSWORD
+const EnvPrefix = "BOOKINGS_"
+
+// Defaults of the secrets, good enough for development and refused in production
+const (
+	defaultLinkSecret            = "change-this-link-secret"
+	defaultPaymentsWebhookSecret = "change-this-webhook-secret"
+)
+
+// minLinkSecretLength is how long the link secret must be in production
+const minLinkSecretLength = 32
+
+// Load fills the settings of c. Each setting starts at its default and is then overridden by the
+// config file, by its environment variable and by its flag in args, in that order. The config file
+// is named by the -config flag or the BOOKINGS_CONFIG variable, and is optional.
+//
+// Secrets can also be read from a file, named by the setting with -file appended, like
+// -db-password-file or BOOKINGS_DB_PASSWORD_FILE, so they stay out of the environment and the
+// process list
+func (c *AppConfig) Load(args []string) error {
+	fs := c.defineSettings()
+
+	// flags are parsed first to find the config file, and again at the end so they win
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	configFile := os.Getenv(EnvPrefix + "CONFIG")
+	if f := fs.Lookup("config"); f.Value.String() != "" {
+		configFile = f.Value.String()
+	}
+	if configFile != "" {
+		err = c.loadFile(configFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || envErr != nil {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			envErr = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), err)
+		}
+	})
+	if envErr != nil {
+		return envErr
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	return c.Validate()
+}
+
+// defineSettings defines a flag for every setting of c, set to its default
+func (c *AppConfig) defineSettings() *flag.FlagSet {
+	fs := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	c.settings = fs
+	c.secrets = make(map[string]bool)
+
+	fs.String("config", "", "read settings from this YAML file")
+
+	fs.IntVar(&c.Port, "port", 8080, "port the web server listens on")
+	fs.StringVar(&c.BaseURL, "base-url", "http://localhost:8080", "address of the site, for the links sent by mail")
+	fs.BoolVar(&c.InProduction, "in-production", false, "run in production, with secure cookies and strict checks of the settings")
+	fs.BoolVar(&c.UseCache, "template-cache", false, "parse the templates once instead of on every request")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "how long shutting down waits for requests in flight, and then for background work")
+
+	fs.StringVar(&c.DBHost, "db-host", "localhost", "database host")
+	fs.IntVar(&c.DBPort, "db-port", 5432, "database port")
+	fs.StringVar(&c.DBName, "db-name", "bookings", "database name")
+	fs.StringVar(&c.DBUser, "db-user", "postgres", "database user")
+	c.secretVar(fs, (*stringValue)(&c.DBPassword), "db-password", "database password")
+	fs.StringVar(&c.DBSSLMode, "db-sslmode", "prefer", "database ssl mode: disable, allow, prefer, require, verify-ca or verify-full")
+
+	fs.StringVar(&c.MailBackend, "mail-backend", "smtp", "how mail is sent: smtp, or file to write it to mail-dir")
+	fs.StringVar(&c.MailDir, "mail-dir", "./mail", "directory the file mail backend writes .eml files to")
+	fs.StringVar(&c.MailTemplatesDir, "mail-templates", "./email-templates", "directory of the email templates")
+	fs.StringVar(&c.SMTPHost, "smtp-host", "localhost", "mail server host")
+	fs.IntVar(&c.SMTPPort, "smtp-port", 1025, "mail server port")
+	fs.IntVar(&c.MailWorkers, "mail-workers", 2, "how many emails are sent at the same time")
+	fs.StringVar(&c.SMTPUsername, "smtp-username", "", "mail server username, if it needs one")
+	c.SMTPPassword = ""
+	c.secretVar(fs, (*stringValue)(&c.SMTPPassword), "smtp-password", "mail server password")
+	fs.StringVar(&c.SMTPEncryption, "smtp-encryption", "none", "mail server encryption: none, starttls or tls")
+
+	fs.IntVar(&c.DepositPercent, "deposit-percent", 30, "share of the total taken when booking: 0 for none, 100 for full prepayment")
+	fs.IntVar(&c.CancellationDays, "cancellation-days", 7, "days before arrival guests can still cancel or change their stay online")
+	fs.BoolVar(&c.RequireTwoFactor, "require-two-factor", false, "make every admin user enrol in two-factor authentication")
+
+	c.LinkSecret = []byte(defaultLinkSecret)
+	c.secretVar(fs, (*bytesValue)(&c.LinkSecret), "link-secret", "secret that signs the links guests manage their reservation with")
+	c.PaymentsWebhookSecret = defaultPaymentsWebhookSecret
+	c.secretVar(fs, (*stringValue)(&c.PaymentsWebhookSecret), "payments-webhook-secret", "secret that signs the webhooks of the payment gateway")
+
+	return fs
+}
+
+// secretVar defines the secret setting name, and name-file to read it from a file
+func (c *AppConfig) secretVar(fs *flag.FlagSet, value flag.Value, name, usage string) {
+	c.secrets[name] = true
+	fs.Var(value, name, usage)
+	fs.Var(&fileValue{value: value}, name+"-file", "read "+name+" from this file")
+}
+
+// loadFile sets the settings in the YAML file path, keyed by the names of their flags
+func (c *AppConfig) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read the config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	err = yaml.Unmarshal(data, &values)
+	if err != nil {
+		return fmt.Errorf("cannot parse the config file %s: %w", path, err)
+	}
+
+	for name, v := range values {
+		f := c.settings.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown setting %s in the config file %s", name, path)
+		}
+
+		var value string
+		switch v := v.(type) {
+		case nil:
+		case map[interface{}]interface{}, []interface{}:
+			return fmt.Errorf("setting %s in the config file %s must be a single value", name, path)
+		default:
+			value = fmt.Sprint(v)
+		}
+
+		err = f.Value.Set(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s in the config file %s: %w", value, name, path, err)
+		}
+	}
+
+	return nil
+}
+
+// envName returns the environment variable the setting name is read from
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Validate checks that the settings of c make sense, and in production that no secret is left at
+// its default
+func (c *AppConfig) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(validPort(c.Port), "port must be between 1 and 65535")
+	check(validPort(c.DBPort), "db-port must be between 1 and 65535")
+	check(validPort(c.SMTPPort), "smtp-port must be between 1 and 65535")
+	check(c.DBHost != "", "db-host is required")
+	check(c.DBName != "", "db-name is required")
+	check(c.DBUser != "", "db-user is required")
+	check(c.SMTPHost != "", "smtp-host is required")
+	switch c.DBSSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		problems = append(problems, fmt.Sprintf("db-sslmode %q is not a valid ssl mode", c.DBSSLMode))
+	}
+	check(c.DepositPercent >= 0 && c.DepositPercent <= 100, "deposit-percent must be between 0 and 100")
+	check(c.CancellationDays >= 0, "cancellation-days can't be negative")
+	check(c.ShutdownTimeout > 0, "shutdown-timeout must be positive")
+	check(c.MailWorkers > 0, "mail-workers must be at least 1")
+	switch c.MailBackend {
+	case "smtp":
+	case "file":
+		check(c.MailDir != "", "mail-dir is required by the file mail backend")
+	default:
+		problems = append(problems, fmt.Sprintf("mail-backend %q is not smtp or file", c.MailBackend))
+	}
+	switch c.SMTPEncryption {
+	case mailer.EncryptionNone, mailer.EncryptionSTARTTLS, mailer.EncryptionTLS:
+	default:
+		problems = append(problems, fmt.Sprintf("smtp-encryption %q is not none, starttls or tls", c.SMTPEncryption))
+	}
+	check(len(c.LinkSecret) > 0, "link-secret is required")
+	check(c.PaymentsWebhookSecret != "", "payments-webhook-secret is required")
+
+	u, err := url.Parse(c.BaseURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "base-url must be an absolute http or https address")
+
+	if c.InProduction {
+		check(err == nil && u.Scheme == "https", "base-url must be https in production")
+		check(string(c.LinkSecret) != defaultLinkSecret && len(c.LinkSecret) >= minLinkSecretLength,
+			"link-secret must be changed and at least %d characters long in production", minLinkSecretLength)
+		check(c.PaymentsWebhookSecret != defaultPaymentsWebhookSecret, "payments-webhook-secret must be changed in production")
+		check(c.DBPassword != "", "db-password is required in production")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validPort reports whether p is a tcp port
+func validPort(p int) bool {
+	return p > 0 && p <= 65535
+}
+
+// Effective returns the settings of c as they were loaded, one per line, with secrets redacted
+func (c *AppConfig) Effective() string {
+	if c.settings == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	c.settings.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if c.secrets[f.Name] && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(&b, "%s=%s\n", f.Name, value)
+	})
+	return b.String()
+}
+
+// stringValue is a string setting defined with flag.Var
+type stringValue string
+
+func (s *stringValue) Set(value string) error {
+	*s = stringValue(value)
+	return nil
+}
+
+func (s *stringValue) String() string {
+	if s == nil {
+		return ""
+	}
+	return string(*s)
+}
+
+// bytesValue is a []byte setting
+type bytesValue []byte
+
+func (b *bytesValue) Set(value string) error {
+	*b = []byte(value)
+	return nil
+}
+
+func (b *bytesValue) String() string {
+	if b == nil {
+		return ""
+	}
+	return string(*b)
+}
+
+// fileValue sets value to the content of the file it is set to, without the trailing newline
+type fileValue struct {
+	value flag.Value
+	path  string
+}
+
+func (f *fileValue) Set(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f.path = path
+	return f.value.Set(strings.TrimRight(string(data), "\r\n"))
+}
+
+func (f *fileValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.path
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to name in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAppConfig_LoadDefaults(t *testing.T) {
+	var c AppConfig
+	err := c.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 8080 || c.DBName != "bookings" || c.SMTPPort != 1025 || c.DepositPercent != 30 || c.CancellationDays != 7 {
+		t.Errorf("expected the defaults but got %+v", c)
+	}
+	if c.Addr() != ":8080" {
+		t.Errorf("expected :8080 but got %s", c.Addr())
+	}
+	if string(c.LinkSecret) != defaultLinkSecret {
+		t.Errorf("expected the default link secret but got %q", c.LinkSecret)
+	}
+	if c.DBPassword != "" {
+		t.Errorf("expected no database password but got %q", c.DBPassword)
+	}
+}
+
+func TestAppConfig_LoadPrecedence(t *testing.T) {
+	configFile := writeFile(t, "config.yml", "port: 9000\ndb-name: from-file\ndb-user: from-file\nsmtp-host: from-file\nrequire-two-factor: true\n")
+	t.Setenv("BOOKINGS_CONFIG", configFile)
+	t.Setenv("BOOKINGS_DB_NAME", "from-env")
+	t.Setenv("BOOKINGS_DB_USER", "from-env")
+
+	var c AppConfig
+	err := c.Load([]string{"-db-user", "from-flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"default", c.DBHost, "localhost"},
+		{"file", c.Port, 9000},
+		{"file bool", c.RequireTwoFactor, true},
+		{"file under env", c.SMTPHost, "from-file"},
+		{"env over file", c.DBName, "from-env"},
+		{"flag over env", c.DBUser, "from-flag"},
+	}
+
+	for _, e := range tests {
+		if e.got != e.expected {
+			t.Errorf("for %s, expected %v but got %v", e.name, e.expected, e.got)
+		}
+	}
+}
+
+func TestAppConfig_LoadSecretFiles(t *testing.T) {
+	passwordFile := writeFile(t, "db-password", "s3cret pass'word\n")
+	linkSecretFile := writeFile(t, "link-secret", "a-link-secret\n")
+	t.Setenv("BOOKINGS_LINK_SECRET_FILE", linkSecretFile)
+
+	var c AppConfig
+	err := c.Load([]string{"-db-password-file", passwordFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.DBPassword != "s3cret pass'word" {
+		t.Errorf("expected the password of the file but got %q", c.DBPassword)
+	}
+	if string(c.LinkSecret) != "a-link-secret" {
+		t.Errorf("expected the link secret of the file but got %q", c.LinkSecret)
+	}
+	if dsn := c.DSN(); !strings.HasSuffix(dsn, `password='s3cret pass\'word'`) {
+		t.Errorf("expected the password to be quoted but got %s", dsn)
+	}
+
+	err = c.Load([]string{"-smtp-password-file", filepath.Join(t.TempDir(), "missing")})
+	if err == nil {
+		t.Error("expected an error for a missing secret file")
+	}
+}
+
+func TestAppConfig_LoadErrors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		args     []string
+		file     string
+		env      map[string]string
+		expected string
+	}{
+		{"unknown flag", []string{"-nope"}, "", nil, "not defined"},
+		{"bad flag", []string{"-port", "x"}, "", nil, "invalid value"},
+		{"unknown file setting", nil, "nope: 1\n", nil, "unknown setting nope"},
+		{"nested file setting", nil, "db-host:\n  name: x\n", nil, "must be a single value"},
+		{"bad file", nil, "port: [", nil, "cannot parse"},
+		{"port", []string{"-port", "70000"}, "", nil, "port must be between 1 and 65535"},
+		{"deposit", []string{"-deposit-percent", "101"}, "", nil, "deposit-percent must be between 0 and 100"},
+		{"shutdown timeout", []string{"-shutdown-timeout", "0s"}, "", nil, "shutdown-timeout must be positive"},
+		{"ssl mode", []string{"-db-sslmode", "maybe"}, "", nil, "db-sslmode"},
+		{"mail backend", []string{"-mail-backend", "pigeon"}, "", nil, "mail-backend"},
+		{"smtp encryption", []string{"-smtp-encryption", "ssl"}, "", nil, "smtp-encryption"},
+		{"base url", []string{"-base-url", "localhost"}, "", nil, "base-url must be an absolute"},
+		{"production secrets", []string{"-in-production", "-base-url", "https://example.com"}, "", nil, "link-secret must be changed"},
+		{"production http", []string{"-in-production"}, "", nil, "base-url must be https in production"},
+		{"production db password", []string{"-in-production"}, "", nil, "db-password is required in production"},
+		// the environment stays set for the rest of the test
+		{"bad env", nil, "", map[string]string{"BOOKINGS_DB_PORT": "x"}, "BOOKINGS_DB_PORT"},
+	}
+
+	for _, e := range tests {
+		args := e.args
+		if e.file != "" {
+			args = append([]string{"-config", writeFile(t, "config.yml", e.file)}, args...)
+		}
+		for k, v := range e.env {
+			t.Setenv(k, v)
+		}
+
+		var c AppConfig
+		err := c.Load(args)
+		if err == nil || !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("for %s, expected an error with %q but got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestAppConfig_Effective(t *testing.T) {
+	var c AppConfig
+	err := c.Load([]string{"-db-password", "hunter2", "-smtp-username", "mailer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	effective := c.Effective()
+	if strings.Contains(effective, "hunter2") || strings.Contains(effective, defaultLinkSecret) {
+		t.Errorf("expected secrets to be redacted but got\n%s", effective)
+	}
+	for _, line := range []string{"db-password=[redacted]", "smtp-password=", "smtp-username=mailer", "port=8080"} {
+		if !strings.Contains(effective, line+"\n") {
+			t.Errorf("expected %s in\n%s", line, effective)
+		}
+	}
+}
@@ -3,11 +3,14 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
 )
 
+var slugRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
 // Form creates a custom form struct, embeds a url.Values object
 type Form struct {
 	url.Values
@@ -56,3 +59,10 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// IsSlug checks that a field is a lowercase url slug, like generals-quarters
+func (f *Form) IsSlug(field string) {
+	if !slugRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use only lowercase letters, numbers and dashes")
+	}
+}
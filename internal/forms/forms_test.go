@@ -131,4 +131,31 @@ func TestForm_IsEmail(t *testing.T) {
 	if !form.Valid() {
 		t.Error("show invalid when email syntax is correct")
 	}
-}
\ No newline at end of file
+}
+
+func TestForm_IsSlug(t *testing.T) {
+	r := httptest.NewRequest("POST", "/whatever", nil)
+
+	postedData := url.Values{}
+	postedData.Add("slug", "Generals Quarters")
+
+	r.PostForm = postedData
+	form := New(r.PostForm)
+
+	form.IsSlug("slug")
+
+	if form.Valid() {
+		t.Error("shows valid when slug has spaces and uppercase letters")
+	}
+
+	postedData.Set("slug", "generals-quarters-2")
+
+	r.PostForm = postedData
+	form = New(r.PostForm)
+
+	form.IsSlug("slug")
+
+	if !form.Valid() {
+		t.Error("shows invalid when slug is correct")
+	}
+}
@@ -0,0 +1,83 @@
+// Package guestmessages describes the emails sent to guests on a schedule, some days before or
+// after their arrival or departure
+package guestmessages
+
+import (
+	"fmt"
+	"time"
+)
+
+// Anchors are the days of a stay messages are scheduled from
+const (
+	AnchorArrival   = "arrival"
+	AnchorDeparture = "departure"
+)
+
+// Anchors lists the anchors, in the order the admin pages show them
+var Anchors = []string{AnchorArrival, AnchorDeparture}
+
+const (
+	// MaxOffsetDays is how many days before or after its anchor a message can be sent
+	MaxOffsetDays = 60
+	// WindowDays is how many days after its send date a message still goes out, so one missed while
+	// the site was down is sent late, but a message added later doesn't go to every past stay
+	WindowDays = 3
+)
+
+// ValidAnchor reports whether anchor is one of the anchors
+func ValidAnchor(anchor string) bool {
+	for _, a := range Anchors {
+		if a == anchor {
+			return true
+		}
+	}
+	return false
+}
+
+// SendDate returns the day a message is sent for a stay from arrival to departure
+func SendDate(anchor string, offsetDays int, arrival, departure time.Time) time.Time {
+	day := arrival
+	if anchor == AnchorDeparture {
+		day = departure
+	}
+	return day.AddDate(0, 0, offsetDays)
+}
+
+// Due reports whether a message for a stay from arrival to departure goes out on today: from its
+// send date until WindowDays after it. A late message isn't sent once the stay has moved on, so a
+// reminder doesn't arrive after the guest did, nor check-in instructions after they left
+func Due(anchor string, offsetDays int, arrival, departure, today time.Time) bool {
+	sendDate := SendDate(anchor, offsetDays, arrival, departure)
+	if today.Before(sendDate) || today.After(sendDate.AddDate(0, 0, WindowDays)) {
+		return false
+	}
+
+	switch {
+	case sendDate.Before(arrival):
+		return today.Before(arrival)
+	case sendDate.Before(departure):
+		return today.Before(departure)
+	}
+	return true
+}
+
+// Describe says when a message is sent, like "7 days before arrival"
+func Describe(anchor string, offsetDays int) string {
+	days := offsetDays
+	if days < 0 {
+		days = -days
+	}
+
+	unit := "days"
+	if days == 1 {
+		unit = "day"
+	}
+
+	switch {
+	case offsetDays < 0:
+		return fmt.Sprintf("%d %s before %s", days, unit, anchor)
+	case offsetDays > 0:
+		return fmt.Sprintf("%d %s after %s", days, unit, anchor)
+	}
+	return "on the day of " + anchor
+}
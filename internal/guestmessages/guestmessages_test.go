@@ -0,0 +1,88 @@
+package guestmessages
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendDate(t *testing.T) {
+	arrival := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	departure := time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name       string
+		anchor     string
+		offsetDays int
+		expected   time.Time
+	}{
+		{"before arrival", AnchorArrival, -7, time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"day of arrival", AnchorArrival, 0, arrival},
+		{"after departure", AnchorDeparture, 1, time.Date(2050, 1, 13, 0, 0, 0, 0, time.UTC)},
+		{"across months", AnchorArrival, -10, time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, e := range tests {
+		if got := SendDate(e.anchor, e.offsetDays, arrival, departure); !got.Equal(e.expected) {
+			t.Errorf("for %s, expected %s but got %s", e.name, e.expected.Format("2006-01-02"), got.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestDue(t *testing.T) {
+	arrival := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	departure := time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name       string
+		anchor     string
+		offsetDays int
+		today      time.Time
+		expected   bool
+	}{
+		{"before the send date", AnchorArrival, -7, time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{"on the send date", AnchorArrival, -7, time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC), true},
+		{"late", AnchorArrival, -7, time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC), true},
+		{"too late", AnchorArrival, -7, time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC), false},
+		{"departure", AnchorDeparture, 1, time.Date(2050, 1, 13, 0, 0, 0, 0, time.UTC), true},
+		{"not yet departed", AnchorDeparture, 1, time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC), false},
+		{"reminder late before arrival", AnchorArrival, -2, time.Date(2050, 1, 9, 0, 0, 0, 0, time.UTC), true},
+		{"reminder late after arrival", AnchorArrival, -2, time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC), false},
+		{"check-in late during the stay", AnchorArrival, 0, time.Date(2050, 1, 11, 0, 0, 0, 0, time.UTC), true},
+		{"check-in late after departure", AnchorArrival, 0, time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, e := range tests {
+		if got := Due(e.anchor, e.offsetDays, arrival, departure, e.today); got != e.expected {
+			t.Errorf("for %s, expected %t but got %t", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	var tests = []struct {
+		anchor     string
+		offsetDays int
+		expected   string
+	}{
+		{AnchorArrival, -7, "7 days before arrival"},
+		{AnchorArrival, -1, "1 day before arrival"},
+		{AnchorArrival, 0, "on the day of arrival"},
+		{AnchorDeparture, 1, "1 day after departure"},
+		{AnchorDeparture, 3, "3 days after departure"},
+	}
+
+	for _, e := range tests {
+		if got := Describe(e.anchor, e.offsetDays); got != e.expected {
+			t.Errorf("for %s %d, expected %q but got %q", e.anchor, e.offsetDays, e.expected, got)
+		}
+	}
+}
+
+func TestValidAnchor(t *testing.T) {
+	if !ValidAnchor(AnchorArrival) || !ValidAnchor(AnchorDeparture) {
+		t.Error("expected the anchors to be valid")
+	}
+	if ValidAnchor("checkout") || ValidAnchor("") {
+		t.Error("expected other anchors not to be valid")
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/rbac"
+)
+
+// LoadUser puts the role of the logged in user in the request context, for RequirePermission and
+// the templates. A user that no longer exists or was deactivated is logged out, and so is a session
+// started before the password last changed. When the site requires two-factor authentication, users
+// that haven't turned it on are sent to enrol
+func (m *Repository) LoadUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := m.DB.GetUserById(m.App.Session.GetInt(r.Context(), "user_id"))
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !user.Active) {
+			_ = m.App.Session.Destroy(r.Context())
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if m.App.Session.GetString(r.Context(), "password_stamp") != passwordStamp(user) {
+			_ = m.App.Session.Destroy(r.Context())
+			m.App.Session.Put(r.Context(), "warning", "Your password was changed, log in again")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		// when the site requires it, users can't go anywhere else until they enrol
+		if m.App.RequireTwoFactor && user.TOTPSecret == "" && !strings.HasPrefix(r.URL.Path, "/admin/two-factor") {
+			m.App.Session.Put(r.Context(), "warning", "Turn on two-factor authentication to continue")
+			http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+			return
+		}
+
+		ctx := rbac.WithRole(r.Context(), rbac.Role(user.AccessLevel))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequirePermission only lets through users whose role grants p. It needs LoadUser to run first
+func (m *Repository) RequirePermission(p rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := rbac.RoleFromContext(r.Context())
+			if !role.Can(p) {
+				m.App.InfoLog.Printf("user %d with role %s was denied %s %s", m.App.Session.GetInt(r.Context(), "user_id"),
+					role, r.Method, r.URL.Path)
+				m.App.Session.Put(r.Context(), "error", "You are not allowed to do that")
+				http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
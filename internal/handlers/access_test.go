@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adrialopezbou/bookings-go/internal/rbac"
+)
+
+var loadUserTests = []struct {
+	name               string
+	userID             int
+	passwordStamp      string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedRole       rbac.Role
+}{
+	{"owner", 1, "", http.StatusOK, "", rbac.Owner},
+	{"database error", 2, "", http.StatusInternalServerError, "", 0},
+	{"deleted user", 3, "", http.StatusSeeOther, "/user/login", 0},
+	{"inactive user", 4, "", http.StatusSeeOther, "/user/login", 0},
+	{"session after password change", 8, "2022-02-01T00:00:00Z", http.StatusOK, "", rbac.Owner},
+	{"session before password change", 8, "", http.StatusSeeOther, "/user/login", 0},
+}
+
+func TestRepository_LoadUser(t *testing.T) {
+	for _, e := range loadUserTests {
+		req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+		session.Put(ctx, "password_stamp", e.passwordStamp)
+
+		var role rbac.Role
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role = rbac.RoleFromContext(r.Context())
+		})
+
+		rr := httptest.NewRecorder()
+		Repo.LoadUser(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if role != e.expectedRole {
+			t.Errorf("for %s, expected role %s but got %s", e.name, e.expectedRole, role)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+	}
+}
+
+var requirePermissionTests = []struct {
+	name               string
+	role               rbac.Role
+	permission         rbac.Permission
+	expectedStatusCode int
+}{
+	{"read only views", rbac.ReadOnly, rbac.ViewReservations, http.StatusOK},
+	{"read only edits", rbac.ReadOnly, rbac.EditReservations, http.StatusSeeOther},
+	{"front desk blocks dates", rbac.FrontDesk, rbac.BlockDates, http.StatusOK},
+	{"front desk deletes", rbac.FrontDesk, rbac.DeleteReservations, http.StatusSeeOther},
+	{"manager edits rates", rbac.Manager, rbac.EditRates, http.StatusOK},
+	{"manager manages api keys", rbac.Manager, rbac.ManageAPIKeys, http.StatusSeeOther},
+	{"owner manages api keys", rbac.Owner, rbac.ManageAPIKeys, http.StatusOK},
+	{"no role", 0, rbac.ViewRooms, http.StatusSeeOther},
+}
+
+func TestRepository_RequirePermission(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, e := range requirePermissionTests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1", nil)
+		ctx := rbac.WithRole(getCtx(req), e.role)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		Repo.RequirePermission(e.permission)(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Code == http.StatusSeeOther {
+			location, _ := rr.Result().Location()
+			if location.String() != "/admin/dashboard" {
+				t.Errorf("for %s, expected location /admin/dashboard but got %s", e.name, location.String())
+			}
+			if session.GetString(ctx, "error") == "" {
+				t.Errorf("for %s, expected an error message in the session", e.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/forms"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+)
+
+// passwordStamp identifies the password of a user in their sessions. It changes with the password,
+// which ends the sessions started before
+func passwordStamp(user models.User) string {
+	if user.PasswordChangedAt.IsZero() {
+		return ""
+	}
+	return user.PasswordChangedAt.UTC().Format(time.RFC3339Nano)
+}
+
+// renewLogin logs the user in to the session of r with a new session token
+func (m *Repository) renewLogin(r *http.Request, id int) error {
+	user, err := m.DB.GetUserById(id)
+	if err != nil {
+		return err
+	}
+
+	err = m.App.Session.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	m.App.Session.Put(r.Context(), "password_stamp", passwordStamp(user))
+	return nil
+}
+
+// ShowForgotPassword shows the form to ask for a password reset link
+func (m *Repository) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword mails a password reset link. The answer is the same whether or not the email
+// belongs to a user, so the form can't be used to find out who has an account
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	// links only go to verified addresses, which are known to belong to the user
+	user, err := m.DB.GetUserByEmail(r.Form.Get("email"))
+	if err == nil && user.Active && !user.EmailVerifiedAt.IsZero() {
+		err = m.sendPasswordReset(user)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	} else if err != nil {
+		m.App.InfoLog.Println("no password reset link sent:", err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If the email belongs to an account, a link to reset the password is on its way")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// sendPasswordReset mails a user a link to choose a new password
+func (m *Repository) sendPasswordReset(user models.User) error {
+	link, err := m.newUserLink(user, tokenPasswordReset, passwordResetTTL, "/user/reset-password")
+	if err != nil {
+		return err
+	}
+
+	m.sendMail(user.Email, "Password reset", mailer.TemplatePasswordReset, models.UserLinkMail{
+		FirstName: user.FirstName,
+		Link:      link,
+		ValidFor:  passwordResetTTL,
+	})
+	return nil
+}
+
+// ShowResetPassword shows the form to choose a new password
+func (m *Repository) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	_, token, ok := m.userFromTokenURL(w, r, tokenPasswordReset)
+	if !ok {
+		return
+	}
+
+	m.renderResetPassword(w, r, token, forms.New(nil))
+}
+
+func (m *Repository) renderResetPassword(w http.ResponseWriter, r *http.Request, token string, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// PostResetPassword stores the new password. Every session of the user ends
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, token, ok := m.userFromTokenURL(w, r, tokenPasswordReset)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	checkNewPassword(form)
+	if !form.Valid() {
+		m.renderResetPassword(w, r, token, form)
+		return
+	}
+
+	err = m.DB.UpdatePassword(user.ID, r.Form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the link is single use
+	err = m.DB.DeleteUserTokens(user.ID, tokenPasswordReset)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, models.AuditEntry{UserID: user.ID, Actor: user.Email, Action: audit.ActionSetPassword,
+		Entity: audit.EntityUser, EntityID: user.ID}, nil, nil)
+
+	_ = m.App.Session.Destroy(r.Context())
+	m.App.Session.Put(r.Context(), "flash", "Your password is changed, you can log in now")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// sendEmailVerification mails a user a link to verify their email
+func (m *Repository) sendEmailVerification(user models.User) error {
+	link, err := m.newUserLink(user, tokenEmailVerification, emailVerificationTTL, "/user/verify-email")
+	if err != nil {
+		return err
+	}
+
+	m.sendMail(user.Email, "Verify your email", mailer.TemplateEmailVerification, models.UserLinkMail{
+		FirstName: user.FirstName,
+		Link:      link,
+		ValidFor:  emailVerificationTTL,
+	})
+	return nil
+}
+
+// VerifyEmailLink marks the email of a user as verified when they follow the link mailed to it
+func (m *Repository) VerifyEmailLink(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := m.userFromTokenURL(w, r, tokenEmailVerification)
+	if !ok {
+		return
+	}
+
+	err := m.DB.VerifyEmail(user.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the link is single use
+	err = m.DB.DeleteUserTokens(user.ID, tokenEmailVerification)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your email is verified")
+	if helpers.IsAuthenticated(r) {
+		http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminResendVerification mails the logged in user a new link to verify their email
+func (m *Repository) AdminResendVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserById(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !user.EmailVerifiedAt.IsZero() {
+		m.App.Session.Put(r.Context(), "flash", "Your email is already verified")
+		http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+		return
+	}
+
+	err = m.sendEmailVerification(user)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "A link to verify your email is on its way")
+	http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var newPasswordForm = url.Values{
+	"password":         {"long enough"},
+	"confirm_password": {"long enough"},
+}
+
+var accountTests = []struct {
+	name               string
+	url                string
+	method             string
+	postedData         url.Values
+	userID             int
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"login", "/user/login", "POST", url.Values{"email": {"admin@here.com"}, "password": {"secret"}}, 0, (*Repository).PostShowLogin, http.StatusSeeOther, "/"},
+	{"login wrong password", "/user/login", "POST", url.Values{"email": {"admin@here.com"}, "password": {"wrong"}}, 0, (*Repository).PostShowLogin, http.StatusSeeOther, "/user/login"},
+
+	{"forgot password form", "/user/forgot-password", "GET", nil, 0, (*Repository).ShowForgotPassword, http.StatusOK, ""},
+	{"forgot password", "/user/forgot-password", "POST", url.Values{"email": {"taken@here.com"}}, 0, (*Repository).PostForgotPassword, http.StatusSeeOther, "/user/login"},
+	{"forgot password unknown email", "/user/forgot-password", "POST", url.Values{"email": {"nobody@here.com"}}, 0, (*Repository).PostForgotPassword, http.StatusSeeOther, "/user/login"},
+	{"forgot password unverified email", "/user/forgot-password", "POST", url.Values{"email": {"unverified@here.com"}}, 0, (*Repository).PostForgotPassword, http.StatusSeeOther, "/user/login"},
+	{"forgot password invalid email", "/user/forgot-password", "POST", url.Values{"email": {"nope"}}, 0, (*Repository).PostForgotPassword, http.StatusOK, ""},
+
+	{"reset password form", "/user/reset-password/reset-token", "GET", nil, 0, (*Repository).ShowResetPassword, http.StatusOK, ""},
+	{"reset password unknown link", "/user/reset-password/nope", "GET", nil, 0, (*Repository).ShowResetPassword, http.StatusSeeOther, "/user/login"},
+	{"reset password with invitation", "/user/reset-password/invite-token", "GET", nil, 0, (*Repository).ShowResetPassword, http.StatusSeeOther, "/user/login"},
+	{"reset password", "/user/reset-password/reset-token", "POST", newPasswordForm, 0, (*Repository).PostResetPassword, http.StatusSeeOther, "/user/login"},
+	{"reset password mismatch", "/user/reset-password/reset-token", "POST", url.Values{"password": {"long enough"}, "confirm_password": {"other"}}, 0, (*Repository).PostResetPassword, http.StatusOK, ""},
+
+	{"verify email", "/user/verify-email/verify-token", "GET", nil, 0, (*Repository).VerifyEmailLink, http.StatusSeeOther, "/user/login"},
+	{"verify email logged in", "/user/verify-email/verify-token", "GET", nil, 1, (*Repository).VerifyEmailLink, http.StatusSeeOther, "/admin/profile"},
+	{"verify email unknown link", "/user/verify-email/nope", "GET", nil, 0, (*Repository).VerifyEmailLink, http.StatusSeeOther, "/user/login"},
+	{"resend verification", "/admin/profile/verify-email", "POST", url.Values{}, 9, (*Repository).AdminResendVerification, http.StatusSeeOther, "/admin/profile"},
+	{"resend verification when verified", "/admin/profile/verify-email", "POST", url.Values{}, 1, (*Repository).AdminResendVerification, http.StatusSeeOther, "/admin/profile"},
+	{"resend verification fails", "/admin/profile/verify-email", "POST", url.Values{}, 2, (*Repository).AdminResendVerification, http.StatusInternalServerError, ""},
+}
+
+func TestRepository_Account(t *testing.T) {
+	for _, e := range accountTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.userID > 0 {
+			session.Put(ctx, "user_id", e.userID)
+		}
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+
+		if e.name == "reset password" && session.Exists(ctx, "user_id") {
+			t.Errorf("for %s, expected the session to end", e.name)
+		}
+	}
+}
+
+func TestPasswordStamp(t *testing.T) {
+	user, _ := Repo.DB.GetUserById(1)
+	if passwordStamp(user) != "" {
+		t.Errorf("expected no stamp for a password never changed but got %s", passwordStamp(user))
+	}
+
+	user, _ = Repo.DB.GetUserById(8)
+	if passwordStamp(user) != "2022-02-01T00:00:00Z" {
+		t.Errorf("expected the stamp of the last change but got %s", passwordStamp(user))
+	}
+}
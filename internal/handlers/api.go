@@ -0,0 +1,707 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/forms"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/openapi"
+	"github.com/adrialopezbou/bookings-go/internal/payments"
+	"github.com/adrialopezbou/bookings-go/internal/pricing"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+	"github.com/adrialopezbou/bookings-go/internal/repository"
+)
+
+// apiDateLayout is the ISO 8601 date format used by the json api
+const apiDateLayout = "2006-01-02"
+
+// maxAPIBodySize limits the size of api request bodies
+const maxAPIBodySize = 1 << 20
+
+// API error codes
+const (
+	apiCodeInvalidRequest = "invalid_request"
+	apiCodeUnauthorized   = "unauthorized"
+	apiCodeNotFound       = "not_found"
+	apiCodeNotAllowed     = "method_not_allowed"
+	apiCodeUnavailable    = "room_unavailable"
+	apiCodeConflict       = "conflict"
+	apiCodePayment        = "payment_failed"
+	apiCodeInternal       = "internal_error"
+)
+
+// apiError is the body of every error response of the api
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+// apiErrorDetail tells the client what went wrong. Fields holds the problem with each invalid field
+type apiErrorDetail struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// apiRoom is a room as returned by the api
+type apiRoom struct {
+	ID            int            `json:"id"`
+	Name          string         `json:"name"`
+	Slug          string         `json:"slug"`
+	Description   string         `json:"description"`
+	Capacity      int            `json:"capacity"`
+	Amenities     []string       `json:"amenities"`
+	Images        []string       `json:"images"`
+	BaseRate      int            `json:"base_rate"`
+	WeekendUplift int            `json:"weekend_uplift"`
+	Currency      string         `json:"currency"`
+	Quote         *pricing.Quote `json:"quote,omitempty"`
+}
+
+// apiReservation is a reservation as returned by the api
+type apiReservation struct {
+	ID          int            `json:"id"`
+	Status      string         `json:"status"`
+	RoomID      int            `json:"room_id"`
+	RoomName    string         `json:"room_name"`
+	StartDate   string         `json:"start_date"`
+	EndDate     string         `json:"end_date"`
+	FirstName   string         `json:"first_name"`
+	LastName    string         `json:"last_name"`
+	Email       string         `json:"email"`
+	Phone       string         `json:"phone"`
+	Quote       *pricing.Quote `json:"quote,omitempty"`
+	AmountPaid  int            `json:"amount_paid"`
+	CreatedAt   time.Time      `json:"created_at"`
+	CancelledAt *time.Time     `json:"cancelled_at,omitempty"`
+}
+
+// apiReservationRequest is the body of a request to create a reservation
+type apiReservationRequest struct {
+	RoomID       int    `json:"room_id"`
+	StartDate    string `json:"start_date"`
+	EndDate      string `json:"end_date"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Email        string `json:"email"`
+	Phone        string `json:"phone"`
+	PaymentToken string `json:"payment_token"`
+}
+
+// writeJSON sends v as a json response with the given status
+func (m *Repository) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		status = http.StatusInternalServerError
+		out = []byte(`{"error": {"code": "internal_error", "message": "Internal server error"}}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// writeAPIError sends an error response
+func (m *Repository) writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	m.writeJSON(w, status, apiError{
+		Error: apiErrorDetail{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
+// writeAPIServerError logs err and sends an internal error response, without details
+func (m *Repository) writeAPIServerError(w http.ResponseWriter, err error) {
+	m.App.ErrorLog.Println(err)
+	m.writeAPIError(w, http.StatusInternalServerError, apiCodeInternal, "Internal server error")
+}
+
+// hashToken returns the hash api keys and user tokens are stored and looked up by
+func hashToken(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyFromRequest reads the api key from the Authorization: Bearer or the X-API-Key header
+func apiKeyFromRequest(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// requestAPIKey returns the api key an api request was made with
+func requestAPIKey(r *http.Request) models.APIKey {
+	apiKey, _ := r.Context().Value(apiKeyContextKey{}).(models.APIKey)
+	return apiKey
+}
+
+// RequireAPIKey only lets through api requests that carry a valid, unrevoked api key
+func (m *Repository) RequireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyFromRequest(r)
+		if key == "" {
+			m.writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "An api key is required")
+			return
+		}
+
+		apiKey, err := m.DB.GetAPIKeyByHash(hashToken(key))
+		if err == sql.ErrNoRows || (err == nil && apiKey.Revoked) {
+			m.writeAPIError(w, http.StatusUnauthorized, apiCodeUnauthorized, "The api key is not valid")
+			return
+		} else if err != nil {
+			m.writeAPIServerError(w, err)
+			return
+		}
+
+		err = m.DB.UpdateAPIKeyUsed(apiKey.ID)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+
+		ctx := context.WithValue(r.Context(), apiKeyContextKey{}, apiKey)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ValidateAPIRequest rejects api requests that don't match the api document before they reach the handlers.
+// Requests the document doesn't describe are let through, to be answered as not found
+func (m *Repository) ValidateAPIRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodySize)
+		}
+
+		err := m.App.OpenAPI.ValidateRequest(r)
+
+		var reqErr *openapi.RequestError
+		if errors.As(err, &reqErr) {
+			if reqErr.Message != "" {
+				m.writeAPIError(w, http.StatusBadRequest, apiCodeInvalidRequest, reqErr.Message)
+			} else {
+				m.writeAPIInvalid(w, reqErr.Fields)
+			}
+			return
+		} else if err != nil && !errors.Is(err, openapi.ErrNoRoute) {
+			m.writeAPIServerError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OpenAPISpec serves the OpenAPI document of the api
+func (m *Repository) OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openapi.Spec)
+}
+
+// APINotFound answers api requests for unknown paths
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	m.writeAPIError(w, http.StatusNotFound, apiCodeNotFound, "Not found")
+}
+
+// APIMethodNotAllowed answers api requests with a method the path doesn't support
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	m.writeAPIError(w, http.StatusMethodNotAllowed, apiCodeNotAllowed, "Method not allowed")
+}
+
+func newAPIRoom(room models.Room) apiRoom {
+	out := apiRoom{
+		ID:            room.ID,
+		Name:          room.RoomName,
+		Slug:          room.Slug,
+		Description:   room.Description,
+		Capacity:      room.Capacity,
+		Amenities:     room.Amenities,
+		Images:        room.Images,
+		BaseRate:      room.BaseRate,
+		WeekendUplift: room.WeekendUplift,
+		Currency:      pricing.Currency,
+	}
+
+	// lists are never null in the api
+	if out.Amenities == nil {
+		out.Amenities = []string{}
+	}
+	if out.Images == nil {
+		out.Images = []string{}
+	}
+
+	return out
+}
+
+// APIRooms lists the rooms offered to guests
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.ActiveRooms()
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+
+	out := struct {
+		Rooms []apiRoom `json:"rooms"`
+	}{
+		Rooms: []apiRoom{},
+	}
+
+	for _, room := range rooms {
+		out.Rooms = append(out.Rooms, newAPIRoom(room))
+	}
+
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+// parseAPIStay reads and checks the dates of a stay
+func parseAPIStay(start, end string, fields map[string]string) (time.Time, time.Time) {
+	startDate, err := time.Parse(apiDateLayout, start)
+	if err != nil {
+		fields["start_date"] = "Use a date like 2050-01-31"
+	}
+
+	endDate, err := time.Parse(apiDateLayout, end)
+	if err != nil {
+		fields["end_date"] = "Use a date like 2050-02-01"
+	} else if fields["start_date"] == "" && !endDate.After(startDate) {
+		fields["end_date"] = "The departure must be after the arrival"
+	}
+
+	return startDate, endDate
+}
+
+// writeAPIInvalid sends the problems found with the fields of a request
+func (m *Repository) writeAPIInvalid(w http.ResponseWriter, fields map[string]string) {
+	m.writeJSON(w, http.StatusUnprocessableEntity, apiError{
+		Error: apiErrorDetail{
+			Code:    apiCodeInvalidRequest,
+			Message: "Some fields are not valid",
+			Fields:  fields,
+		},
+	})
+}
+
+// APIAvailability lists the rooms that are free for a stay, with the price of the stay in each.
+// The url is /api/v1/availability?start_date=2050-01-01&end_date=2050-01-03
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	fields := make(map[string]string)
+	startDate, endDate := parseAPIStay(r.URL.Query().Get("start_date"), r.URL.Query().Get("end_date"), fields)
+	if len(fields) > 0 {
+		m.writeAPIInvalid(w, fields)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+
+	free := make(map[int]bool)
+	for _, room := range available {
+		free[room.ID] = true
+	}
+
+	rooms, err := m.DB.ActiveRooms()
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+
+	out := struct {
+		StartDate string    `json:"start_date"`
+		EndDate   string    `json:"end_date"`
+		Rooms     []apiRoom `json:"rooms"`
+	}{
+		StartDate: startDate.Format(apiDateLayout),
+		EndDate:   endDate.Format(apiDateLayout),
+		Rooms:     []apiRoom{},
+	}
+
+	for _, room := range rooms {
+		if !free[room.ID] {
+			continue
+		}
+
+		quote, err := m.quoteStay(room, startDate, endDate)
+		if err != nil {
+			m.writeAPIServerError(w, err)
+			return
+		}
+
+		x := newAPIRoom(room)
+		x.Quote = &quote
+		out.Rooms = append(out.Rooms, x)
+	}
+
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+// newAPIReservation converts a reservation for the api, adding what its guest has paid
+func (m *Repository) newAPIReservation(res models.Reservation) (apiReservation, error) {
+	out := apiReservation{
+		ID:        res.ID,
+		Status:    "confirmed",
+		RoomID:    res.RoomID,
+		RoomName:  res.Room.RoomName,
+		StartDate: res.StartDate.Format(apiDateLayout),
+		EndDate:   res.EndDate.Format(apiDateLayout),
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		CreatedAt: res.CreatedAt,
+	}
+
+	if len(res.Quote.Nights) > 0 {
+		quote := res.Quote
+		out.Quote = &quote
+	}
+
+	if !res.CancelledAt.IsZero() {
+		cancelledAt := res.CancelledAt
+		out.Status = "cancelled"
+		out.CancelledAt = &cancelledAt
+	}
+
+	reservationPayments, err := m.DB.GetPaymentsForReservation(res.ID)
+	if err != nil {
+		return out, err
+	}
+
+	for _, p := range reservationPayments {
+		if p.Status == payments.StatusCaptured {
+			out.AmountPaid += p.Amount
+		}
+	}
+
+	return out, nil
+}
+
+// APIPostReservation books a room. When a deposit is due, the request must carry a payment token
+func (m *Repository) APIPostReservation(w http.ResponseWriter, r *http.Request) {
+	var req apiReservationRequest
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&req)
+	if err != nil {
+		m.writeAPIError(w, http.StatusBadRequest, apiCodeInvalidRequest, fmt.Sprintf("The body is not a valid reservation: %s", err))
+		return
+	}
+
+	// guest details are checked like the reservation form does
+	form := forms.New(url.Values{
+		"first_name": {req.FirstName},
+		"last_name":  {req.LastName},
+		"email":      {req.Email},
+	})
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	fields := make(map[string]string)
+	for field := range form.Errors {
+		fields[field] = form.Errors.Get(field)
+	}
+
+	startDate, endDate := parseAPIStay(req.StartDate, req.EndDate, fields)
+
+	if req.RoomID <= 0 {
+		fields["room_id"] = "A room is required"
+	}
+
+	if len(fields) > 0 {
+		m.writeAPIInvalid(w, fields)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(req.RoomID)
+	if err == sql.ErrNoRows || (err == nil && !room.Active) {
+		m.writeAPIInvalid(w, map[string]string{"room_id": "There is no such room"})
+		return
+	} else if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesAndRoomId(startDate, endDate, room.ID)
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+	if !available {
+		m.writeAPIError(w, http.StatusConflict, apiCodeUnavailable, "The room is not available for these dates")
+		return
+	}
+
+	quote, err := m.quoteStay(room, startDate, endDate)
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+
+	res := models.Reservation{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Phone:     req.Phone,
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    room.ID,
+		Room:      room,
+		Quote:     quote,
+		APIKeyID:  requestAPIKey(r).ID,
+	}
+
+	amountDue := payments.AmountDue(quote.Total, m.App.DepositPercent)
+
+	var auth payments.Authorization
+	if amountDue > 0 {
+		auth, err = m.App.Payments.Authorize(payments.AuthorizeRequest{
+			Amount:      amountDue,
+			Currency:    quote.Currency,
+			Token:       req.PaymentToken,
+			Description: fmt.Sprintf("%s from %s to %s", room.RoomName, req.StartDate, req.EndDate),
+			Email:       req.Email,
+		})
+		if err == payments.ErrDeclined {
+			m.writeAPIError(w, http.StatusPaymentRequired, apiCodePayment,
+				fmt.Sprintf("A payment of %s %s is required and the card was declined", pricing.FormatAmount(amountDue), quote.Currency))
+			return
+		} else if err != nil {
+			m.App.ErrorLog.Println(err)
+			m.writeAPIError(w, http.StatusPaymentRequired, apiCodePayment, "The payment could not be processed")
+			return
+		}
+	}
+
+	payment, err := m.takePayment(quote.Currency, auth)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.writeAPIError(w, http.StatusPaymentRequired, apiCodePayment, "The payment could not be taken, the room has not been booked")
+		return
+	}
+
+	// another guest can book the room between the check above and now
+	res.ID, err = m.DB.BookReservation(res, 0, payment, m.reservationMails)
+	if err != nil {
+		m.returnPayment(payment)
+	}
+	if errors.Is(err, repository.ErrRoomTaken) {
+		m.writeAPIError(w, http.StatusConflict, apiCodeUnavailable, "The room was just booked for these dates")
+		return
+	} else if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionCreate, audit.EntityReservation, res.ID, nil, res)
+
+	res.CreatedAt = time.Now()
+	out, err := m.newAPIReservation(res)
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+	out.AmountPaid = payment.Amount
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/reservations/%d", res.ID))
+	m.writeJSON(w, http.StatusCreated, out)
+}
+
+// apiReservationFromURL loads the reservation of a url like /api/v1/reservations/{id},
+// sending the error response when it can't. An api key only sees the reservations it made,
+// the others are answered as missing
+func (m *Repository) apiReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 5 {
+		m.APINotFound(w, r)
+		return models.Reservation{}, false
+	}
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		m.APINotFound(w, r)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && res.APIKeyID != requestAPIKey(r).ID) {
+		m.writeAPIError(w, http.StatusNotFound, apiCodeNotFound, "There is no such reservation")
+		return res, false
+	} else if err != nil {
+		m.writeAPIServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// APIReservation returns one reservation
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	out, err := m.newAPIReservation(res)
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+// APICancelReservation cancels a reservation, freeing its nights and refunding what the guest paid
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if !res.CancelledAt.IsZero() {
+		m.writeAPIError(w, http.StatusConflict, apiCodeConflict, "The reservation is already cancelled")
+		return
+	}
+
+	err := m.DB.CancelReservation(res.ID, m.cancellationMails(res))
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+	before := res
+	res.CancelledAt = time.Now()
+	m.audit(r, audit.ActionCancel, audit.EntityReservation, res.ID, before, res)
+
+	err = m.refundReservation(res.ID)
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+
+	out, err := m.newAPIReservation(res)
+	if err != nil {
+		m.writeAPIServerError(w, err)
+		return
+	}
+
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+// newAPIKey returns a new random api key
+func newAPIKey() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "bk_" + hex.EncodeToString(b), nil
+}
+
+// AdminAPIKeys lists the api keys
+func (m *Repository) AdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	m.renderAPIKeys(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderAPIKeys(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	keys, err := m.DB.AllAPIKeys()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["keys"] = keys
+
+	// a new key is only ever shown once
+	stringMap := make(map[string]string)
+	stringMap["new_key"] = m.App.Session.PopString(r.Context(), "new_api_key")
+
+	render.Template(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// AdminPostAPIKey creates an api key
+func (m *Repository) AdminPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	if !form.Valid() {
+		m.renderAPIKeys(w, r, form)
+		return
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	apiKey := models.APIKey{
+		Name:    r.Form.Get("name"),
+		Prefix:  key[:7],
+		KeyHash: hashToken(key),
+	}
+	err = m.DB.InsertAPIKey(apiKey)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionCreate, audit.EntityAPIKey, 0, nil, apiKey)
+
+	m.App.Session.Put(r.Context(), "new_api_key", key)
+	m.App.Session.Put(r.Context(), "flash", "Api key created")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminRevokeAPIKey stops an api key from being accepted
+func (m *Repository) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	// url is /admin/api-keys/{id}/revoke
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.RevokeAPIKey(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionRevoke, audit.EntityAPIKey, id, nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Api key revoked")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/openapi"
+	"github.com/adrialopezbou/bookings-go/internal/payments"
+	"github.com/go-chi/chi"
+)
+
+const validReservationBody = `{"room_id": 1, "start_date": "2051-01-01", "end_date": "2051-01-03",
+	"first_name": "adria", "last_name": "lopez", "email": "adria@lopez.es", "phone": "66582"}`
+
+var apiTests = []struct {
+	name               string
+	method             string
+	url                string
+	key                string
+	body               string
+	expectedStatusCode int
+	expectedCode       string
+}{
+	{"no key", "GET", "/api/v1/rooms", "", "", http.StatusUnauthorized, apiCodeUnauthorized},
+	{"unknown key", "GET", "/api/v1/rooms", "guess", "", http.StatusUnauthorized, apiCodeUnauthorized},
+	{"revoked key", "GET", "/api/v1/rooms", "revoked-api-key", "", http.StatusUnauthorized, apiCodeUnauthorized},
+	{"rooms", "GET", "/api/v1/rooms", "test-api-key", "", http.StatusOK, ""},
+	{"api document", "GET", "/api/openapi.json", "", "", http.StatusOK, ""},
+	{"unknown path", "GET", "/api/v1/guests", "test-api-key", "", http.StatusNotFound, apiCodeNotFound},
+	{"wrong method", "PUT", "/api/v1/rooms", "test-api-key", "", http.StatusMethodNotAllowed, apiCodeNotAllowed},
+	{"availability", "GET", "/api/v1/availability?start_date=2051-01-01&end_date=2051-01-03", "test-api-key", "", http.StatusOK, ""},
+	{"availability without dates", "GET", "/api/v1/availability", "test-api-key", "", http.StatusUnprocessableEntity, apiCodeInvalidRequest},
+	{"availability backwards", "GET", "/api/v1/availability?start_date=2051-01-03&end_date=2051-01-01", "test-api-key", "", http.StatusUnprocessableEntity, apiCodeInvalidRequest},
+	{"availability european dates", "GET", "/api/v1/availability?start_date=01-01-2051&end_date=03-01-2051", "test-api-key", "", http.StatusUnprocessableEntity, apiCodeInvalidRequest},
+	{"reserve", "POST", "/api/v1/reservations", "test-api-key", validReservationBody, http.StatusCreated, ""},
+	{"reserve booked room", "POST", "/api/v1/reservations", "test-api-key",
+		strings.Replace(validReservationBody, "2051-01-0", "2050-01-0", 2), http.StatusConflict, apiCodeUnavailable},
+	{"reserve invalid guest", "POST", "/api/v1/reservations", "test-api-key",
+		strings.Replace(validReservationBody, "adria@lopez.es", "adria", 1), http.StatusUnprocessableEntity, apiCodeInvalidRequest},
+	{"reserve without room", "POST", "/api/v1/reservations", "test-api-key",
+		strings.Replace(validReservationBody, `"room_id": 1`, `"room_id": 0`, 1), http.StatusUnprocessableEntity, apiCodeInvalidRequest},
+	{"reserve unknown field", "POST", "/api/v1/reservations", "test-api-key",
+		strings.Replace(validReservationBody, "phone", "mobile", 1), http.StatusBadRequest, apiCodeInvalidRequest},
+	{"reserve form body", "POST", "/api/v1/reservations", "test-api-key", "room_id=1", http.StatusBadRequest, apiCodeInvalidRequest},
+	{"reserve without body", "POST", "/api/v1/reservations", "test-api-key", "", http.StatusBadRequest, apiCodeInvalidRequest},
+	{"reserve room as text", "POST", "/api/v1/reservations", "test-api-key",
+		strings.Replace(validReservationBody, `"room_id": 1`, `"room_id": "1"`, 1), http.StatusUnprocessableEntity, apiCodeInvalidRequest},
+	{"reserve short name", "POST", "/api/v1/reservations", "test-api-key",
+		strings.Replace(validReservationBody, `"adria"`, `"a"`, 1), http.StatusUnprocessableEntity, apiCodeInvalidRequest},
+	{"reserve backwards", "POST", "/api/v1/reservations", "test-api-key",
+		strings.Replace(validReservationBody, "2051-01-03", "2050-12-31", 1), http.StatusUnprocessableEntity, apiCodeInvalidRequest},
+	{"reserve insert fails", "POST", "/api/v1/reservations", "test-api-key",
+		strings.Replace(validReservationBody, `"room_id": 1`, `"room_id": 2`, 1), http.StatusInternalServerError, apiCodeInternal},
+	{"reservation", "GET", "/api/v1/reservations/1", "test-api-key", "", http.StatusOK, ""},
+	{"missing reservation", "GET", "/api/v1/reservations/100", "test-api-key", "", http.StatusNotFound, apiCodeNotFound},
+	{"reservation bad id", "GET", "/api/v1/reservations/x", "test-api-key", "", http.StatusNotFound, apiCodeNotFound},
+	{"reservation fails", "GET", "/api/v1/reservations/3", "test-api-key", "", http.StatusInternalServerError, apiCodeInternal},
+	{"cancel", "DELETE", "/api/v1/reservations/1", "test-api-key", "", http.StatusOK, ""},
+	{"cancel fails", "DELETE", "/api/v1/reservations/2", "test-api-key", "", http.StatusInternalServerError, apiCodeInternal},
+	{"cancel missing reservation", "DELETE", "/api/v1/reservations/100", "test-api-key", "", http.StatusNotFound, apiCodeNotFound},
+	{"reservation of another key", "GET", "/api/v1/reservations/1", "partner-api-key", "", http.StatusNotFound, apiCodeNotFound},
+	{"cancel reservation of another key", "DELETE", "/api/v1/reservations/1", "partner-api-key", "", http.StatusNotFound, apiCodeNotFound},
+}
+
+func TestRepository_API(t *testing.T) {
+	routes := getRoutes()
+
+	for _, e := range apiTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		if e.key != "" {
+			req.Header.Set("Authorization", "Bearer "+e.key)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+		}
+
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("for %s, expected a json response but got %s", e.name, ct)
+		}
+
+		checkAPIResponse(t, e.name, req, rr)
+
+		if e.expectedCode != "" {
+			var body apiError
+			err := json.Unmarshal(rr.Body.Bytes(), &body)
+			if err != nil {
+				t.Errorf("for %s, error body is not json: %s", e.name, err)
+			} else if body.Error.Code != e.expectedCode {
+				t.Errorf("for %s, expected error code %s but got %s", e.name, e.expectedCode, body.Error.Code)
+			}
+		}
+	}
+}
+
+// checkAPIResponse fails the test when a response doesn't match the api document
+func checkAPIResponse(t *testing.T, name string, req *http.Request, rr *httptest.ResponseRecorder) {
+	err := app.OpenAPI.ValidateResponse(req, rr.Code, rr.Header(), rr.Body.Bytes())
+	if errors.Is(err, openapi.ErrNoRoute) {
+		// answers to unknown paths and methods can't be described
+		if rr.Code != http.StatusNotFound && rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("for %s, the api document doesn't describe %s %s", name, req.Method, req.URL.Path)
+		}
+	} else if err != nil {
+		t.Errorf("for %s, the response doesn't match the api document: %s", name, err)
+	}
+}
+
+func TestRepository_APIFields(t *testing.T) {
+	routes := getRoutes()
+
+	var tests = []struct {
+		name           string
+		body           string
+		expectedFields []string
+	}{
+		{"missing fields", `{"room_id": 1}`, []string{"start_date", "end_date", "first_name", "last_name", "email"}},
+		{"bad date and email", strings.Replace(strings.Replace(validReservationBody, "2051-01-01", "01-01-2051", 1), "adria@lopez.es", "adria", 1),
+			[]string{"start_date", "email"}},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(e.body))
+		req.Header.Set("Authorization", "Bearer test-api-key")
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		var body apiError
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body.Error.Fields) != len(e.expectedFields) {
+			t.Errorf("for %s, expected %d invalid fields but got %v", e.name, len(e.expectedFields), body.Error.Fields)
+		}
+		for _, field := range e.expectedFields {
+			if body.Error.Fields[field] == "" {
+				t.Errorf("for %s, expected %s to be invalid", e.name, field)
+			}
+		}
+	}
+}
+
+// TestAPIDocumented keeps the api document in step with the routes
+func TestAPIDocumented(t *testing.T) {
+	err := chi.Walk(getRoutes().(chi.Router), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, "/api/") && route != "/search-availability-json" {
+			return nil
+		}
+
+		req, _ := http.NewRequest(method, strings.Replace(route, "{id}", "1", 1), nil)
+		err := app.OpenAPI.ValidateRequest(req)
+		if errors.Is(err, openapi.ErrNoRoute) {
+			t.Errorf("%s %s is not in the api document", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepository_APIReservationDeposit(t *testing.T) {
+	app.DepositPercent = 30
+	defer func() {
+		app.DepositPercent = 0
+	}()
+
+	routes := getRoutes()
+
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"card accepted", "4242424242424242", http.StatusCreated},
+		{"card declined", payments.DeclinedCard, http.StatusPaymentRequired},
+		{"no card", "", http.StatusPaymentRequired},
+	}
+
+	for _, e := range tests {
+		body := strings.Replace(validReservationBody, `"phone"`, `"payment_token": "`+e.token+`", "phone"`, 1)
+
+		req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+		req.Header.Set("X-API-Key", "test-api-key")
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		checkAPIResponse(t, e.name, req, rr)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+
+		if rr.Code == http.StatusCreated {
+			var res apiReservation
+			err := json.Unmarshal(rr.Body.Bytes(), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// two nights at 100.00, 30% deposit
+			if res.AmountPaid != 6000 {
+				t.Errorf("for %s, expected 6000 paid but got %d", e.name, res.AmountPaid)
+			}
+			if res.Quote == nil || res.Quote.Total != 20000 {
+				t.Errorf("for %s, expected a quote of 20000 but got %+v", e.name, res.Quote)
+			}
+		}
+	}
+}
+
+func TestRepository_APICancelReservationMail(t *testing.T) {
+	routes := getRoutes()
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/reservations/1", nil)
+	req.Header.Set("X-API-Key", "test-api-key")
+
+	mailRecorder.Reset()
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d but got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// the guest and the owner are told, as when the guest cancels
+	sent := mailRecorder.Messages()
+	if len(sent) != 2 || sent[0].To != "adria@lopez.es" || sent[0].Template != mailer.TemplateReservationCancelled ||
+		sent[1].Template != mailer.TemplateReservationCancelledOwner {
+		t.Errorf("expected the cancellation mailed to the guest and the owner but got %+v", sent)
+	}
+}
+
+var adminAPIKeysTests = []struct {
+	name               string
+	url                string
+	method             string
+	postedData         url.Values
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"list keys", "/admin/api-keys", "GET", nil, (*Repository).AdminAPIKeys, http.StatusOK, ""},
+	{"create key", "/admin/api-keys", "POST", url.Values{"name": {"Partner"}}, (*Repository).AdminPostAPIKey, http.StatusSeeOther, "/admin/api-keys"},
+	{"create key without name", "/admin/api-keys", "POST", url.Values{}, (*Repository).AdminPostAPIKey, http.StatusOK, ""},
+	{"create key fails", "/admin/api-keys", "POST", url.Values{"name": {"fail"}}, (*Repository).AdminPostAPIKey, http.StatusInternalServerError, ""},
+	{"revoke key", "/admin/api-keys/1/revoke", "POST", url.Values{}, (*Repository).AdminRevokeAPIKey, http.StatusSeeOther, "/admin/api-keys"},
+	{"revoke key fails", "/admin/api-keys/2/revoke", "POST", url.Values{}, (*Repository).AdminRevokeAPIKey, http.StatusInternalServerError, ""},
+	{"revoke bad id", "/admin/api-keys/x/revoke", "POST", url.Values{}, (*Repository).AdminRevokeAPIKey, http.StatusBadRequest, ""},
+}
+
+func TestRepository_AdminAPIKeys(t *testing.T) {
+	for _, e := range adminAPIKeysTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+
+		if e.name == "create key" && !strings.HasPrefix(session.GetString(ctx, "new_api_key"), "bk_") {
+			t.Errorf("for %s, the new key was not put in the session", e.name)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+)
+
+// auditPageSize is how many entries the audit log page shows; the export has them all
+const auditPageSize = 200
+
+// apiKeyContextKey carries the api key of an api request, for the audit log and to tell the
+// reservations the key made
+type apiKeyContextKey struct{}
+
+// blockedNights are the nights of a room blocked or unblocked in one go, for the audit log
+type blockedNights struct {
+	Nights []string
+}
+
+// activeState is whether a room or a user is active, for the audit log
+type activeState struct {
+	Active bool
+}
+
+// auditActor returns an audit entry with whoever makes r as its actor: the api key of an api
+// request, the logged in user or else a guest
+func (m *Repository) auditActor(r *http.Request) models.AuditEntry {
+	e := models.AuditEntry{Actor: "guest"}
+
+	if apiKey, ok := r.Context().Value(apiKeyContextKey{}).(models.APIKey); ok {
+		e.Actor = "api key " + apiKey.Name
+	} else if userID := m.App.Session.GetInt(r.Context(), "user_id"); userID > 0 {
+		e.UserID = userID
+		e.Actor = fmt.Sprintf("user %d", userID)
+		if user, err := m.DB.GetUserById(userID); err == nil {
+			e.Actor = user.Email
+		}
+	}
+
+	return e
+}
+
+// audit records in the audit log that whoever makes r did action to the entity with id. before and
+// after are the entity before and after the action, nil when it didn't exist
+func (m *Repository) audit(r *http.Request, action, entity string, id int, before, after interface{}) {
+	e := m.auditActor(r)
+	e.Action = action
+	e.Entity = entity
+	e.EntityID = id
+
+	m.recordAudit(r, e, before, after)
+}
+
+// recordAudit appends e to the audit log with what changed from before to after. The action it
+// records has already happened, so failing to record it is logged rather than shown to the user
+func (m *Repository) recordAudit(r *http.Request, e models.AuditEntry, before, after interface{}) {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		m.App.ErrorLog.Println("cannot diff the audit entry:", err)
+	}
+	if len(changes) > 0 {
+		b, err := json.Marshal(changes)
+		if err != nil {
+			m.App.ErrorLog.Println("cannot encode the audit entry:", err)
+		}
+		e.Changes = string(b)
+	}
+
+	e.IP = clientIP(r)
+
+	err = m.DB.InsertAuditEntry(e)
+	if err != nil {
+		m.App.ErrorLog.Printf("cannot record %s %s %d by %s in the audit log: %s", e.Action, e.Entity, e.EntityID, e.Actor, err)
+	}
+}
+
+// auditFilterFromURL reads the entity, entity_id and user_id filters of the audit log from the query
+// string
+func auditFilterFromURL(r *http.Request) (models.AuditFilter, error) {
+	var f models.AuditFilter
+	var err error
+
+	q := r.URL.Query()
+	f.Entity = q.Get("entity")
+	if s := q.Get("entity_id"); s != "" {
+		f.EntityID, err = strconv.Atoi(s)
+		if err != nil {
+			return f, err
+		}
+	}
+	if s := q.Get("user_id"); s != "" {
+		f.UserID, err = strconv.Atoi(s)
+		if err != nil {
+			return f, err
+		}
+	}
+
+	return f, nil
+}
+
+// auditChanges decodes the changes of an audit entry
+func auditChanges(e models.AuditEntry) []audit.Change {
+	var changes []audit.Change
+	if e.Changes != "" {
+		_ = json.Unmarshal([]byte(e.Changes), &changes)
+	}
+	return changes
+}
+
+// AdminAuditLog shows the latest entries of the audit log, filtered by entity or user
+func (m *Repository) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilterFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	f.Limit = auditPageSize
+
+	entries, err := m.DB.AuditEntries(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	changes := make(map[int][]audit.Change)
+	for _, e := range entries {
+		changes[e.ID] = auditChanges(e)
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["changes"] = changes
+	data["entities"] = audit.Entities
+	data["users"] = users
+
+	stringMap := make(map[string]string)
+	stringMap["entity"] = f.Entity
+	stringMap["export_url"] = "/admin/audit-log.csv"
+	if r.URL.RawQuery != "" {
+		stringMap["export_url"] += "?" + r.URL.RawQuery
+	}
+
+	intMap := make(map[string]int)
+	intMap["entity_id"] = f.EntityID
+	intMap["user_id"] = f.UserID
+	intMap["page_size"] = auditPageSize
+
+	render.Template(w, r, "admin-audit-log.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// csvCell keeps a cell of the export from being read as a formula by spreadsheets. Actors can be
+// whatever anyone typed as their email on the login page
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// AdminAuditLogCSV exports every entry of the audit log that matches the filters as CSV
+func (m *Repository) AdminAuditLogCSV(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilterFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := m.DB.AuditEntries(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-log-%s.csv"`, time.Now().Format("2006-01-02")))
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "time", "user_id", "actor", "action", "entity", "entity_id", "ip", "changes"})
+	for _, e := range entries {
+		_ = cw.Write([]string{
+			strconv.Itoa(e.ID),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.Itoa(e.UserID),
+			csvCell(e.Actor),
+			csvCell(e.Action),
+			csvCell(e.Entity),
+			strconv.Itoa(e.EntityID),
+			csvCell(e.IP),
+			csvCell(e.Changes),
+		})
+	}
+	cw.Flush()
+
+	if err := cw.Error(); err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/repository"
+)
+
+var auditLogTests = []struct {
+	name               string
+	url                string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+}{
+	{"audit log", "/admin/audit-log", (*Repository).AdminAuditLog, http.StatusOK},
+	{"audit log of an entity", "/admin/audit-log?entity=room&entity_id=1", (*Repository).AdminAuditLog, http.StatusOK},
+	{"audit log of a user", "/admin/audit-log?user_id=1", (*Repository).AdminAuditLog, http.StatusOK},
+	{"audit log bad entity id", "/admin/audit-log?entity=room&entity_id=x", (*Repository).AdminAuditLog, http.StatusBadRequest},
+	{"audit log bad user id", "/admin/audit-log?user_id=x", (*Repository).AdminAuditLog, http.StatusBadRequest},
+	{"audit log fails", "/admin/audit-log?user_id=2", (*Repository).AdminAuditLog, http.StatusInternalServerError},
+	{"export", "/admin/audit-log.csv?entity=room", (*Repository).AdminAuditLogCSV, http.StatusOK},
+	{"export bad entity id", "/admin/audit-log.csv?entity_id=x", (*Repository).AdminAuditLogCSV, http.StatusBadRequest},
+	{"export fails", "/admin/audit-log.csv?user_id=2", (*Repository).AdminAuditLogCSV, http.StatusInternalServerError},
+	{"export formula", "/admin/audit-log.csv?entity=user", (*Repository).AdminAuditLogCSV, http.StatusOK},
+}
+
+func TestRepository_AuditLog(t *testing.T) {
+	for _, e := range auditLogTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.name == "export" {
+			if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+				t.Errorf("for %s, expected a csv but got %s", e.name, ct)
+			}
+			lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+			if len(lines) != 3 || lines[0] != "id,time,user_id,actor,action,entity,entity_id,ip,changes" {
+				t.Errorf("for %s, expected a header and 2 entries but got %q", e.name, rr.Body.String())
+			}
+		}
+
+		// a spreadsheet would run the formula typed as the email of a failed login
+		if e.name == "export formula" && !strings.Contains(rr.Body.String(), `"'=HYPERLINK(`) {
+			t.Errorf("for %s, expected the formula to be escaped but got %q", e.name, rr.Body.String())
+		}
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	theTests := []struct {
+		cell     string
+		expected string
+	}{
+		{"admin@here.com", "admin@here.com"},
+		{"=1+2", "'=1+2"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tx", "'\tx"},
+		{"\rx", "'\rx"},
+		{"", ""},
+	}
+
+	for _, e := range theTests {
+		if got := csvCell(e.cell); got != e.expected {
+			t.Errorf("for %q, expected %q but got %q", e.cell, e.expected, got)
+		}
+	}
+}
+
+func TestRepository_auditActor(t *testing.T) {
+	theTests := []struct {
+		name          string
+		setup         func(r *http.Request) *http.Request
+		expectedActor string
+	}{
+		{"guest", func(r *http.Request) *http.Request { return r }, "guest"},
+		{"user", func(r *http.Request) *http.Request {
+			session.Put(r.Context(), "user_id", 1)
+			return r
+		}, "test@here.com"},
+		{"api key", func(r *http.Request) *http.Request {
+			return r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, models.APIKey{Name: "channel manager"}))
+		}, "api key channel manager"},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req = req.WithContext(getCtx(req))
+		req = e.setup(req)
+
+		if actor := Repo.auditActor(req).Actor; actor != e.expectedActor {
+			t.Errorf("for %s, expected %q but got %q", e.name, e.expectedActor, actor)
+		}
+	}
+}
+
+// auditRecorder keeps the entries appended to the audit log, and leaves the rest to the test repo
+type auditRecorder struct {
+	repository.DatabaseRepo
+	entries []models.AuditEntry
+}
+
+func (a *auditRecorder) InsertAuditEntry(e models.AuditEntry) error {
+	a.entries = append(a.entries, e)
+	return nil
+}
+
+func TestRepository_AuditChanges(t *testing.T) {
+	recorder := &auditRecorder{DatabaseRepo: Repo.DB}
+	Repo.DB = recorder
+	defer func() { Repo.DB = recorder.DatabaseRepo }()
+	routes := getRoutes()
+
+	// what an entity was before it was changed or deleted is recorded, and what it became
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		handler  func(*Repository, http.ResponseWriter, *http.Request)
+		expected []string
+	}{
+		{"process reservation", "POST", "/admin/process-reservation/new/1", (*Repository).AdminProcessReservation,
+			[]string{`{"field":"Processed","before":0,"after":1}`}},
+		{"delete reservation", "POST", "/admin/delete-reservation/all/1", (*Repository).AdminDeleteReservation,
+			[]string{`{"field":"Email","before":"adria@lopez.es","after":null}`}},
+		{"cancel reservation", "POST", manageURL(1, stayEnd) + "/cancel", (*Repository).ManagePostCancel,
+			[]string{`{"field":"CancelledAt","before":"0001-01-01T00:00:00Z","after":"` + time.Now().Format("2006")}},
+		// made through the api routes, with the key that made the reservation
+		{"cancel reservation through the api", "DELETE", "/api/v1/reservations/1", nil,
+			[]string{`{"field":"CancelledAt","before":"0001-01-01T00:00:00Z","after":"` + time.Now().Format("2006")}},
+		{"delete user", "POST", "/admin/users/5/delete", (*Repository).AdminDeleteUser,
+			[]string{`{"field":"Email","before":"test@here.com","after":null}`}},
+	}
+
+	for _, e := range tests {
+		recorder.entries = nil
+
+		req, _ := http.NewRequest(e.method, e.url, nil)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		if e.handler == nil {
+			req.Header.Set("Authorization", "Bearer test-api-key")
+			routes.ServeHTTP(rr, req)
+		} else {
+			ctx := getCtx(req)
+			req = req.WithContext(ctx)
+			session.Put(ctx, "user_id", 1)
+			e.handler(Repo, rr, req)
+		}
+
+		if len(recorder.entries) != 1 {
+			t.Errorf("for %s, expected an entry in the audit log but got %d", e.name, len(recorder.entries))
+			continue
+		}
+		for _, change := range e.expected {
+			if !strings.Contains(recorder.entries[0].Changes, change) {
+				t.Errorf("for %s, expected the change %s but got %s", e.name, change, recorder.entries[0].Changes)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/forms"
+	"github.com/adrialopezbou/bookings-go/internal/guestmessages"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+)
+
+// guestMessageIDFromURL returns the id of the message in urls like /admin/guest-messages/{id}, where
+// "new" is message 0
+func guestMessageIDFromURL(r *http.Request) (int, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 {
+		return 0, errors.New("missing guest message id")
+	}
+
+	if exploded[3] == "new" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(exploded[3])
+}
+
+// AdminGuestMessages lists the emails sent to guests before and after their stay
+func (m *Repository) AdminGuestMessages(w http.ResponseWriter, r *http.Request) {
+	messages, err := m.DB.AllGuestMessages()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// when each message is sent, by id
+	schedules := make(map[int]string)
+	for _, g := range messages {
+		schedules[g.ID] = guestmessages.Describe(g.Anchor, g.OffsetDays)
+	}
+
+	data := make(map[string]interface{})
+	data["messages"] = messages
+	data["schedules"] = schedules
+
+	render.Template(w, r, "admin-guest-messages.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowGuestMessage shows the form to add or edit a guest message
+func (m *Repository) AdminShowGuestMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := guestMessageIDFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	message := models.GuestMessage{
+		Anchor: guestmessages.AnchorArrival,
+	}
+	if id > 0 {
+		message, err = m.DB.GetGuestMessageByID(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.renderGuestMessage(w, r, message, forms.New(nil))
+}
+
+func (m *Repository) renderGuestMessage(w http.ResponseWriter, r *http.Request, message models.GuestMessage, form *forms.Form) {
+	days := message.OffsetDays
+	when := "after"
+	if days < 0 {
+		days, when = -days, "before"
+	}
+
+	data := make(map[string]interface{})
+	data["message"] = message
+	data["anchors"] = guestmessages.Anchors
+
+	stringMap := make(map[string]string)
+	stringMap["when"] = when
+
+	intMap := make(map[string]int)
+	intMap["days"] = days
+	intMap["max_days"] = guestmessages.MaxOffsetDays
+	intMap["window_days"] = guestmessages.WindowDays
+
+	render.Template(w, r, "admin-guest-message.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostGuestMessage adds a guest message or updates one
+func (m *Repository) AdminPostGuestMessage(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := guestMessageIDFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var message models.GuestMessage
+	if id > 0 {
+		message, err = m.DB.GetGuestMessageByID(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	before := message
+
+	message.Name = strings.TrimSpace(r.Form.Get("name"))
+	message.Subject = strings.TrimSpace(r.Form.Get("subject"))
+	message.Body = strings.TrimSpace(r.Form.Get("body"))
+	message.Anchor = r.Form.Get("anchor")
+	message.Active = r.Form.Get("active") == "1"
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "subject", "body")
+
+	if !guestmessages.ValidAnchor(message.Anchor) {
+		form.Errors.Add("anchor", "Choose arrival or departure")
+	}
+
+	days, err := strconv.Atoi(r.Form.Get("days"))
+	if err != nil || days < 0 || days > guestmessages.MaxOffsetDays {
+		form.Errors.Add("days", "Enter a number of days from 0 to "+strconv.Itoa(guestmessages.MaxOffsetDays))
+	}
+	message.OffsetDays = days
+	if r.Form.Get("when") == "before" {
+		message.OffsetDays = -days
+	}
+
+	if !form.Valid() {
+		m.renderGuestMessage(w, r, message, form)
+		return
+	}
+
+	if message.ID == 0 {
+		message.ID, err = m.DB.InsertGuestMessage(message)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.audit(r, audit.ActionCreate, audit.EntityGuestMessage, message.ID, nil, message)
+	} else {
+		err = m.DB.UpdateGuestMessage(message)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.audit(r, audit.ActionUpdate, audit.EntityGuestMessage, message.ID, before, message)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Guest message saved")
+	http.Redirect(w, r, "/admin/guest-messages", http.StatusSeeOther)
+}
+
+// AdminDeleteGuestMessage deletes a guest message, so it is no longer sent
+func (m *Repository) AdminDeleteGuestMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := guestMessageIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteGuestMessage(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionDelete, audit.EntityGuestMessage, id, nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Guest message deleted")
+	http.Redirect(w, r, "/admin/guest-messages", http.StatusSeeOther)
+}
+
+// SendGuestMessages queues the guest messages due on the day of now for every stay that isn't
+// cancelled, and returns how many were queued. Each message goes once to each reservation, even
+// when several servers send them at the same time. Failures to queue one are logged
+func (m *Repository) SendGuestMessages(now time.Time) (int, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	messages, err := m.DB.AllGuestMessages()
+	if err != nil {
+		return 0, err
+	}
+
+	byID := make(map[int]models.GuestMessage)
+	for _, g := range messages {
+		byID[g.ID] = g
+	}
+
+	// stays a message sent on today can be about, counting the ones sent late
+	from := today.AddDate(0, 0, -guestmessages.MaxOffsetDays-guestmessages.WindowDays)
+	to := today.AddDate(0, 0, guestmessages.MaxOffsetDays)
+	pending, err := m.DB.PendingGuestMessages(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, p := range pending {
+		g, ok := byID[p.MessageID]
+		if !ok || !g.Active {
+			continue
+		}
+
+		res := p.Reservation
+		if !guestmessages.Due(g.Anchor, g.OffsetDays, res.StartDate, res.EndDate, today) {
+			continue
+		}
+
+		mail := m.mail(res.Email, g.Subject, mailer.TemplateGuestMessage, models.GuestMessageMail{
+			ReservationMail: m.reservationMail(res),
+			Body:            g.Body,
+			Upcoming:        today.Before(res.StartDate),
+		})
+
+		queued, err := m.DB.SendGuestMessage(res.ID, g.ID, mail)
+		if err != nil {
+			// the other stays still get their messages, and this one is tried again next time
+			m.App.ErrorLog.Printf("cannot send guest message %d for reservation %d: %s", g.ID, res.ID, err)
+			continue
+		}
+		if queued {
+			sent++
+		}
+	}
+
+	return sent, nil
+}
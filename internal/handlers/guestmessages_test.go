@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+var guestMessageForm = url.Values{
+	"name":    {"Reminder"},
+	"subject": {"See you soon"},
+	"body":    {"Your stay is coming up."},
+	"days":    {"7"},
+	"when":    {"before"},
+	"anchor":  {"arrival"},
+	"active":  {"1"},
+}
+
+// withGuestMessageForm returns guestMessageForm with the given fields replaced
+func withGuestMessageForm(fields url.Values) url.Values {
+	values := url.Values{}
+	for k, v := range guestMessageForm {
+		values[k] = v
+	}
+	for k, v := range fields {
+		values[k] = v
+	}
+	return values
+}
+
+var guestMessagesTests = []struct {
+	name               string
+	url                string
+	method             string
+	postedData         url.Values
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"list messages", "/admin/guest-messages", "GET", nil, (*Repository).AdminGuestMessages, http.StatusOK, ""},
+	{"new form", "/admin/guest-messages/new", "GET", nil, (*Repository).AdminShowGuestMessage, http.StatusOK, ""},
+	{"edit form", "/admin/guest-messages/1", "GET", nil, (*Repository).AdminShowGuestMessage, http.StatusOK, ""},
+	{"edit form bad id", "/admin/guest-messages/x", "GET", nil, (*Repository).AdminShowGuestMessage, http.StatusBadRequest, ""},
+	{"edit form missing message", "/admin/guest-messages/9", "GET", nil, (*Repository).AdminShowGuestMessage, http.StatusNotFound, ""},
+	{"edit form fails", "/admin/guest-messages/4", "GET", nil, (*Repository).AdminShowGuestMessage, http.StatusInternalServerError, ""},
+
+	{"add", "/admin/guest-messages/new", "POST", guestMessageForm, (*Repository).AdminPostGuestMessage, http.StatusSeeOther, "/admin/guest-messages"},
+	{"add without fields", "/admin/guest-messages/new", "POST", url.Values{}, (*Repository).AdminPostGuestMessage, http.StatusOK, ""},
+	{"add bad anchor", "/admin/guest-messages/new", "POST", withGuestMessageForm(url.Values{"anchor": {"booking"}}), (*Repository).AdminPostGuestMessage, http.StatusOK, ""},
+	{"add too many days", "/admin/guest-messages/new", "POST", withGuestMessageForm(url.Values{"days": {"61"}}), (*Repository).AdminPostGuestMessage, http.StatusOK, ""},
+	{"add fails", "/admin/guest-messages/new", "POST", withGuestMessageForm(url.Values{"name": {"fail"}}), (*Repository).AdminPostGuestMessage, http.StatusInternalServerError, ""},
+	{"update", "/admin/guest-messages/2", "POST", guestMessageForm, (*Repository).AdminPostGuestMessage, http.StatusSeeOther, "/admin/guest-messages"},
+	{"update missing message", "/admin/guest-messages/9", "POST", guestMessageForm, (*Repository).AdminPostGuestMessage, http.StatusNotFound, ""},
+	{"update fails", "/admin/guest-messages/2", "POST", withGuestMessageForm(url.Values{"name": {"fail"}}), (*Repository).AdminPostGuestMessage, http.StatusInternalServerError, ""},
+
+	{"delete", "/admin/guest-messages/3/delete", "POST", url.Values{}, (*Repository).AdminDeleteGuestMessage, http.StatusSeeOther, "/admin/guest-messages"},
+	{"delete bad id", "/admin/guest-messages/x/delete", "POST", url.Values{}, (*Repository).AdminDeleteGuestMessage, http.StatusBadRequest, ""},
+}
+
+func TestRepository_GuestMessages(t *testing.T) {
+	for _, e := range guestMessagesTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+	}
+}
+
+func TestRepository_SendGuestMessages(t *testing.T) {
+	// the test repo has stays from 10-01-2050 to 12-01-2050 for reservations 1, 2 and 1000. Only
+	// reservation 1 gets the messages: they were sent to 2 already and queueing them fails for 1000
+	tests := []struct {
+		name     string
+		now      time.Time
+		subject  string
+		upcoming bool
+	}{
+		{"a week before arrival", time.Date(2050, 1, 3, 9, 0, 0, 0, time.UTC), "Your stay is coming up", true},
+		{"late within the window", time.Date(2050, 1, 5, 23, 0, 0, 0, time.UTC), "Your stay is coming up", true},
+		{"on the day of arrival", time.Date(2050, 1, 10, 9, 0, 0, 0, time.UTC), "Check-in instructions", false},
+		{"after the window", time.Date(2050, 1, 7, 9, 0, 0, 0, time.UTC), "", false},
+		{"inactive after departure", time.Date(2050, 1, 13, 9, 0, 0, 0, time.UTC), "", false},
+	}
+
+	for _, e := range tests {
+		mailRecorder.Reset()
+
+		sent, err := Repo.SendGuestMessages(e.now)
+		if err != nil {
+			t.Errorf("for %s, unexpected error: %s", e.name, err)
+			continue
+		}
+
+		expected := 0
+		if e.subject != "" {
+			expected = 1
+		}
+		if sent != expected {
+			t.Errorf("for %s, expected %d but got %d", e.name, expected, sent)
+		}
+
+		mail := mailRecorder.Messages()
+		if len(mail) != expected {
+			t.Errorf("for %s, expected %d emails but got %d: %+v", e.name, expected, len(mail), mail)
+			continue
+		}
+		if expected == 0 {
+			continue
+		}
+
+		if mail[0].To != "adria@lopez.es" || mail[0].Subject != e.subject || mail[0].Template != mailer.TemplateGuestMessage {
+			t.Errorf("for %s, expected %q to adria@lopez.es but got %q to %s with %s", e.name, e.subject, mail[0].Subject, mail[0].To, mail[0].Template)
+		}
+		data, ok := mail[0].Data.(models.GuestMessageMail)
+		if !ok || data.FirstName != "adria" || data.Upcoming != e.upcoming {
+			t.Errorf("for %s, expected the stay of adria, upcoming %t, but got %+v", e.name, e.upcoming, mail[0].Data)
+		}
+	}
+}
@@ -1,20 +1,29 @@
 package handlers
 
 import (
+	"crypto/subtle"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/adrialopezbou/bookings-go/internal/audit"
 	"github.com/adrialopezbou/bookings-go/internal/config"
 	"github.com/adrialopezbou/bookings-go/internal/driver"
 	"github.com/adrialopezbou/bookings-go/internal/forms"
 	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/holds"
+	"github.com/adrialopezbou/bookings-go/internal/ical"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
 	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/payments"
+	"github.com/adrialopezbou/bookings-go/internal/pricing"
 	"github.com/adrialopezbou/bookings-go/internal/render"
 	"github.com/adrialopezbou/bookings-go/internal/repository"
 	"github.com/adrialopezbou/bookings-go/internal/repository/dbrepo"
@@ -78,8 +87,33 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	res.Room.RoomName = room.RoomName
 
+	quote, err := m.quoteStay(room, res.StartDate, res.EndDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't calculate the price of the stay")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	res.Quote = quote
+
+	// the room stays held while the guest is on the form
+	err = m.extendHold(r, res)
+	if errors.Is(err, repository.ErrRoomTaken) {
+		m.App.Session.Put(r.Context(), "error", roomTakenMessage)
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't hold the room")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
+	m.renderReservationForm(w, r, res, forms.New(nil))
+}
+
+// renderReservationForm displays the reservation form with the price and deposit of the stay
+func (m *Repository) renderReservationForm(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
 	sd := res.StartDate.Format("02-01-2006")
 	ed := res.EndDate.Format("02-01-2006")
 
@@ -87,15 +121,133 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
 
+	intMap := make(map[string]int)
+	intMap["amount_due"] = payments.AmountDue(res.Quote.Total, m.App.DepositPercent)
+	intMap["deposit_percent"] = m.App.DepositPercent
+	intMap["hold_seconds"] = int(m.holdLeft(r).Seconds())
+
 	data := make(map[string]interface{})
 	data["reservation"] = res
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-		Form:      forms.New(nil),
+		Form:      form,
 		Data:      data,
 		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
+// takePayment captures an authorized amount before the room is booked, and returns the payment to
+// record with the reservation. An amount that can't be taken is voided
+func (m *Repository) takePayment(currency string, auth payments.Authorization) (models.Payment, error) {
+	if auth.ID == "" {
+		return models.Payment{}, nil
+	}
+
+	err := m.App.Payments.Capture(auth.ID, auth.Amount)
+	if err != nil {
+		m.voidPayment(auth)
+		return models.Payment{}, err
+	}
+
+	return models.Payment{
+		Provider:    m.App.Payments.Name(),
+		ProviderRef: auth.ID,
+		Amount:      auth.Amount,
+		Currency:    currency,
+		Status:      payments.StatusCaptured,
+	}, nil
+}
+
+// returnPayment gives back a payment taken for a room that wasn't booked after all. It was never
+// recorded, so failing to is logged for the owner to refund by hand
+func (m *Repository) returnPayment(payment models.Payment) {
+	if payment.Amount == 0 {
+		return
+	}
+
+	err := m.App.Payments.Refund(payment.ProviderRef, payment.Amount)
+	if err != nil {
+		m.App.ErrorLog.Printf("cannot refund %d %s of payment %s: %s", payment.Amount, payment.Currency, payment.ProviderRef, err)
+	}
+}
+
+// voidPayment releases the hold on the guest's card when the room isn't booked after all. Failing
+// to is logged, since the provider lets the authorization lapse anyway
+func (m *Repository) voidPayment(auth payments.Authorization) {
+	if auth.ID == "" {
+		return
+	}
+
+	err := m.App.Payments.Void(auth.ID)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// refundPayment gives a captured payment back to the guest
+func (m *Repository) refundPayment(payment models.Payment) {
+	err := m.App.Payments.Refund(payment.ProviderRef, payment.Amount)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	err = m.DB.UpdatePaymentStatus(payment.ID, payments.StatusRefunded)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// refundReservation gives back everything captured for a reservation
+func (m *Repository) refundReservation(reservationID int) error {
+	reservationPayments, err := m.DB.GetPaymentsForReservation(reservationID)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range reservationPayments {
+		if p.Status == payments.StatusCaptured {
+			m.refundPayment(p)
+		}
+	}
+
+	return nil
+}
+
+// PaymentWebhook receives payment status changes from the payment provider
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	event, err := m.App.Payments.VerifyWebhook(r)
+	if err != nil {
+		m.App.InfoLog.Println(err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	status, ok := payments.StatusForEvent(event.Type)
+	if !ok {
+		// events we don't track are acknowledged so the provider stops sending them
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payment, err := m.DB.GetPaymentByProviderRef(m.App.Payments.Name(), event.PaymentID)
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdatePaymentStatus(payment.ID, status)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -130,65 +282,107 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	
-	newReservationID, err := m.DB.InsertReservation(res)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+
+	// the guest books at the price they were quoted on the reservation form
+	if len(res.Quote.Nights) == 0 {
+		room, err := m.DB.GetRoomById(res.RoomID)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find room")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+
+		res.Quote, err = m.quoteStay(room, res.StartDate, res.EndDate)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't calculate the price of the stay")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	// hold the deposit on the guest's card before anything is booked
+	amountDue := payments.AmountDue(res.Quote.Total, m.App.DepositPercent)
+
+	var auth payments.Authorization
+	if amountDue > 0 {
+		auth, err = m.App.Payments.Authorize(payments.AuthorizeRequest{
+			Amount:      amountDue,
+			Currency:    res.Quote.Currency,
+			Token:       r.Form.Get("payment_token"),
+			Description: fmt.Sprintf("%s from %s to %s", res.Room.RoomName, res.StartDate.Format("02-01-2006"), res.EndDate.Format("02-01-2006")),
+			Email:       res.Email,
+		})
+		if err != nil {
+			if err == payments.ErrDeclined {
+				form.Errors.Add("payment_token", "Your card was declined")
+			} else {
+				m.App.ErrorLog.Println(err)
+				form.Errors.Add("payment_token", "Your payment could not be processed, please try again")
+			}
+
+			m.renderReservationForm(w, r, res, form)
+			return
+		}
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     res.StartDate,
-		EndDate:       res.EndDate,
-		RoomID:        res.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	// the deposit is taken before booking, so that a booked room always has its payment recorded
+	payment, err := m.takePayment(res.Quote.Currency, auth)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "your payment could not be taken, the room has not been booked")
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	// the reservation, the nights it holds, its payment and its confirmation are booked together, or
+	// not at all when another guest got any of the nights first
+	newReservationID, err := m.DB.BookReservation(res, m.App.Session.GetInt(r.Context(), "hold_id"), payment, m.reservationMails)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction into database")
+		m.returnPayment(payment)
+	}
+	if errors.Is(err, repository.ErrRoomTaken) {
+		m.releaseHold(r)
+		m.App.Session.Put(r.Context(), "error", roomTakenMessage)
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	res.ID = newReservationID
 
-	// send notification to client
-	htmlMessage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br>
-		Dear %s, <br>
-		This is to confirm your reservation from %s to %s.
-	`, res.FirstName, res.StartDate.Format("02-01-2006"), res.EndDate.Format("02-01-2006"))
+	// the hold became the reservation
+	m.App.Session.Remove(r.Context(), "hold_id")
+	m.App.Session.Remove(r.Context(), "hold_expires_at")
 
-	msg := models.MailData{
-		To: res.Email,
-		From: "me@here.com",
-		Subject: "Reservation Confirmation",
-		Content: htmlMessage,
-		Template: "basic.html",
-	}
+	m.audit(r, audit.ActionCreate, audit.EntityReservation, res.ID, nil, res)
+	m.bookedFromWaitlist(r, res)
 
-	m.App.MailChan <- msg
+	m.App.Session.Put(r.Context(), "reservation", res)
+	m.App.Session.Put(r.Context(), "amount_paid", payment.Amount)
 
-	// send notificatio to owner
-	htmlMessage = fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br>
-		A reservation has been made for %s from %s to %s.
-	`, res.Room.RoomName, res.StartDate.Format("02-01-2006"), res.EndDate.Format("02-01-2006"))
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
 
-	msg = models.MailData{
-		To: "me@here.com",
-		From: "me@here.com",
-		Subject: "Reservation Notification",
-		Content: htmlMessage,
-		Template: "basic.html",
-	}
+// reservationMails are the emails confirming a new reservation to the guest and notifying the owner
+func (m *Repository) reservationMails(res models.Reservation) []models.MailData {
+	data := m.reservationMail(res)
 
-	m.App.MailChan <- msg
+	return []models.MailData{
+		m.mail(res.Email, "Reservation Confirmation", mailer.TemplateReservationConfirmation, data),
+		m.ownerMail("Reservation Notification", mailer.TemplateReservationNotification, data),
+	}
+}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+// cancellationMails returns the emails telling the guest and the owner that res was cancelled
+func (m *Repository) cancellationMails(res models.Reservation) []models.MailData {
+	data := m.reservationMail(res)
 
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	return []models.MailData{
+		m.mail(res.Email, "Reservation Cancelled", mailer.TemplateReservationCancelled, data),
+		m.ownerMail("Reservation Cancelled", mailer.TemplateReservationCancelledOwner, data),
+	}
 }
 
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
@@ -213,6 +407,9 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a new search lets go of the room held for the last one, so it can be found again
+	m.releaseHold(r)
+
 	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "error searching for availability")
@@ -220,9 +417,10 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the guest can wait for a room to free up instead
 	if len(rooms) == 0 {
-		m.App.Session.Put(r.Context(), "error", "No availability")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		m.App.Session.Put(r.Context(), "error", "No availability, join the waitlist and we will let you know when a room frees up")
+		http.Redirect(w, r, waitlistURL(start, end), http.StatusSeeOther)
 		return
 	}
 
@@ -242,11 +440,12 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 }
 
 type jsonResponse struct {
-	Ok        bool   `json:"ok"`
-	Message   string `json:"message"`
-	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
+	Ok        bool           `json:"ok"`
+	Message   string         `json:"message"`
+	RoomID    string         `json:"room_id"`
+	StartDate string         `json:"start_date"`
+	EndDate   string         `json:"end_date"`
+	Quote     *pricing.Quote `json:"quote,omitempty"`
 }
 
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
@@ -288,6 +487,16 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		RoomID:    strconv.Itoa(roomID),
 	}
 
+	if available {
+		room, err := m.DB.GetRoomById(roomID)
+		if err == nil {
+			quote, err := m.quoteStay(room, startDate, endDate)
+			if err == nil {
+				resp.Quote = &quote
+			}
+		}
+	}
+
 	out, _ := json.MarshalIndent(resp, "", "     ")
 
 	w.Header().Set("Content-Type", "application/json")
@@ -295,12 +504,82 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// Rooms lists the rooms offered to guests
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.ActiveRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room displays the page of a single room
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	// url is /rooms/{slug}
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 3 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomBySlug(exploded[2])
+	if err == sql.ErrNoRows || (err == nil && !room.Active) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// quoteStay prices a stay in a room with the room's current rates
+func (m *Repository) quoteStay(room models.Room, start, end time.Time) (pricing.Quote, error) {
+	seasons, err := m.DB.GetSeasonalRatesForRoom(room.ID)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+
+	discounts, err := m.DB.GetStayDiscountsForRoom(room.ID)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+
+	rules := pricing.Rules{
+		BaseRate:      room.BaseRate,
+		WeekendUplift: room.WeekendUplift,
+	}
+
+	for _, x := range seasons {
+		rules.Seasons = append(rules.Seasons, pricing.Season{
+			Name:        x.Name,
+			StartDate:   x.StartDate,
+			EndDate:     x.EndDate,
+			NightlyRate: x.NightlyRate,
+		})
+	}
+
+	for _, x := range discounts {
+		rules.StayDiscounts = append(rules.StayDiscounts, pricing.StayDiscount{
+			MinNights: x.MinNights,
+			Percent:   x.Percent,
+		})
+	}
+
+	return rules.Quote(start, end)
 }
 
 // ReservationSummary displays the reservation summary page
@@ -322,9 +601,13 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
 
+	intMap := make(map[string]int)
+	intMap["amount_paid"] = m.App.Session.PopInt(r.Context(), "amount_paid")
+
 	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
 		Data:      data,
 		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
@@ -345,6 +628,10 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 
 	res.RoomID = roomID
 
+	if !m.holdChosenRoom(w, r, res) {
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
@@ -378,6 +665,10 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	res.StartDate = startDate
 	res.EndDate = endDate
 
+	if !m.holdChosenRoom(w, r, res) {
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
@@ -413,21 +704,70 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// locked keys wait without their password being checked, so guesses don't count
+	keys := loginKeys(r, email)
+	locked, err := m.loginLocked(keys)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if locked {
+		m.auditLoginFailure(r, 0, email)
+		m.refuseLogin(w, r, true)
+		return
+	}
+
 	id, _, err := m.DB.Authenticate(email, password)
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		m.auditLoginFailure(r, 0, email)
+		err = m.recordLoginFailure(r, keys)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.refuseLogin(w, r, false)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, err := m.DB.GetUserById(id)
 	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// users with two-factor authentication aren't logged in until they enter a code
+	if user.TOTPSecret != "" {
+		m.startTwoFactorLogin(r, user.ID)
+		http.Redirect(w, r, "/user/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.ClearLoginThrottle(accountLoginKey(email))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.renewLogin(r, id)
+	if err != nil {
+		helpers.ServerError(w, err)
 		return
 	}
+	m.audit(r, audit.ActionLogin, audit.EntityUser, id, nil, nil)
 
-	m.App.Session.Put(r.Context(), "user_id", id)
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 // Logout logs a user out
 func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	if id := m.App.Session.GetInt(r.Context(), "user_id"); id > 0 {
+		m.audit(r, audit.ActionLogout, audit.EntityUser, id, nil, nil)
+	}
+
 	m.App.Session.Destroy(r.Context())
 	m.App.Session.RenewToken(r.Context())
 
@@ -436,4 +776,1084 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
-}
\ No newline at end of file
+}
+
+// AdminNewReservations shows all new reservations in admin tool
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.NewReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminAllReservations shows all reservations in admin tool
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowReservation shows the reservation in the admin tool
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	// url is /admin/reservations/{src}/{id}/show
+	exploded := strings.Split(r.RequestURI, "/")
+	if len(exploded) < 5 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["year"] = r.URL.Query().Get("y")
+	stringMap["month"] = r.URL.Query().Get("m")
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservationPayments, err := m.DB.GetPaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["payments"] = reservationPayments
+
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostShowReservation saves the changes made to a reservation in the admin tool
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// url is /admin/reservations/{src}/{id}
+	exploded := strings.Split(r.RequestURI, "/")
+	if len(exploded) < 5 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	src := exploded[3]
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	before := res
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["src"] = src
+		stringMap["year"] = r.Form.Get("year")
+		stringMap["month"] = r.Form.Get("month")
+
+		data := make(map[string]interface{})
+		data["reservation"] = res
+
+		render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+			Data:      data,
+			Form:      form,
+		})
+		return
+	}
+
+	err = m.DB.UpdateReservation(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityReservation, res.ID, before, res)
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, reservationsListURL(src, r), http.StatusSeeOther)
+}
+
+// reservationsListURL returns the admin page a reservation was opened from
+func reservationsListURL(src string, r *http.Request) string {
+	if src == "cal" {
+		return fmt.Sprintf("/admin/reservation-calendar?y=%s&m=%s", r.FormValue("year"), r.FormValue("month"))
+	}
+	return fmt.Sprintf("/admin/reservations-%s", src)
+}
+
+// AdminProcessReservation marks a reservation as processed
+func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	// url is /admin/process-reservation/{src}/{id}
+	exploded := strings.Split(r.RequestURI, "/")
+	if len(exploded) < 5 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	src := exploded[3]
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	before := res
+
+	err = m.DB.UpdateProcessed(id, 1)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	res.Processed = 1
+	m.audit(r, audit.ActionProcess, audit.EntityReservation, id, before, res)
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+	http.Redirect(w, r, reservationsListURL(src, r), http.StatusSeeOther)
+}
+
+// AdminDeleteReservation deletes a reservation and frees its room
+func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	// url is /admin/delete-reservation/{src}/{id}
+	exploded := strings.Split(r.RequestURI, "/")
+	if len(exploded) < 5 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	src := exploded[3]
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionDelete, audit.EntityReservation, id, res, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	http.Redirect(w, r, reservationsListURL(src, r), http.StatusSeeOther)
+}
+
+// AdminReservationsCalendar displays the reservation calendar
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	// assume that there is no month/year specified
+	now := time.Now()
+
+	if r.URL.Query().Get("y") != "" {
+		year, err := strconv.Atoi(r.URL.Query().Get("y"))
+		if err != nil {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		month, err := strconv.Atoi(r.URL.Query().Get("m"))
+		if err != nil || month < 1 || month > 12 {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	data := make(map[string]interface{})
+	data["now"] = now
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	stringMap := make(map[string]string)
+	stringMap["next_month"] = next.Format("01")
+	stringMap["next_month_year"] = next.Format("2006")
+	stringMap["last_month"] = last.Format("01")
+	stringMap["last_month_year"] = last.Format("2006")
+	stringMap["this_month"] = now.Format("01")
+	stringMap["this_month_year"] = now.Format("2006")
+
+	// get the first and last days of the month
+	currentYear, currentMonth, _ := now.Date()
+	currentLocation := now.Location()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = lastOfMonth.Day()
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data["rooms"] = rooms
+
+	for _, x := range rooms {
+		// create maps keyed by night, holding the reservation or block that occupies it
+		reservationMap := make(map[string]int)
+		blockMap := make(map[string]int)
+		externalMap := make(map[string]int)
+		holdMap := make(map[string]int)
+
+		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
+			reservationMap[d.Format("2006-01-2")] = 0
+			blockMap[d.Format("2006-01-2")] = 0
+			externalMap[d.Format("2006-01-2")] = 0
+			holdMap[d.Format("2006-01-2")] = 0
+		}
+
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, y := range restrictions {
+			// a restriction covers every night from its start date up to, but not including, its end date
+			for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+				key := d.Format("2006-01-2")
+				if _, ok := blockMap[key]; !ok {
+					// night falls outside of this month
+					continue
+				}
+				if y.ReservationID > 0 {
+					reservationMap[key] = y.ReservationID
+				} else if y.RestrictionID == ical.ExternalRestrictionID {
+					// imported from another site, only removed by syncing its calendar
+					externalMap[key] = y.ID
+				} else if y.RestrictionID == holds.RestrictionID {
+					// a guest is booking it right now
+					holdMap[key] = y.ID
+				} else {
+					blockMap[key] = y.ID
+				}
+			}
+		}
+
+		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("external_map_%d", x.ID)] = externalMap
+		data[fmt.Sprintf("hold_map_%d", x.ID)] = holdMap
+
+		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+	})
+}
+
+// AdminPostReservationsCalendar adds and removes owner blocks from the reservation calendar
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, err := strconv.Atoi(r.Form.Get("y"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	month, err := strconv.Atoi(r.Form.Get("m"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	// nights that were shown as blocked and are no longer checked must be unblocked, and only
+	// them, as a block can span nights that stay blocked
+	toRemove := make(map[int]map[int][]time.Time)
+	removed := make(map[int][]string)
+	for _, x := range rooms {
+		curMap, ok := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
+		if !ok {
+			m.App.Session.Put(r.Context(), "error", "Calendar expired, please try again")
+			http.Redirect(w, r, fmt.Sprintf("/admin/reservation-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+			return
+		}
+
+		for name, value := range curMap {
+			if value > 0 && !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
+				night, err := time.Parse("2006-01-2", name)
+				if err != nil {
+					continue
+				}
+				if toRemove[x.ID] == nil {
+					toRemove[x.ID] = make(map[int][]time.Time)
+				}
+				toRemove[x.ID][value] = append(toRemove[x.ID][value], night)
+				removed[x.ID] = append(removed[x.ID], name)
+			}
+		}
+	}
+
+	for roomID, nights := range toRemove {
+		err = m.DB.DeleteBlockNights(roomID, nights)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	for roomID, nights := range removed {
+		sort.Strings(nights)
+		m.audit(r, audit.ActionUnblock, audit.EntityRoom, roomID, blockedNights{nights}, nil)
+	}
+
+	// newly checked nights become blocks
+	toAdd := make(map[int][]time.Time)
+	for name := range r.PostForm {
+		if !strings.HasPrefix(name, "add_block") {
+			continue
+		}
+
+		// name is add_block_{room_id}_{date}
+		exploded := strings.Split(name, "_")
+		if len(exploded) != 4 {
+			continue
+		}
+
+		roomID, err := strconv.Atoi(exploded[2])
+		if err != nil {
+			continue
+		}
+
+		t, err := time.Parse("2006-01-2", exploded[3])
+		if err != nil {
+			continue
+		}
+
+		toAdd[roomID] = append(toAdd[roomID], t)
+	}
+
+	for roomID, dates := range toAdd {
+		err = m.DB.InsertBlocksForRoom(roomID, dates)
+		if errors.Is(err, repository.ErrRoomTaken) {
+			m.App.Session.Put(r.Context(), "error", "A night to block is booked or being booked by a guest, please check the calendar and try again")
+			http.Redirect(w, r, fmt.Sprintf("/admin/reservation-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		var nights []string
+		for _, d := range dates {
+			nights = append(nights, d.Format("2006-01-02"))
+		}
+		sort.Strings(nights)
+		m.audit(r, audit.ActionBlock, audit.EntityRoom, roomID, nil, blockedNights{nights})
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservation-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+}
+
+// AdminRooms lists all rooms in the admin tool
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// roomIDFromURL returns the room id of /admin/rooms/{id}/..., where "new" is room 0
+func roomIDFromURL(r *http.Request) (int, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 {
+		return 0, errors.New("missing room id")
+	}
+
+	if exploded[3] == "new" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(exploded[3])
+}
+
+// AdminShowRoom shows the form to create or edit a room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := roomIDFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room := models.Room{
+		Capacity: 2,
+		Active:   true,
+		BaseRate: 10000,
+	}
+	if id > 0 {
+		room, err = m.DB.GetRoomById(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostRoom creates or updates a room
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := roomIDFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room := models.Room{
+		Active: true,
+	}
+	if id > 0 {
+		room, err = m.DB.GetRoomById(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	before := room
+
+	room.RoomName = r.Form.Get("room_name")
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	room.Description = r.Form.Get("description")
+	room.Amenities = helpers.SplitLines(r.Form.Get("amenities"))
+	room.Images = helpers.SplitLines(r.Form.Get("images"))
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "slug", "capacity", "base_rate")
+	form.IsSlug("slug")
+
+	room.Capacity, err = strconv.Atoi(r.Form.Get("capacity"))
+	if err != nil || room.Capacity < 1 {
+		form.Errors.Add("capacity", "Capacity must be a number of guests")
+	}
+
+	room.BaseRate, err = pricing.ParseAmount(r.Form.Get("base_rate"))
+	if err != nil {
+		form.Errors.Add("base_rate", "Enter a nightly rate, like 120.00")
+	}
+
+	room.WeekendUplift = 0
+	if form.Has("weekend_uplift") {
+		room.WeekendUplift, err = strconv.Atoi(r.Form.Get("weekend_uplift"))
+		if err != nil || room.WeekendUplift < 0 {
+			form.Errors.Add("weekend_uplift", "Enter a percentage, like 20")
+		}
+	}
+
+	if form.Valid() {
+		existing, err := m.DB.GetRoomBySlug(room.Slug)
+		if err == nil && existing.ID != room.ID {
+			form.Errors.Add("slug", "This slug is already used by another room")
+		} else if err != nil && err != sql.ErrNoRows {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["room"] = room
+
+		render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	if room.ID == 0 {
+		// every room gets its own secret calendar feed
+		room.ICalToken, err = ical.NewToken()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		room.ID, err = m.DB.InsertRoom(room)
+		if err == nil {
+			m.audit(r, audit.ActionCreate, audit.EntityRoom, room.ID, nil, room)
+		}
+	} else {
+		err = m.DB.UpdateRoom(room)
+		if err == nil {
+			m.audit(r, audit.ActionUpdate, audit.EntityRoom, room.ID, before, room)
+		}
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminRetireRoom stops offering a room to guests
+func (m *Repository) AdminRetireRoom(w http.ResponseWriter, r *http.Request) {
+	m.updateRoomActive(w, r, false, "Room retired")
+}
+
+// AdminRestoreRoom offers a retired room to guests again
+func (m *Repository) AdminRestoreRoom(w http.ResponseWriter, r *http.Request) {
+	m.updateRoomActive(w, r, true, "Room restored")
+}
+
+func (m *Repository) updateRoomActive(w http.ResponseWriter, r *http.Request, active bool, msg string) {
+	id, err := roomIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.UpdateRoomActive(id, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityRoom, id, activeState{!active}, activeState{active})
+
+	m.App.Session.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminRoomRates shows the seasonal rates and length of stay discounts of a room
+func (m *Repository) AdminRoomRates(w http.ResponseWriter, r *http.Request) {
+	m.renderRoomRates(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderRoomRates(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	id, err := roomIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rates, err := m.DB.GetSeasonalRatesForRoom(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	discounts, err := m.DB.GetStayDiscountsForRoom(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["rates"] = rates
+	data["discounts"] = discounts
+
+	render.Template(w, r, "admin-room-rates.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostSeasonalRate adds a seasonal rate to a room
+func (m *Repository) AdminPostSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := roomIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "start_date", "end_date", "nightly_rate")
+
+	layout := "02-01-2006"
+	rate := models.SeasonalRate{
+		RoomID: id,
+		Name:   r.Form.Get("name"),
+	}
+
+	rate.StartDate, err = time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Enter a date like 01-07-2050")
+	}
+
+	rate.EndDate, err = time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Enter a date like 31-08-2050")
+	} else if rate.EndDate.Before(rate.StartDate) {
+		form.Errors.Add("end_date", "The season must end after it starts")
+	}
+
+	rate.NightlyRate, err = pricing.ParseAmount(r.Form.Get("nightly_rate"))
+	if err != nil {
+		form.Errors.Add("nightly_rate", "Enter a nightly rate, like 120.00")
+	}
+
+	if !form.Valid() {
+		m.renderRoomRates(w, r, form)
+		return
+	}
+
+	err = m.DB.InsertSeasonalRate(rate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionAddRate, audit.EntityRoom, id, nil, rate)
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", id), http.StatusSeeOther)
+}
+
+// AdminDeleteSeasonalRate removes a seasonal rate from a room
+func (m *Repository) AdminDeleteSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	// url is /admin/rooms/{id}/rates/{rate_id}/delete
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 6 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	rateID, err := strconv.Atoi(exploded[5])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteSeasonalRate(id, rateID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionDeleteRate, audit.EntityRoom, id, struct{ RateID int }{rateID}, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", id), http.StatusSeeOther)
+}
+
+// AdminPostStayDiscount adds a length of stay discount to a room
+func (m *Repository) AdminPostStayDiscount(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := roomIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("min_nights", "percent")
+
+	discount := models.StayDiscount{
+		RoomID: id,
+	}
+
+	discount.MinNights, err = strconv.Atoi(r.Form.Get("min_nights"))
+	if err != nil || discount.MinNights < 1 {
+		form.Errors.Add("min_nights", "Enter a number of nights")
+	}
+
+	discount.Percent, err = strconv.Atoi(r.Form.Get("percent"))
+	if err != nil || discount.Percent < 1 || discount.Percent > 100 {
+		form.Errors.Add("percent", "Enter a percentage between 1 and 100")
+	}
+
+	if !form.Valid() {
+		m.renderRoomRates(w, r, form)
+		return
+	}
+
+	err = m.DB.InsertStayDiscount(discount)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionAddDiscount, audit.EntityRoom, id, nil, discount)
+
+	m.App.Session.Put(r.Context(), "flash", "Discount added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", id), http.StatusSeeOther)
+}
+
+// AdminDeleteStayDiscount removes a length of stay discount from a room
+func (m *Repository) AdminDeleteStayDiscount(w http.ResponseWriter, r *http.Request) {
+	// url is /admin/rooms/{id}/discounts/{discount_id}/delete
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 6 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	discountID, err := strconv.Atoi(exploded[5])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteStayDiscount(id, discountID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionDeleteDiscount, audit.EntityRoom, id, struct{ DiscountID int }{discountID}, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Discount deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", id), http.StatusSeeOther)
+}
+
+// RoomCalendar serves the reservations and blocks of a room as an iCalendar feed, for other
+// booking sites to import. The url is /rooms/{slug}/calendar.ics?token={token}
+func (m *Repository) RoomCalendar(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	room, err := m.DB.GetRoomBySlug(exploded[2])
+	if err == sql.ErrNoRows {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// a wrong token looks the same as a missing room
+	token := r.URL.Query().Get("token")
+	if room.ICalToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(room.ICalToken)) != 1 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	end := start.AddDate(2, 0, 0)
+
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, start, end)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var events []ical.Event
+	for _, x := range restrictions {
+		// nights imported from other sites are not sent back to them, and holds are over before
+		// they would see them
+		if x.RestrictionID == ical.ExternalRestrictionID || x.RestrictionID == holds.RestrictionID {
+			continue
+		}
+
+		summary := "Blocked"
+		if x.ReservationID > 0 {
+			summary = "Reserved"
+		}
+
+		events = append(events, ical.Event{
+			UID:     fmt.Sprintf("restriction-%d@bookings", x.ID),
+			Start:   x.StartDate,
+			End:     x.EndDate,
+			Summary: summary,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%s.ics", room.Slug))
+	err = ical.Write(w, room.RoomName, events)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// AdminRoomCalendars shows the calendar feed of a room and the external calendars it imports
+func (m *Repository) AdminRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	m.renderRoomCalendars(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderRoomCalendars(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	id, err := roomIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	feeds, err := m.DB.GetCalendarFeedsForRoom(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	if room.ICalToken != "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		stringMap["feed_url"] = fmt.Sprintf("%s://%s/rooms/%s/calendar.ics?token=%s", scheme, r.Host, room.Slug, room.ICalToken)
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["feeds"] = feeds
+
+	render.Template(w, r, "admin-room-calendars.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostCalendarFeed adds an external calendar to a room and imports it
+func (m *Repository) AdminPostCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := roomIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "url")
+
+	if !form.Valid() {
+		m.renderRoomCalendars(w, r, form)
+		return
+	}
+
+	feed := models.CalendarFeed{
+		RoomID: id,
+		Name:   r.Form.Get("name"),
+		URL:    strings.TrimSpace(r.Form.Get("url")),
+	}
+
+	err = m.DB.InsertCalendarFeed(feed)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionAddCalendar, audit.EntityRoom, id, nil, feed)
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar added, it will be imported shortly")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", id), http.StatusSeeOther)
+}
+
+// calendarFeedFromURL reads the room and feed ids of a url like /admin/rooms/{id}/calendars/{feed_id}/delete
+func calendarFeedFromURL(r *http.Request) (int, int, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 6 {
+		return 0, 0, errors.New("missing calendar id")
+	}
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	feedID, err := strconv.Atoi(exploded[5])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return id, feedID, nil
+}
+
+// AdminDeleteCalendarFeed stops importing an external calendar and frees the nights it blocked
+func (m *Repository) AdminDeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	id, feedID, err := calendarFeedFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteCalendarFeed(id, feedID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionDeleteCalendar, audit.EntityRoom, id, struct{ CalendarFeedID int }{feedID}, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar removed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", id), http.StatusSeeOther)
+}
+
+// AdminSyncCalendarFeed imports an external calendar right away
+func (m *Repository) AdminSyncCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	id, feedID, err := calendarFeedFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	feed, err := m.DB.GetCalendarFeedByID(feedID)
+	if err == sql.ErrNoRows || (err == nil && feed.RoomID != id) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = ical.Sync(m.DB, feed)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Can't import %s: %s", feed.Name, err))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Calendar imported")
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", id), http.StatusSeeOther)
+}
+
+// AdminResetCalendarToken gives the calendar feed of a room a new secret url, so the old one stops working
+func (m *Repository) AdminResetCalendarToken(w http.ResponseWriter, r *http.Request) {
+	id, err := roomIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	token, err := ical.NewToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateRoomICalToken(id, token)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionResetCalendarLink, audit.EntityRoom, id, nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "The calendar feed has a new link")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", id), http.StatusSeeOther)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -12,7 +13,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
 	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/payments"
+	"github.com/adrialopezbou/bookings-go/internal/pricing"
 )
 
 type postData struct {
@@ -28,8 +32,14 @@ var theTests = []struct {
 }{
 	{"home", "/", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
-	{"gq", "/generals-quarters", "GET", http.StatusOK},
-	{"ms", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"gq", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"ms", "/rooms/majors-suite", "GET", http.StatusOK},
+	{"missing room", "/rooms/missing-room", "GET", http.StatusNotFound},
+	{"room calendar", "/rooms/generals-quarters/calendar.ics?token=calendar-token", "GET", http.StatusOK},
+	{"room calendar wrong token", "/rooms/generals-quarters/calendar.ics?token=guess", "GET", http.StatusNotFound},
+	{"room calendar without token", "/rooms/majors-suite/calendar.ics", "GET", http.StatusNotFound},
+	{"missing room calendar", "/rooms/missing-room/calendar.ics?token=calendar-token", "GET", http.StatusNotFound},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
 	{"mr", "/make-reservation", "GET", http.StatusOK},
@@ -73,7 +83,9 @@ func TestHandlers(t *testing.T) {
 
 func TestRepository_Reservation(t *testing.T) {
 	reservation := models.Reservation{
-		RoomID: 1,
+		StartDate: time.Date(2050, time.January, 3, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, time.January, 5, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
 		Room: models.Room{
 			ID: 1,
 			RoomName: "General's Quarters",
@@ -120,6 +132,21 @@ func TestRepository_Reservation(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
+
+	// test case where the stay has no nights to price
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+	reservation.RoomID = 1
+	reservation.EndDate = reservation.StartDate
+	session.Put(ctx, "reservation", reservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
 }
 
 func TestRepository_PostReservation(t *testing.T) {
@@ -161,6 +188,7 @@ func TestRepository_PostReservation(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
+	mailRecorder.Reset()
 	handler := http.HandlerFunc(Repo.PostReservation)
 
 	handler.ServeHTTP(rr, req)
@@ -169,6 +197,29 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("post reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
+	// the booking mails the guest and the owner, and nobody else
+	expectedMail := []struct {
+		to       string
+		subject  string
+		template string
+	}{
+		{"adria@lopez.es", "Reservation Confirmation", mailer.TemplateReservationConfirmation},
+		{"me@here.com", "Reservation Notification", mailer.TemplateReservationNotification},
+	}
+	sent := mailRecorder.Messages()
+	if len(sent) != len(expectedMail) {
+		t.Fatalf("expected %d emails but got %d: %+v", len(expectedMail), len(sent), sent)
+	}
+	for i, e := range expectedMail {
+		if sent[i].To != e.to || sent[i].Subject != e.subject || sent[i].Template != e.template {
+			t.Errorf("for email %d, expected %q to %s with %s but got %q to %s with %s", i, e.subject, e.to, e.template, sent[i].Subject, sent[i].To, sent[i].Template)
+		}
+		data, ok := sent[i].Data.(models.ReservationMail)
+		if !ok || data.FirstName != "adria" || !data.StartDate.Equal(sd) {
+			t.Errorf("for email %d, expected the reservation of adria but got %+v", i, sent[i].Data)
+		}
+	}
+
 	// test for missing post body
 	req, _ = http.NewRequest("POST", "/make-reservation", nil)
 	ctx = getCtx(req)
@@ -265,6 +316,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	}
 
 	sessionalRes.RoomID = 2
+	sessionalRes.Quote = pricing.Quote{Nights: []pricing.Night{{Date: sd, Rate: 10000}}, Total: 10000}
 	session.Put(ctx, "reservation", sessionalRes)
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -300,6 +352,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	}
 
 	sessionalRes.RoomID = 1000
+	sessionalRes.Quote = pricing.Quote{Nights: []pricing.Night{{Date: sd, Rate: 10000}}, Total: 10000}
 	session.Put(ctx, "reservation", sessionalRes)
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -314,6 +367,163 @@ func TestRepository_PostReservation(t *testing.T) {
 	}
 }
 
+func TestRepository_PostReservationPayment(t *testing.T) {
+	app.DepositPercent = 30
+	defer func() {
+		app.DepositPercent = 0
+	}()
+
+	sd, _ := time.Parse("02-01-2006", "01-01-2050")
+	ed, _ := time.Parse("02-01-2006", "02-01-2050")
+	quote := pricing.Quote{Nights: []pricing.Night{{Date: sd, Rate: 10000}}, Total: 10000, Currency: pricing.Currency}
+
+	var tests = []struct {
+		name               string
+		roomID             int
+		card               string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"deposit taken", 1, "4242424242424242", http.StatusSeeOther, "/reservation-summary"},
+		{"card declined", 1, payments.DeclinedCard, http.StatusOK, ""},
+		{"missing card", 1, "", http.StatusOK, ""},
+		{"booking fails", 1000, "4242424242424242", http.StatusTemporaryRedirect, "/"},
+		{"room taken", 3, "4242424242424242", http.StatusSeeOther, "/search-availability"},
+	}
+
+	// only the deposit of a booked room stays taken from the card of the guest, and only a booked room
+	// is confirmed
+	gateway := app.Payments.(*payments.FakeGateway)
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("first_name", "adria")
+		postedData.Add("last_name", "lopez")
+		postedData.Add("email", "adria@lopez.es")
+		postedData.Add("phone", "66582")
+		postedData.Add("payment_token", e.card)
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		session.Put(ctx, "reservation", models.Reservation{
+			StartDate: sd,
+			EndDate:   ed,
+			RoomID:    e.roomID,
+			Quote:     quote,
+		})
+
+		held := gateway.Held()
+		mailRecorder.Reset()
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		booked := e.expectedLocation == "/reservation-summary"
+		expectedHeld, expectedMail := 0, 0
+		if booked {
+			expectedHeld, expectedMail = 3000, 2
+		}
+		if gateway.Held()-held != expectedHeld {
+			t.Errorf("%s: expected %d left taken from the card but got %d", e.name, expectedHeld, gateway.Held()-held)
+		}
+		if sent := mailRecorder.Messages(); len(sent) != expectedMail {
+			t.Errorf("%s: expected %d emails but got %d", e.name, expectedMail, len(sent))
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("%s: expected location %s but got none", e.name, e.expectedLocation)
+			} else if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if booked && session.GetInt(ctx, "amount_paid") != 3000 {
+			t.Errorf("%s: expected a deposit of 3000 but got %d", e.name, session.GetInt(ctx, "amount_paid"))
+		}
+	}
+}
+
+func TestRepository_PostReservationTaken(t *testing.T) {
+	// room 3 of the test repo is booked by someone else while the guest fills in the form
+	postedData := url.Values{}
+	postedData.Add("first_name", "adria")
+	postedData.Add("last_name", "lopez")
+	postedData.Add("email", "adria@lopez.es")
+	postedData.Add("phone", "66582")
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	sd, _ := time.Parse("02-01-2006", "01-01-2050")
+	ed, _ := time.Parse("02-01-2006", "02-01-2050")
+	session.Put(ctx, "reservation", models.Reservation{
+		StartDate: sd,
+		EndDate:   ed,
+		RoomID:    3,
+		Quote:     pricing.Quote{Nights: []pricing.Night{{Date: sd, Rate: 10000}}, Total: 10000},
+	})
+
+	mailRecorder.Reset()
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if loc, err := rr.Result().Location(); err != nil || loc.String() != "/search-availability" {
+		t.Errorf("expected the guest to be sent to search again but got %v", loc)
+	}
+	if !strings.Contains(session.GetString(ctx, "error"), "just booked") {
+		t.Errorf("expected the guest to be told the room was just booked but got %q", session.GetString(ctx, "error"))
+	}
+	if sent := mailRecorder.Messages(); len(sent) != 0 {
+		t.Errorf("expected no emails but got %+v", sent)
+	}
+}
+
+func TestRepository_PaymentWebhook(t *testing.T) {
+	gateway := app.Payments.(*payments.FakeGateway)
+	other := payments.NewFakeGateway("other secret")
+
+	var tests = []struct {
+		name               string
+		gateway            *payments.FakeGateway
+		eventType          string
+		paymentID          string
+		expectedStatusCode int
+	}{
+		{"refund", gateway, payments.EventRefunded, "fake_1", http.StatusOK},
+		{"unknown event", gateway, "payment.disputed", "fake_1", http.StatusOK},
+		{"unknown payment", gateway, payments.EventRefunded, "fake_404", http.StatusNotFound},
+		{"bad signature", other, payments.EventRefunded, "fake_1", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		body, sig := e.gateway.Webhook(e.eventType, e.paymentID)
+
+		req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewReader(body))
+		req.Header.Set(payments.FakeSignatureHeader, sig)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PaymentWebhook).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
 func TestRepository_AvailabilityJSON(t *testing.T) {
 	// tests when rooms are not available
 	reqBody := "start=01-01-2050"
@@ -332,6 +542,7 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	handler.ServeHTTP(rr, req)
+	checkAPIResponse(t, "unavailable room", req, rr)
 
 	var j jsonResponse
 	err := json.Unmarshal(rr.Body.Bytes(), &j)
@@ -352,6 +563,7 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	rr = httptest.NewRecorder()
 
 	handler.ServeHTTP(rr, req)
+	checkAPIResponse(t, "empty body", req, rr)
 	err = json.Unmarshal(rr.Body.Bytes(), &j)
 	if err != nil {
 		t.Error("failed to parse json")
@@ -378,6 +590,7 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 	rr = httptest.NewRecorder()
 
 	handler.ServeHTTP(rr, req)
+	checkAPIResponse(t, "database error", req, rr)
 	err = json.Unmarshal(rr.Body.Bytes(), &j)
 	if err != nil {
 		t.Error("failed to parse json")
@@ -387,6 +600,449 @@ func TestRepository_AvailabilityJSON(t *testing.T) {
 		t.Error("didn't return an error when failing inserting into database")
 	}
 
+	// test for an available room, which is priced
+	reqBody = "start=01-01-2051"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=03-01-2051")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
+
+	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody))
+
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+	checkAPIResponse(t, "available room", req, rr)
+
+	j = jsonResponse{}
+	err = json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Error("failed to parse json")
+	}
+
+	if !j.Ok || j.Quote == nil {
+		t.Error("available room was not priced")
+	}
+}
+
+var adminReservationTests = []struct {
+	name               string
+	url                string
+	method             string
+	postedData         url.Values
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"new reservations", "/admin/reservations-new", "GET", nil, (*Repository).AdminNewReservations, http.StatusOK, ""},
+	{"all reservations", "/admin/reservations-all", "GET", nil, (*Repository).AdminAllReservations, http.StatusOK, ""},
+	{"show reservation", "/admin/reservations/new/1/show", "GET", nil, (*Repository).AdminShowReservation, http.StatusOK, ""},
+	{"show missing reservation", "/admin/reservations/new/100/show", "GET", nil, (*Repository).AdminShowReservation, http.StatusInternalServerError, ""},
+	{"show bad id", "/admin/reservations/new/x/show", "GET", nil, (*Repository).AdminShowReservation, http.StatusBadRequest, ""},
+	{"post reservation", "/admin/reservations/all/1", "POST", url.Values{
+		"first_name": {"adria"},
+		"last_name":  {"lopez"},
+		"email":      {"adria@lopez.es"},
+		"phone":      {"66582"},
+	}, (*Repository).AdminPostShowReservation, http.StatusSeeOther, "/admin/reservations-all"},
+	{"post invalid reservation", "/admin/reservations/all/1", "POST", url.Values{
+		"first_name": {"a"},
+		"last_name":  {"lopez"},
+		"email":      {"adria"},
+	}, (*Repository).AdminPostShowReservation, http.StatusOK, ""},
+	{"post reservation update fails", "/admin/reservations/all/2", "POST", url.Values{
+		"first_name": {"adria"},
+		"last_name":  {"lopez"},
+		"email":      {"adria@lopez.es"},
+	}, (*Repository).AdminPostShowReservation, http.StatusInternalServerError, ""},
+	{"process reservation", "/admin/process-reservation/new/1", "POST", url.Values{}, (*Repository).AdminProcessReservation, http.StatusSeeOther, "/admin/reservations-new"},
+	{"process reservation fails", "/admin/process-reservation/new/2", "POST", url.Values{}, (*Repository).AdminProcessReservation, http.StatusInternalServerError, ""},
+	{"delete reservation", "/admin/delete-reservation/all/1", "POST", url.Values{}, (*Repository).AdminDeleteReservation, http.StatusSeeOther, "/admin/reservations-all"},
+	{"delete reservation fails", "/admin/delete-reservation/all/2", "POST", url.Values{}, (*Repository).AdminDeleteReservation, http.StatusInternalServerError, ""},
+}
+
+func TestRepository_AdminReservations(t *testing.T) {
+	for _, e := range adminReservationTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+	}
+}
+
+func TestRepository_AdminReservationsCalendar(t *testing.T) {
+	tests := []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"current month", "/admin/reservation-calendar", http.StatusOK},
+		{"given month", "/admin/reservation-calendar?y=2050&m=01", http.StatusOK},
+		{"invalid month", "/admin/reservation-calendar?y=2050&m=13", http.StatusBadRequest},
+		{"invalid year", "/admin/reservation-calendar?y=x&m=01", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendar(t *testing.T) {
+	tests := []struct {
+		name               string
+		postedData         url.Values
+		blockMap           map[string]int
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{
+			"add and remove blocks",
+			url.Values{
+				"y":                        {"2050"},
+				"m":                        {"01"},
+				"add_block_1_2050-01-2":    {"1"},
+				"remove_block_1_2050-01-4": {"3"},
+			},
+			map[string]int{"2050-01-3": 2, "2050-01-4": 3},
+			http.StatusSeeOther,
+			"/admin/reservation-calendar?y=2050&m=1",
+		},
+		{
+			"unblock a night of a longer block",
+			url.Values{
+				"y":                        {"2050"},
+				"m":                        {"01"},
+				"remove_block_1_2050-01-4": {"3"},
+			},
+			map[string]int{"2050-01-4": 3, "2050-01-5": 3, "2050-01-6": 3},
+			http.StatusSeeOther,
+			"/admin/reservation-calendar?y=2050&m=1",
+		},
+		{
+			"block held room",
+			url.Values{
+				"y":                     {"2050"},
+				"m":                     {"01"},
+				"add_block_2_2050-01-2": {"1"},
+			},
+			map[string]int{},
+			http.StatusSeeOther,
+			"/admin/reservation-calendar?y=2050&m=1",
+		},
+		{
+			"missing block map",
+			url.Values{
+				"y": {"2050"},
+				"m": {"01"},
+			},
+			nil,
+			http.StatusSeeOther,
+			"/admin/reservation-calendar?y=2050&m=1",
+		},
+		{
+			"missing year",
+			url.Values{
+				"m": {"01"},
+			},
+			map[string]int{},
+			http.StatusBadRequest,
+			"",
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservation-calendar", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		if e.blockMap != nil {
+			session.Put(ctx, "block_map_1", e.blockMap)
+		}
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+
+		// room 2 of the test repo is held by another guest
+		taken := strings.Contains(session.GetString(ctx, "error"), "booked")
+		if taken != (e.postedData.Get("add_block_2_2050-01-2") != "") {
+			t.Errorf("for %s, expected the error about the night taken to be shown %t", e.name, !taken)
+		}
+	}
+}
+
+var adminRoomTests = []struct {
+	name               string
+	url                string
+	method             string
+	postedData         url.Values
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"list rooms", "/admin/rooms", "GET", nil, (*Repository).AdminRooms, http.StatusOK, ""},
+	{"new room", "/admin/rooms/new", "GET", nil, (*Repository).AdminShowRoom, http.StatusOK, ""},
+	{"edit room", "/admin/rooms/1", "GET", nil, (*Repository).AdminShowRoom, http.StatusOK, ""},
+	{"edit missing room", "/admin/rooms/100", "GET", nil, (*Repository).AdminShowRoom, http.StatusInternalServerError, ""},
+	{"edit bad id", "/admin/rooms/x", "GET", nil, (*Repository).AdminShowRoom, http.StatusBadRequest, ""},
+	{"create room", "/admin/rooms/new", "POST", url.Values{
+		"room_name": {"Colonel's Cabin"},
+		"slug":      {"colonels-cabin"},
+		"capacity":  {"4"},
+		"base_rate": {"120.00"},
+		"amenities": {"Sea view\r\nFireplace"},
+	}, (*Repository).AdminPostRoom, http.StatusSeeOther, "/admin/rooms"},
+	{"create room with used slug", "/admin/rooms/new", "POST", url.Values{
+		"room_name": {"Colonel's Cabin"},
+		"slug":      {"majors-suite"},
+		"capacity":  {"4"},
+		"base_rate": {"120.00"},
+	}, (*Repository).AdminPostRoom, http.StatusOK, ""},
+	{"create invalid room", "/admin/rooms/new", "POST", url.Values{
+		"room_name": {"Colonel's Cabin"},
+		"slug":      {"Colonel's Cabin"},
+		"capacity":  {"none"},
+	}, (*Repository).AdminPostRoom, http.StatusOK, ""},
+	{"create room fails", "/admin/rooms/new", "POST", url.Values{
+		"room_name": {"Colonel's Cabin"},
+		"slug":      {"fail"},
+		"capacity":  {"4"},
+		"base_rate": {"120.00"},
+	}, (*Repository).AdminPostRoom, http.StatusInternalServerError, ""},
+	{"update room", "/admin/rooms/1", "POST", url.Values{
+		"room_name": {"General's Quarters"},
+		"slug":      {"generals-quarters"},
+		"capacity":  {"2"},
+		"base_rate": {"120.00"},
+	}, (*Repository).AdminPostRoom, http.StatusSeeOther, "/admin/rooms"},
+	{"update room fails", "/admin/rooms/2", "POST", url.Values{
+		"room_name": {"Major's Suite"},
+		"slug":      {"majors-suite-2"},
+		"capacity":  {"2"},
+		"base_rate": {"120.00"},
+	}, (*Repository).AdminPostRoom, http.StatusInternalServerError, ""},
+	{"retire room", "/admin/rooms/1/retire", "POST", url.Values{}, (*Repository).AdminRetireRoom, http.StatusSeeOther, "/admin/rooms"},
+	{"retire room fails", "/admin/rooms/2/retire", "POST", url.Values{}, (*Repository).AdminRetireRoom, http.StatusInternalServerError, ""},
+	{"restore room", "/admin/rooms/1/restore", "POST", url.Values{}, (*Repository).AdminRestoreRoom, http.StatusSeeOther, "/admin/rooms"},
+	{"restore new room", "/admin/rooms/new/restore", "POST", url.Values{}, (*Repository).AdminRestoreRoom, http.StatusBadRequest, ""},
+}
+
+func TestRepository_AdminRooms(t *testing.T) {
+	for _, e := range adminRoomTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+	}
+}
+
+var adminRoomRatesTests = []struct {
+	name               string
+	url                string
+	method             string
+	postedData         url.Values
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"show rates", "/admin/rooms/1/rates", "GET", nil, (*Repository).AdminRoomRates, http.StatusOK, ""},
+	{"show rates fails", "/admin/rooms/2/rates", "GET", nil, (*Repository).AdminRoomRates, http.StatusInternalServerError, ""},
+	{"show rates of new room", "/admin/rooms/new/rates", "GET", nil, (*Repository).AdminRoomRates, http.StatusBadRequest, ""},
+	{"add season", "/admin/rooms/1/rates", "POST", url.Values{
+		"name":         {"Summer"},
+		"start_date":   {"01-07-2050"},
+		"end_date":     {"31-08-2050"},
+		"nightly_rate": {"150.00"},
+	}, (*Repository).AdminPostSeasonalRate, http.StatusSeeOther, "/admin/rooms/1/rates"},
+	{"add invalid season", "/admin/rooms/1/rates", "POST", url.Values{
+		"name":         {"Summer"},
+		"start_date":   {"31-08-2050"},
+		"end_date":     {"01-07-2050"},
+		"nightly_rate": {"lots"},
+	}, (*Repository).AdminPostSeasonalRate, http.StatusOK, ""},
+	{"delete season", "/admin/rooms/1/rates/1/delete", "POST", url.Values{}, (*Repository).AdminDeleteSeasonalRate, http.StatusSeeOther, "/admin/rooms/1/rates"},
+	{"delete season fails", "/admin/rooms/1/rates/2/delete", "POST", url.Values{}, (*Repository).AdminDeleteSeasonalRate, http.StatusInternalServerError, ""},
+	{"add discount", "/admin/rooms/1/discounts", "POST", url.Values{
+		"min_nights": {"7"},
+		"percent":    {"10"},
+	}, (*Repository).AdminPostStayDiscount, http.StatusSeeOther, "/admin/rooms/1/rates"},
+	{"add invalid discount", "/admin/rooms/1/discounts", "POST", url.Values{
+		"min_nights": {"0"},
+		"percent":    {"200"},
+	}, (*Repository).AdminPostStayDiscount, http.StatusOK, ""},
+	{"delete discount", "/admin/rooms/1/discounts/1/delete", "POST", url.Values{}, (*Repository).AdminDeleteStayDiscount, http.StatusSeeOther, "/admin/rooms/1/rates"},
+	{"delete discount fails", "/admin/rooms/1/discounts/2/delete", "POST", url.Values{}, (*Repository).AdminDeleteStayDiscount, http.StatusInternalServerError, ""},
+}
+
+func TestRepository_AdminRoomRates(t *testing.T) {
+	for _, e := range adminRoomRatesTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+	}
+}
+
+var adminRoomCalendarsTests = []struct {
+	name               string
+	url                string
+	method             string
+	postedData         url.Values
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"show calendars", "/admin/rooms/1/calendars", "GET", nil, (*Repository).AdminRoomCalendars, http.StatusOK, ""},
+	{"show calendars fails", "/admin/rooms/2/calendars", "GET", nil, (*Repository).AdminRoomCalendars, http.StatusInternalServerError, ""},
+	{"show calendars bad id", "/admin/rooms/x/calendars", "GET", nil, (*Repository).AdminRoomCalendars, http.StatusBadRequest, ""},
+	{"add calendar", "/admin/rooms/1/calendars", "POST", url.Values{
+		"name": {"Other site"},
+		"url":  {"https://example.com/calendar.ics"},
+	}, (*Repository).AdminPostCalendarFeed, http.StatusSeeOther, "/admin/rooms/1/calendars"},
+	{"add calendar without url", "/admin/rooms/1/calendars", "POST", url.Values{
+		"name": {"Other site"},
+	}, (*Repository).AdminPostCalendarFeed, http.StatusOK, ""},
+	{"add calendar fails", "/admin/rooms/2/calendars", "POST", url.Values{
+		"name": {"Other site"},
+		"url":  {"https://example.com/calendar.ics"},
+	}, (*Repository).AdminPostCalendarFeed, http.StatusInternalServerError, ""},
+	{"sync calendar", "/admin/rooms/1/calendars/1/sync", "POST", url.Values{}, (*Repository).AdminSyncCalendarFeed, http.StatusSeeOther, "/admin/rooms/1/calendars"},
+	{"sync calendar of another room", "/admin/rooms/3/calendars/1/sync", "POST", url.Values{}, (*Repository).AdminSyncCalendarFeed, http.StatusNotFound, ""},
+	{"sync missing calendar", "/admin/rooms/1/calendars/5/sync", "POST", url.Values{}, (*Repository).AdminSyncCalendarFeed, http.StatusNotFound, ""},
+	{"delete calendar", "/admin/rooms/1/calendars/1/delete", "POST", url.Values{}, (*Repository).AdminDeleteCalendarFeed, http.StatusSeeOther, "/admin/rooms/1/calendars"},
+	{"delete calendar fails", "/admin/rooms/1/calendars/2/delete", "POST", url.Values{}, (*Repository).AdminDeleteCalendarFeed, http.StatusInternalServerError, ""},
+	{"delete calendar bad id", "/admin/rooms/1/calendars/x/delete", "POST", url.Values{}, (*Repository).AdminDeleteCalendarFeed, http.StatusBadRequest, ""},
+	{"reset token", "/admin/rooms/1/calendars/token", "POST", url.Values{}, (*Repository).AdminResetCalendarToken, http.StatusSeeOther, "/admin/rooms/1/calendars"},
+	{"reset token fails", "/admin/rooms/2/calendars/token", "POST", url.Values{}, (*Repository).AdminResetCalendarToken, http.StatusInternalServerError, ""},
+}
+
+func TestRepository_AdminRoomCalendars(t *testing.T) {
+	for _, e := range adminRoomCalendarsTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+	}
 }
 
 func getCtx(r *http.Request) context.Context {
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/holds"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/repository"
+)
+
+// roomTakenMessage tells a guest the room they were booking was taken by someone else meanwhile
+const roomTakenMessage = "Sorry, this room was just booked by someone else for these dates, please search again"
+
+// holdResponse is how long the room of the reservation form is still held for the guest
+type holdResponse struct {
+	Ok          bool   `json:"ok"`
+	Message     string `json:"message"`
+	SecondsLeft int    `json:"seconds_left"`
+}
+
+// holdRoom holds the room of res for the guest, letting go of the one they held before, if any. It
+// returns repository.ErrRoomTaken when the room is no longer free
+func (m *Repository) holdRoom(r *http.Request, res models.Reservation) error {
+	m.releaseHold(r)
+
+	expiresAt := time.Now().Add(holds.Duration)
+	id, err := m.DB.HoldRoom(res.RoomID, res.StartDate, res.EndDate, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	m.App.Session.Put(r.Context(), "hold_id", id)
+	m.App.Session.Put(r.Context(), "hold_expires_at", int(expiresAt.Unix()))
+	return nil
+}
+
+// holdChosenRoom holds the room a guest chose to book, sending them to search again when it was
+// taken since they found it free. It reports whether the room is held
+func (m *Repository) holdChosenRoom(w http.ResponseWriter, r *http.Request, res models.Reservation) bool {
+	err := m.holdRoom(r, res)
+	if errors.Is(err, repository.ErrRoomTaken) {
+		m.App.Session.Put(r.Context(), "error", roomTakenMessage)
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return false
+	}
+	return true
+}
+
+// extendHold keeps the room of res held for the guest, who is still booking it. When their hold
+// has expired the room is held again, if it is still free
+func (m *Repository) extendHold(r *http.Request, res models.Reservation) error {
+	if id := m.App.Session.GetInt(r.Context(), "hold_id"); id > 0 {
+		expiresAt := time.Now().Add(holds.Duration)
+		extended, err := m.DB.ExtendHold(id, expiresAt)
+		if err != nil {
+			return err
+		}
+		if extended {
+			m.App.Session.Put(r.Context(), "hold_expires_at", int(expiresAt.Unix()))
+			return nil
+		}
+	}
+
+	return m.holdRoom(r, res)
+}
+
+// releaseHold lets go of the room held for the guest, if any. Failing to is logged, since the hold
+// expires anyway
+func (m *Repository) releaseHold(r *http.Request) {
+	m.App.Session.Remove(r.Context(), "hold_expires_at")
+	id := m.App.Session.PopInt(r.Context(), "hold_id")
+	if id == 0 {
+		return
+	}
+
+	err := m.DB.ReleaseHold(id)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// holdLeft returns how long the room is still held for the guest
+func (m *Repository) holdLeft(r *http.Request) time.Duration {
+	expires := m.App.Session.GetInt(r.Context(), "hold_expires_at")
+	if expires == 0 {
+		return 0
+	}
+	return holds.Remaining(time.Unix(int64(expires), 0), time.Now())
+}
+
+// PostReservationHold keeps the room of the reservation form held while the guest fills it in,
+// and returns how long it is held for
+func (m *Repository) PostReservationHold(w http.ResponseWriter, r *http.Request) {
+	resp := holdResponse{Ok: true}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		resp = holdResponse{Message: "There is no reservation to hold the room for"}
+	} else if err := m.extendHold(r, res); errors.Is(err, repository.ErrRoomTaken) {
+		resp = holdResponse{Message: roomTakenMessage}
+	} else if err != nil {
+		m.App.ErrorLog.Println(err)
+		resp = holdResponse{Message: "The room could not be held, please try again"}
+	} else {
+		resp.SecondsLeft = int(m.holdLeft(r).Seconds())
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
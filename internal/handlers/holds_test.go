@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/holds"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+func TestRepository_HoldChosenRoom(t *testing.T) {
+	// room 2 of the test repo is held by another guest
+	tests := []struct {
+		name               string
+		url                string
+		handler            func(*Repository, http.ResponseWriter, *http.Request)
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"choose room", "/choose-room/1", (*Repository).ChooseRoom, http.StatusSeeOther, "/make-reservation"},
+		{"choose held room", "/choose-room/2", (*Repository).ChooseRoom, http.StatusSeeOther, "/search-availability"},
+		{"book room", "/book-room?id=1&s=10-02-2051&e=12-02-2051", (*Repository).BookRoom, http.StatusSeeOther, "/make-reservation"},
+		{"book held room", "/book-room?id=2&s=10-02-2051&e=12-02-2051", (*Repository).BookRoom, http.StatusSeeOther, "/search-availability"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		req.RequestURI = e.url
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", models.Reservation{
+			StartDate: time.Date(2051, 2, 10, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2051, 2, 12, 0, 0, 0, 0, time.UTC),
+		})
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		location, err := rr.Result().Location()
+		if err != nil || location.String() != e.expectedLocation {
+			t.Errorf("for %s, expected location %s but got %v", e.name, e.expectedLocation, location)
+		}
+
+		// the guest holds the room they are booking, or none
+		held := session.GetInt(ctx, "hold_id") > 0
+		if held != (e.expectedLocation == "/make-reservation") {
+			t.Errorf("for %s, expected the room held to be %t", e.name, !held)
+		}
+	}
+}
+
+func TestRepository_ReservationHold(t *testing.T) {
+	// the form shows how long the room is held
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", models.Reservation{
+		StartDate: time.Date(2051, 2, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2051, 2, 12, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+	})
+
+	rr := httptest.NewRecorder()
+	Repo.Reservation(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `id="hold-notice"`) {
+		t.Errorf("expected the form with the room held but got %d", rr.Code)
+	}
+	if session.GetInt(ctx, "hold_id") == 0 {
+		t.Error("expected the room to be held")
+	}
+}
+
+func TestRepository_PostReservationHold(t *testing.T) {
+	// hold 1 of the test repo is still held and any other expired, and room 2 is held by another guest
+	tests := []struct {
+		name     string
+		roomID   int
+		holdID   int
+		expected bool
+	}{
+		{"extended", 1, 1, true},
+		{"held again after expiring", 1, 2, true},
+		{"held for the first time", 1, 0, true},
+		{"taken after expiring", 2, 2, false},
+		{"no reservation", 0, 1, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/make-reservation/hold", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.roomID > 0 {
+			session.Put(ctx, "reservation", models.Reservation{
+				StartDate: time.Date(2051, 2, 10, 0, 0, 0, 0, time.UTC),
+				EndDate:   time.Date(2051, 2, 12, 0, 0, 0, 0, time.UTC),
+				RoomID:    e.roomID,
+			})
+		}
+		if e.holdID > 0 {
+			session.Put(ctx, "hold_id", e.holdID)
+		}
+
+		rr := httptest.NewRecorder()
+		Repo.PostReservationHold(rr, req)
+
+		var resp holdResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &resp)
+		if err != nil {
+			t.Errorf("for %s, failed to parse json: %s", e.name, err)
+			continue
+		}
+
+		if resp.Ok != e.expected {
+			t.Errorf("for %s, expected ok to be %t but got %+v", e.name, e.expected, resp)
+		}
+		if resp.Ok && (resp.SecondsLeft <= 0 || resp.SecondsLeft > int(holds.Duration.Seconds())) {
+			t.Errorf("for %s, expected the room held for up to %s but got %d seconds", e.name, holds.Duration, resp.SecondsLeft)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+)
+
+// jobFailuresShown is how many of the latest failed runs the jobs page shows
+const jobFailuresShown = 20
+
+// AdminJobs shows the background jobs with their last and next run, and the runs that failed lately
+func (m *Repository) AdminJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := m.App.Jobs.Jobs()
+	now := time.Now()
+
+	// the last and next run of each job, by name. Jobs that never ran have no last run
+	lastRuns := make(map[string]models.JobRun)
+	nextRuns := make(map[string]time.Time)
+	for _, j := range jobs {
+		last, err := m.DB.LastJobRun(j.Name)
+		if err == nil {
+			lastRuns[j.Name] = last
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+		nextRuns[j.Name] = j.Schedule.Next(now)
+	}
+
+	failures, err := m.DB.FailedJobRuns(jobFailuresShown)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["jobs"] = jobs
+	data["last_runs"] = lastRuns
+	data["next_runs"] = nextRuns
+	data["failures"] = failures
+
+	intMap := make(map[string]int)
+	intMap["failures_shown"] = jobFailuresShown
+
+	render.Template(w, r, "admin-jobs.page.tmpl", &models.TemplateData{
+		IntMap: intMap,
+		Data:   data,
+	})
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepository_AdminJobs(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/jobs", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+
+	Repo.AdminJobs(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, expected := range []string{"calendar-sync", "never-run", "Never", "dial tcp: connection refused"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected the jobs page to show %q", expected)
+		}
+	}
+}
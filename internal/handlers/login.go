@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+const (
+	// loginFailureWindow is how long failed logins are remembered after the last one
+	loginFailureWindow = time.Hour
+	// loginLockout is how long a key stays locked after too many failed logins
+	loginLockout = 15 * time.Minute
+)
+
+// loginLimit is how many failed logins a kind of key allows before each one makes the next wait
+// twice as long as the one before, and before the key is locked out
+type loginLimit struct {
+	prefix    string
+	free      int
+	lockAfter int
+}
+
+// Failed logins are counted per account, whether or not it exists, and per address, which tries
+// many accounts before it is stopped
+var (
+	accountLoginLimit = loginLimit{prefix: "email:", free: 3, lockAfter: 10}
+	addressLoginLimit = loginLimit{prefix: "ip:", free: 20, lockAfter: 100}
+)
+
+// delay returns how long logins wait after the given number of failures, and whether that is a
+// lockout
+func (l loginLimit) delay(failures int) (time.Duration, bool) {
+	if failures >= l.lockAfter {
+		return loginLockout, true
+	}
+	if failures <= l.free {
+		return 0, false
+	}
+
+	n := failures - l.free - 1
+	if n > 10 {
+		n = 10
+	}
+	d := time.Second << uint(n)
+	if d > loginLockout {
+		d = loginLockout
+	}
+	return d, false
+}
+
+// loginKey is a key failed logins are counted under, with its limit
+type loginKey struct {
+	key   string
+	limit loginLimit
+}
+
+// accountLoginKey returns the key the failed logins of email are counted under
+func accountLoginKey(email string) string {
+	return accountLoginLimit.prefix + strings.ToLower(strings.TrimSpace(email))
+}
+
+// clientIP returns the address r comes from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginKeys returns the keys a login of r for email is counted under
+func loginKeys(r *http.Request, email string) []loginKey {
+	keys := []loginKey{{accountLoginKey(email), accountLoginLimit}}
+	if ip := clientIP(r); ip != "" {
+		keys = append(keys, loginKey{addressLoginLimit.prefix + ip, addressLoginLimit})
+	}
+	return keys
+}
+
+// loginLocked reports whether any of keys has to wait before logging in again
+func (m *Repository) loginLocked(keys []loginKey) (bool, error) {
+	for _, k := range keys {
+		throttle, err := m.DB.GetLoginThrottle(k.key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		if throttle.LockedUntil.After(time.Now()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// recordLoginFailure counts a failed login of r under keys, makes them wait before the next one and
+// records the lockouts
+func (m *Repository) recordLoginFailure(r *http.Request, keys []loginKey) error {
+	now := time.Now()
+	for _, k := range keys {
+		failures, err := m.DB.RecordLoginFailure(k.key, now.Add(-loginFailureWindow))
+		if err != nil {
+			return err
+		}
+
+		delay, lockout := k.limit.delay(failures)
+		if delay == 0 {
+			continue
+		}
+
+		err = m.DB.LockLogin(k.key, now.Add(delay))
+		if err != nil {
+			return err
+		}
+
+		if lockout {
+			m.App.InfoLog.Printf("locked out %s after %d failed logins from %s", k.key, failures, clientIP(r))
+			event := models.LockoutEvent{
+				Key:         k.key,
+				IP:          clientIP(r),
+				Failures:    failures,
+				LockedUntil: now.Add(delay),
+			}
+			err = m.DB.InsertLockoutEvent(event)
+			if err != nil {
+				return err
+			}
+			m.recordAudit(r, models.AuditEntry{Actor: k.key, Action: audit.ActionLockout}, nil, event)
+		}
+	}
+	return nil
+}
+
+// auditLoginFailure records a failed login for email in the audit log. userID is the user when it
+// is known, after their password
+func (m *Repository) auditLoginFailure(r *http.Request, userID int, email string) {
+	m.recordAudit(r, models.AuditEntry{
+		UserID:   userID,
+		Actor:    email,
+		Action:   audit.ActionLoginFailed,
+		Entity:   audit.EntityUser,
+		EntityID: userID,
+	}, nil, nil)
+}
+
+// refuseLogin sends the user back to the login page. Every failure gets the same answer, so it
+// can't be used to find out which emails have an account
+func (m *Repository) refuseLogin(w http.ResponseWriter, r *http.Request, locked bool) {
+	if locked {
+		m.App.Session.Put(r.Context(), "error", "Too many failed logins, try again later")
+	} else {
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminUnlockUser lifts the lockout of a user after too many failed logins
+func (m *Repository) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := m.DB.GetUserById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ClearLoginThrottle(accountLoginKey(user.Email))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionUnlock, audit.EntityUser, id, nil, nil)
+
+	m.App.InfoLog.Printf("user %d unlocked the login of user %d", m.App.Session.GetInt(r.Context(), "user_id"), id)
+	m.App.Session.Put(r.Context(), "flash", "User unlocked")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var loginDelayTests = []struct {
+	name            string
+	limit           loginLimit
+	failures        int
+	expectedDelay   time.Duration
+	expectedLockout bool
+}{
+	{"first failure", accountLoginLimit, 1, 0, false},
+	{"last free failure", accountLoginLimit, 3, 0, false},
+	{"first delayed failure", accountLoginLimit, 4, time.Second, false},
+	{"delay doubles", accountLoginLimit, 6, 4 * time.Second, false},
+	{"lockout", accountLoginLimit, 10, loginLockout, true},
+	{"after lockout", accountLoginLimit, 12, loginLockout, true},
+	{"address long delay", addressLoginLimit, 99, loginLockout, false},
+	{"address lockout", addressLoginLimit, 100, loginLockout, true},
+}
+
+func TestLoginLimit_delay(t *testing.T) {
+	for _, e := range loginDelayTests {
+		delay, lockout := e.limit.delay(e.failures)
+		if delay != e.expectedDelay {
+			t.Errorf("for %s, expected %s but got %s", e.name, e.expectedDelay, delay)
+		}
+		if lockout != e.expectedLockout {
+			t.Errorf("for %s, expected lockout %t but got %t", e.name, e.expectedLockout, lockout)
+		}
+	}
+}
+
+var loginTests = []struct {
+	name               string
+	email              string
+	password           string
+	expectedStatusCode int
+	expectedError      string
+}{
+	{"wrong password", "admin@here.com", "wrong", http.StatusSeeOther, "Invalid login credentials"},
+	{"unknown email", "nobody@here.com", "wrong", http.StatusSeeOther, "Invalid login credentials"},
+	{"locked out", "locked@here.com", "secret", http.StatusSeeOther, "Too many failed logins, try again later"},
+	{"reaching lockout", "guesser@here.com", "wrong", http.StatusSeeOther, "Invalid login credentials"},
+	{"database error", "admin@here.com", "fail", http.StatusInternalServerError, ""},
+}
+
+func TestRepository_PostShowLoginThrottled(t *testing.T) {
+	for _, e := range loginTests {
+		postedData := url.Values{"email": {e.email}, "password": {e.password}}
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "192.0.2.1:1234"
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		Repo.PostShowLogin(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("for %s, expected error %q but got %q", e.name, e.expectedError, msg)
+		}
+
+		if session.Exists(ctx, "user_id") {
+			t.Errorf("for %s, expected no user in the session", e.name)
+		}
+	}
+}
+
+var unlockTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+}{
+	{"unlock", "/admin/users/5/unlock", http.StatusSeeOther},
+	{"unlock bad id", "/admin/users/x/unlock", http.StatusBadRequest},
+	{"unlock fails", "/admin/users/2/unlock", http.StatusInternalServerError},
+}
+
+func TestRepository_AdminUnlockUser(t *testing.T) {
+	for _, e := range unlockTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+
+		Repo.AdminUnlockUser(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/outbox"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+)
+
+// mailPageSize is how many emails the mail page shows
+const mailPageSize = 200
+
+// mail returns an email to a guest or a user, laid out in an email template with data of the type
+// the template takes
+func (m *Repository) mail(to, subject, template string, data interface{}) models.MailData {
+	return models.MailData{
+		To:       to,
+		From:     "me@here.com",
+		Subject:  subject,
+		Template: template,
+		Data:     data,
+	}
+}
+
+// ownerMail returns an email notifying the owner
+func (m *Repository) ownerMail(subject, template string, data interface{}) models.MailData {
+	return m.mail("me@here.com", subject, template, data)
+}
+
+// sendMail sends a message to a guest or a user
+func (m *Repository) sendMail(to, subject, template string, data interface{}) {
+	m.queueMail(m.mail(to, subject, template, data))
+}
+
+// sendOwnerMail notifies the owner
+func (m *Repository) sendOwnerMail(subject, template string, data interface{}) {
+	m.queueMail(m.ownerMail(subject, template, data))
+}
+
+// reservationMail returns the data of the emails about res
+func (m *Repository) reservationMail(res models.Reservation) models.ReservationMail {
+	return models.ReservationMail{
+		FirstName:  res.FirstName,
+		LastName:   res.LastName,
+		Email:      res.Email,
+		Phone:      res.Phone,
+		RoomName:   res.Room.RoomName,
+		StartDate:  res.StartDate,
+		EndDate:    res.EndDate,
+		ManageLink: m.manageLink(res),
+	}
+}
+
+// queueMail adds mail to the outbox, to be sent by the mail workers. What the mail is about has
+// already happened, so failing to queue it is logged rather than shown to the user
+func (m *Repository) queueMail(mail ...models.MailData) {
+	err := m.DB.QueueMail(mail)
+	if err != nil {
+		for _, msg := range mail {
+			m.App.ErrorLog.Printf("cannot queue %q to %s: %s", msg.Subject, msg.To, err)
+		}
+	}
+}
+
+// mailIDFromURL returns the id of the email in urls like /admin/mail/{id}
+func mailIDFromURL(r *http.Request) (int, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 {
+		return 0, errors.New("missing mail id")
+	}
+
+	return strconv.Atoi(exploded[3])
+}
+
+// AdminMail shows the latest emails of the outbox with a status, the pending ones by default
+func (m *Repository) AdminMail(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = outbox.StatusPending
+	}
+
+	valid := false
+	for _, s := range outbox.Statuses {
+		if s == status {
+			valid = true
+		}
+	}
+	if !valid {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	mail, err := m.DB.OutboxMail(status, mailPageSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["mail"] = mail
+	data["statuses"] = outbox.Statuses
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	intMap := make(map[string]int)
+	intMap["page_size"] = mailPageSize
+	intMap["max_attempts"] = outbox.MaxAttempts
+
+	render.Template(w, r, "admin-mail.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// AdminShowMail shows an email of the outbox and how sending it went
+func (m *Repository) AdminShowMail(w http.ResponseWriter, r *http.Request) {
+	id, err := mailIDFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	mail, err := m.DB.GetOutboxMailByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["mail"] = mail
+
+	// the email is shown as it is sent, or with why it can't be
+	stringMap := make(map[string]string)
+	html, text, err := m.App.MailTemplates.Render(mail.Mail)
+	if err != nil {
+		stringMap["render_error"] = err.Error()
+	} else {
+		stringMap["html"] = html
+		stringMap["text"] = text
+	}
+
+	render.Template(w, r, "admin-mail-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminResendMail queues an email of the outbox to be sent again, like a dead one once the reason
+// it failed is fixed
+func (m *Repository) AdminResendMail(w http.ResponseWriter, r *http.Request) {
+	id, err := mailIDFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	mail, err := m.DB.GetOutboxMailByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ResendMail(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionResend, audit.EntityMail, id, nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%q to %s will be sent again", mail.Mail.Subject, mail.Mail.To))
+	http.Redirect(w, r, fmt.Sprintf("/admin/mail/%d", id), http.StatusSeeOther)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var mailTests = []struct {
+	name               string
+	url                string
+	method             string
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"pending mail", "/admin/mail", "GET", (*Repository).AdminMail, http.StatusOK, ""},
+	{"dead mail", "/admin/mail?status=dead", "GET", (*Repository).AdminMail, http.StatusOK, ""},
+	{"bad status", "/admin/mail?status=lost", "GET", (*Repository).AdminMail, http.StatusBadRequest, ""},
+	{"listing fails", "/admin/mail?status=sent", "GET", (*Repository).AdminMail, http.StatusInternalServerError, ""},
+	{"show mail", "/admin/mail/1", "GET", (*Repository).AdminShowMail, http.StatusOK, ""},
+	{"show bad id", "/admin/mail/x", "GET", (*Repository).AdminShowMail, http.StatusBadRequest, ""},
+	{"show missing mail", "/admin/mail/3", "GET", (*Repository).AdminShowMail, http.StatusNotFound, ""},
+	{"show fails", "/admin/mail/2", "GET", (*Repository).AdminShowMail, http.StatusInternalServerError, ""},
+	{"resend", "/admin/mail/1/resend", "POST", (*Repository).AdminResendMail, http.StatusSeeOther, "/admin/mail/1"},
+	{"resend bad id", "/admin/mail/x/resend", "POST", (*Repository).AdminResendMail, http.StatusBadRequest, ""},
+	{"resend missing mail", "/admin/mail/3/resend", "POST", (*Repository).AdminResendMail, http.StatusNotFound, ""},
+}
+
+func TestRepository_Mail(t *testing.T) {
+	for _, e := range mailTests {
+		req, _ := http.NewRequest(e.method, e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/forms"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/payments"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+)
+
+var errInvalidLink = errors.New("the link is not valid")
+var errLinkExpired = errors.New("the link has expired")
+
+// manageToken returns the signed token that lets a guest manage a reservation. It carries the
+// reservation id and expires the day after departure
+func (m *Repository) manageToken(res models.Reservation) string {
+	expires := res.EndDate.AddDate(0, 0, 1).Unix()
+	payload := fmt.Sprintf("%d.%d", res.ID, expires)
+	return payload + "." + m.signLink(payload)
+}
+
+// manageLink returns the link to the page where a guest manages a reservation
+func (m *Repository) manageLink(res models.Reservation) string {
+	return fmt.Sprintf("%s/manage-reservation/%s", m.App.BaseURL, m.manageToken(res))
+}
+
+func (m *Repository) signLink(payload string) string {
+	mac := hmac.New(sha256.New, m.App.LinkSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// reservationIDFromToken checks the signature and expiry of a manage token and returns the reservation it is for
+func (m *Repository) reservationIDFromToken(token string) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, errInvalidLink
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(m.signLink(payload))) {
+		return 0, errInvalidLink
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, errInvalidLink
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, errInvalidLink
+	}
+	if time.Now().Unix() > expires {
+		return 0, errLinkExpired
+	}
+
+	return id, nil
+}
+
+// manageReservationFromURL loads the reservation of a url like /manage-reservation/{token}, sending the guest
+// home with an error when the link is not good
+func (m *Repository) manageReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, string, bool) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 3 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Reservation{}, "", false
+	}
+	token := exploded[2]
+
+	id, err := m.reservationIDFromToken(token)
+	if err == errLinkExpired {
+		m.App.Session.Put(r.Context(), "error", "This link has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return models.Reservation{}, "", false
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This link is not valid")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return models.Reservation{}, "", false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This reservation no longer exists")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, "", false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return res, "", false
+	}
+
+	return res, token, true
+}
+
+// changeDeadline is the last moment a guest can cancel or change the dates of a reservation online
+func (m *Repository) changeDeadline(res models.Reservation) time.Time {
+	return res.StartDate.AddDate(0, 0, -m.App.CancellationDays)
+}
+
+// canChange reports whether the guest can still cancel or change the dates of a reservation online
+func (m *Repository) canChange(res models.Reservation) bool {
+	return res.CancelledAt.IsZero() && time.Now().Before(m.changeDeadline(res))
+}
+
+// ManageReservation shows a guest their reservation, with the changes they can still make
+func (m *Repository) ManageReservation(w http.ResponseWriter, r *http.Request) {
+	res, token, ok := m.manageReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderManageReservation(w, r, res, token, forms.New(nil))
+}
+
+func (m *Repository) renderManageReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, token string, form *forms.Form) {
+	reservationPayments, err := m.DB.GetPaymentsForReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	amountPaid := 0
+	for _, p := range reservationPayments {
+		if p.Status == payments.StatusCaptured {
+			amountPaid += p.Amount
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["deadline"] = m.changeDeadline(res)
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+	stringMap["start_date"] = res.StartDate.Format("02-01-2006")
+	stringMap["end_date"] = res.EndDate.Format("02-01-2006")
+
+	intMap := make(map[string]int)
+	intMap["amount_paid"] = amountPaid
+	if m.canChange(res) {
+		intMap["can_change"] = 1
+	}
+
+	render.Template(w, r, "manage-reservation.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Form:      form,
+	})
+}
+
+// ManagePostDates moves a reservation to new dates when the room is free on them
+func (m *Repository) ManagePostDates(w http.ResponseWriter, r *http.Request) {
+	res, token, ok := m.manageReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if !m.canChange(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed online, please contact us")
+		http.Redirect(w, r, "/manage-reservation/"+token, http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date")
+
+	layout := "02-01-2006"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Use a date like 31-01-2050")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Use a date like 01-02-2050")
+	} else if form.Errors.Get("start_date") == "" && !endDate.After(startDate) {
+		form.Errors.Add("end_date", "The departure must be after the arrival")
+	}
+
+	if form.Errors.Get("start_date") == "" && time.Now().After(startDate.AddDate(0, 0, -m.App.CancellationDays)) {
+		form.Errors.Add("start_date", fmt.Sprintf("The arrival must be at least %d days away", m.App.CancellationDays))
+	}
+
+	if !form.Valid() {
+		m.renderManageReservation(w, r, res, token, form)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(res.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	quote, err := m.quoteStay(room, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// what the guest paid was worked out from the price they booked at, and there is no card to
+	// charge again, so only dates at the same price are changed online
+	if quote.Total != res.Quote.Total {
+		form.Errors.Add("start_date", "These dates cost a different price from the ones you booked, please contact us to change to them")
+		m.renderManageReservation(w, r, res, token, form)
+		return
+	}
+
+	before := res
+	oldStart, oldEnd := res.StartDate, res.EndDate
+	res.StartDate = startDate
+	res.EndDate = endDate
+	res.Quote = quote
+
+	moved, err := m.DB.ChangeReservationDates(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !moved {
+		form.Errors.Add("start_date", "The room is not available for these dates")
+		res.StartDate, res.EndDate = oldStart, oldEnd
+		m.renderManageReservation(w, r, res, token, form)
+		return
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityReservation, res.ID, before, res)
+
+	// the link carries the departure, so a new one is sent
+	data := m.reservationMail(res)
+	data.Total = quote.Total
+	data.PreviousStartDate, data.PreviousEndDate = oldStart, oldEnd
+	m.sendMail(res.Email, "Reservation Changed", mailer.TemplateReservationChanged, data)
+	m.sendOwnerMail("Reservation Changed", mailer.TemplateReservationChangedOwner, data)
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been changed")
+	http.Redirect(w, r, "/manage-reservation/"+m.manageToken(res), http.StatusSeeOther)
+}
+
+// ManagePostContact updates the contact details of the guest of a reservation
+func (m *Repository) ManagePostContact(w http.ResponseWriter, r *http.Request) {
+	res, token, ok := m.manageReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if !res.CancelledAt.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "This reservation is cancelled")
+		http.Redirect(w, r, "/manage-reservation/"+token, http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	before := res
+	oldEmail := res.Email
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		m.renderManageReservation(w, r, res, token, form)
+		return
+	}
+
+	err = m.DB.UpdateReservation(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityReservation, res.ID, before, res)
+
+	data := m.reservationMail(res)
+	m.sendMail(res.Email, "Contact Details Changed", mailer.TemplateContactChanged, data)
+	if oldEmail != res.Email {
+		// the old address is told too, in case the change wasn't made by the guest
+		m.sendMail(oldEmail, "Contact Details Changed", mailer.TemplateContactChanged, data)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your contact details have been updated")
+	http.Redirect(w, r, "/manage-reservation/"+token, http.StatusSeeOther)
+}
+
+// ManagePostCancel cancels a reservation for its guest, refunding what they paid
+func (m *Repository) ManagePostCancel(w http.ResponseWriter, r *http.Request) {
+	res, token, ok := m.manageReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if !m.canChange(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled online, please contact us")
+		http.Redirect(w, r, "/manage-reservation/"+token, http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.CancelReservation(res.ID, m.cancellationMails(res))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	before := res
+	res.CancelledAt = time.Now()
+	m.audit(r, audit.ActionCancel, audit.EntityReservation, res.ID, before, res)
+
+	err = m.refundReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, "/manage-reservation/"+token, http.StatusSeeOther)
+}
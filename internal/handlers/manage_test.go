@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// manageURL returns the manage page of a test reservation ending on the given day
+func manageURL(id int, end time.Time) string {
+	return "/manage-reservation/" + Repo.manageToken(models.Reservation{ID: id, EndDate: end})
+}
+
+var stayEnd = time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)
+
+func TestRepository_ManageReservation(t *testing.T) {
+	// links are signed with the app secret, so they are made once the app is set up
+	manageTests := []struct {
+		name               string
+		url                string
+		method             string
+		postedData         url.Values
+		handler            func(*Repository, http.ResponseWriter, *http.Request)
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"show", manageURL(1, stayEnd), "GET", nil, (*Repository).ManageReservation, http.StatusOK, ""},
+		{"show tampered link", strings.Replace(manageURL(1, stayEnd), "/1.", "/4.", 1), "GET", nil,
+			(*Repository).ManageReservation, http.StatusSeeOther, "/"},
+		{"show broken link", "/manage-reservation/1", "GET", nil, (*Repository).ManageReservation, http.StatusSeeOther, "/"},
+		{"show expired link", manageURL(1, time.Now().AddDate(0, 0, -2)), "GET", nil, (*Repository).ManageReservation, http.StatusSeeOther, "/"},
+		{"show missing reservation", manageURL(100, stayEnd), "GET", nil, (*Repository).ManageReservation, http.StatusSeeOther, "/"},
+		{"show fails", manageURL(3, stayEnd), "GET", nil, (*Repository).ManageReservation, http.StatusInternalServerError, ""},
+
+		{"change dates", manageURL(1, stayEnd) + "/dates", "POST", url.Values{"start_date": {"01-01-2051"}, "end_date": {"03-01-2051"}},
+			(*Repository).ManagePostDates, http.StatusSeeOther, manageURL(1, time.Date(2051, 1, 3, 0, 0, 0, 0, time.UTC))},
+		{"change to taken dates", manageURL(1, stayEnd) + "/dates", "POST", url.Values{"start_date": {"10-01-2050"}, "end_date": {"12-01-2050"}},
+			(*Repository).ManagePostDates, http.StatusOK, ""},
+		{"change to dates at another price", manageURL(1, stayEnd) + "/dates", "POST", url.Values{"start_date": {"01-01-2051"}, "end_date": {"04-01-2051"}},
+			(*Repository).ManagePostDates, http.StatusOK, ""},
+		{"change to bad dates", manageURL(1, stayEnd) + "/dates", "POST", url.Values{"start_date": {"2051-01-01"}, "end_date": {"03-01-2051"}},
+			(*Repository).ManagePostDates, http.StatusOK, ""},
+		{"change to backwards dates", manageURL(1, stayEnd) + "/dates", "POST", url.Values{"start_date": {"03-01-2051"}, "end_date": {"01-01-2051"}},
+			(*Repository).ManagePostDates, http.StatusOK, ""},
+		{"change to a close arrival", manageURL(1, stayEnd) + "/dates", "POST",
+			url.Values{"start_date": {time.Now().AddDate(0, 0, 1).Format("02-01-2006")}, "end_date": {time.Now().AddDate(0, 0, 3).Format("02-01-2006")}},
+			(*Repository).ManagePostDates, http.StatusOK, ""},
+		{"change dates fails", manageURL(2, stayEnd) + "/dates", "POST", url.Values{"start_date": {"01-01-2051"}, "end_date": {"03-01-2051"}},
+			(*Repository).ManagePostDates, http.StatusInternalServerError, ""},
+
+		{"change contact", manageURL(1, stayEnd) + "/contact", "POST",
+			url.Values{"first_name": {"adria"}, "last_name": {"lopez"}, "email": {"adria@example.com"}, "phone": {"555"}},
+			(*Repository).ManagePostContact, http.StatusSeeOther, manageURL(1, stayEnd)},
+		{"change contact to bad email", manageURL(1, stayEnd) + "/contact", "POST",
+			url.Values{"first_name": {"adria"}, "last_name": {"lopez"}, "email": {"adria"}},
+			(*Repository).ManagePostContact, http.StatusOK, ""},
+		{"change contact fails", manageURL(2, stayEnd) + "/contact", "POST",
+			url.Values{"first_name": {"adria"}, "last_name": {"lopez"}, "email": {"adria@example.com"}},
+			(*Repository).ManagePostContact, http.StatusInternalServerError, ""},
+
+		{"cancel", manageURL(1, stayEnd) + "/cancel", "POST", url.Values{}, (*Repository).ManagePostCancel, http.StatusSeeOther, manageURL(1, stayEnd)},
+		{"cancel fails", manageURL(2, stayEnd) + "/cancel", "POST", url.Values{}, (*Repository).ManagePostCancel, http.StatusInternalServerError, ""},
+		{"cancel with expired link", manageURL(1, time.Now().AddDate(0, 0, -2)) + "/cancel", "POST", url.Values{},
+			(*Repository).ManagePostCancel, http.StatusSeeOther, "/"},
+	}
+
+	for _, e := range manageTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+
+		// a guest changing dates isn't charged again or refunded, so the price can't change
+		if e.name == "change to dates at another price" && !strings.Contains(rr.Body.String(), "different price") {
+			t.Errorf("for %s, expected the dates to be refused for their price", e.name)
+		}
+	}
+}
+
+func TestRepository_CanChange(t *testing.T) {
+	var tests = []struct {
+		name     string
+		res      models.Reservation
+		expected bool
+	}{
+		{"far away", models.Reservation{StartDate: time.Now().AddDate(0, 1, 0)}, true},
+		{"inside the policy", models.Reservation{StartDate: time.Now().AddDate(0, 0, app.CancellationDays-1)}, false},
+		{"cancelled", models.Reservation{StartDate: time.Now().AddDate(0, 1, 0), CancelledAt: time.Now()}, false},
+	}
+
+	for _, e := range tests {
+		if Repo.canChange(e.res) != e.expected {
+			t.Errorf("for %s, expected %t", e.name, e.expected)
+		}
+	}
+}
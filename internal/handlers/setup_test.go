@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"html/template"
@@ -12,7 +13,13 @@ import (
 	"time"
 
 	"github.com/adrialopezbou/bookings-go/internal/config"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/jobs"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
 	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/openapi"
+	"github.com/adrialopezbou/bookings-go/internal/payments"
+	"github.com/adrialopezbou/bookings-go/internal/pricing"
 	"github.com/adrialopezbou/bookings-go/internal/render"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
@@ -22,11 +29,24 @@ import (
 
 var app config.AppConfig
 var session *scs.SessionManager
+// mailRecorder records the emails the handlers send
+var mailRecorder = &mailer.Recorder{}
 var pathToTemplates = "./../../templates"
+var functions = template.FuncMap{
+	"humanDate":    render.HumanDate,
+	"formatDate":   render.FormatDate,
+	"iterate":      render.Iterate,
+	"add":          render.Add,
+	"formatAmount": pricing.FormatAmount,
+	"formatPrice":  render.FormatPrice,
+	"can":          render.Can,
+	"roleName":     render.RoleName,
+}
 
 func TestMain(m *testing.M) {
 	// what am I going to put in the session
 	gob.Register(models.Reservation{})
+	gob.Register(map[string]int{})
 	
 	// change this to true when in production
 	app.InProduction = false
@@ -41,11 +61,30 @@ func TestMain(m *testing.M) {
 	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
+	app.Payments = payments.NewFakeGateway("secret")
+	app.Mailer = mailRecorder
+	mailTemplates, err := mailer.ParseTemplates("./../../email-templates")
+	if err != nil {
+		log.Fatal("cannot parse the email templates:", err)
+	}
+	app.MailTemplates = mailTemplates
+	app.Jobs = &jobs.Runner{InfoLog: app.InfoLog, ErrorLog: app.ErrorLog}
+	noop := func(ctx context.Context) error { return nil }
+	if err := app.Jobs.Register("calendar-sync", "*/15 * * * *", noop); err != nil {
+		log.Fatal(err)
+	}
+	if err := app.Jobs.Register("never-run", "@daily", noop); err != nil {
+		log.Fatal(err)
+	}
+	app.CancellationDays = 7
+	app.BaseURL = "http://localhost:8080"
+	app.LinkSecret = []byte("secret")
 
-	listenForMail()
+	validator, err := openapi.NewValidator()
+	if err != nil {
+		log.Fatal("cannot load the api document:", err)
+	}
+	app.OpenAPI = validator
 
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -58,18 +97,11 @@ func TestMain(m *testing.M) {
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			<-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 	
 
@@ -87,12 +119,42 @@ func getRoutes() http.Handler {
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/user/set-password/{token}", Repo.ShowSetPassword)
+	mux.Post("/user/set-password/{token}", Repo.PostSetPassword)
+	mux.Get("/user/forgot-password", Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", Repo.ShowResetPassword)
+	mux.Post("/user/reset-password/{token}", Repo.PostResetPassword)
+	mux.Get("/user/verify-email/{token}", Repo.VerifyEmailLink)
+	mux.Get("/user/two-factor", Repo.ShowTwoFactorLogin)
+	mux.Post("/user/two-factor", Repo.PostTwoFactorLogin)
+
+	mux.Get("/manage-reservation/{token}", Repo.ManageReservation)
+	mux.Post("/manage-reservation/{token}/dates", Repo.ManagePostDates)
+	mux.Post("/manage-reservation/{token}/contact", Repo.ManagePostContact)
+	mux.Post("/manage-reservation/{token}/cancel", Repo.ManagePostCancel)
+
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
 
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/rooms/{slug}/calendar.ics", Repo.RoomCalendar)
+
+	mux.Get("/api/openapi.json", Repo.OpenAPISpec)
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(Repo.RequireAPIKey)
+		mux.Use(Repo.ValidateAPIRequest)
+		mux.NotFound(Repo.APINotFound)
+		mux.MethodNotAllowed(Repo.APIMethodNotAllowed)
+
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/availability", Repo.APIAvailability)
+		mux.Post("/reservations", Repo.APIPostReservation)
+		mux.Get("/reservations/{id}", Repo.APIReservation)
+		mux.Delete("/reservations/{id}", Repo.APICancelReservation)
+	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
@@ -128,7 +190,7 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 	for _, page := range pages {
 		name := filepath.Base(page)
 
-		ts, err := template.New(name).Funcs(template.FuncMap{}).ParseFiles(page)
+		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
 		if err != nil {
 			return myCache, err
 		}
@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/forms"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+	"github.com/adrialopezbou/bookings-go/internal/totp"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// totpIssuer names the site in authenticator apps
+	totpIssuer = "Bookings"
+	// recoveryCodeCount is how many recovery codes a user gets at a time
+	recoveryCodeCount = 10
+	// twoFactorLoginTTL is how long a user has to enter their code after their password
+	twoFactorLoginTTL = 5 * time.Minute
+	// maxTwoFactorAttempts is how many wrong codes a user can enter before having to log in again
+	maxTwoFactorAttempts = 5
+)
+
+// twoFactorState is whether a user has two-factor authentication, for the audit log
+type twoFactorState struct {
+	TwoFactor bool
+}
+
+// newRecoveryCodes returns new recovery codes, to show to the user once, and the hashes they are
+// stored by
+func newRecoveryCodes() ([]string, []string, error) {
+	var codes, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes in any case and with spaces around the parts
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, " ", ""))
+}
+
+// startTwoFactorLogin remembers in the session that the user with id gave the right password and
+// still has to enter a code
+func (m *Repository) startTwoFactorLogin(r *http.Request, id int) {
+	m.App.Session.Put(r.Context(), "two_factor_user_id", id)
+	m.App.Session.Put(r.Context(), "two_factor_started", int(time.Now().Unix()))
+	m.App.Session.Put(r.Context(), "two_factor_attempts", 0)
+}
+
+// endTwoFactorLogin forgets the login waiting for a code
+func (m *Repository) endTwoFactorLogin(r *http.Request) {
+	m.App.Session.Remove(r.Context(), "two_factor_user_id")
+	m.App.Session.Remove(r.Context(), "two_factor_started")
+	m.App.Session.Remove(r.Context(), "two_factor_attempts")
+}
+
+// pendingTwoFactorLogin returns the id of the user waiting to enter a code. It sends the user back
+// to the login page when there is none or it expired
+func (m *Repository) pendingTwoFactorLogin(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id := m.App.Session.GetInt(r.Context(), "two_factor_user_id")
+	started := time.Unix(int64(m.App.Session.GetInt(r.Context(), "two_factor_started")), 0)
+	if id == 0 || time.Since(started) > twoFactorLoginTTL {
+		m.endTwoFactorLogin(r)
+		m.App.Session.Put(r.Context(), "warning", "Your login expired, log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return 0, false
+	}
+	return id, true
+}
+
+// ShowTwoFactorLogin shows the form for the second step of the login
+func (m *Repository) ShowTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	_, ok := m.pendingTwoFactorLogin(w, r)
+	if !ok {
+		return
+	}
+
+	render.Template(w, r, "two-factor-login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostTwoFactorLogin logs the user in when they enter a code from their authenticator app or one of
+// their recovery codes
+func (m *Repository) PostTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, ok := m.pendingTwoFactorLogin(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := m.DB.GetUserById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the account can be locked while the user is at this step, by guesses here or from elsewhere,
+	// and then codes aren't checked either
+	keys := loginKeys(r, user.Email)
+	locked, err := m.loginLocked(keys)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if locked {
+		m.endTwoFactorLogin(r)
+		m.auditLoginFailure(r, user.ID, user.Email)
+		m.refuseLogin(w, r, true)
+		return
+	}
+
+	attempts := m.App.Session.GetInt(r.Context(), "two_factor_attempts") + 1
+	if attempts > maxTwoFactorAttempts {
+		m.endTwoFactorLogin(r)
+		m.App.Session.Put(r.Context(), "error", "Too many wrong codes, log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	m.App.Session.Put(r.Context(), "two_factor_attempts", attempts)
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		render.Template(w, r, "two-factor-login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	code := r.Form.Get("code")
+	recovery := false
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if ok {
+		// each code logs in once
+		ok, err = m.DB.UseTOTPStep(user.ID, step)
+	} else {
+		recovery = true
+		ok, err = m.DB.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !ok {
+		// wrong codes count like wrong passwords
+		m.auditLoginFailure(r, user.ID, user.Email)
+		err = m.recordLoginFailure(r, keys)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		form.Errors.Add("code", "This code is not valid")
+		render.Template(w, r, "two-factor-login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	m.endTwoFactorLogin(r)
+	err = m.DB.ClearLoginThrottle(accountLoginKey(user.Email))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.renewLogin(r, user.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionLogin, audit.EntityUser, user.ID, nil, nil)
+
+	if recovery {
+		left, err := m.DB.CountRecoveryCodes(user.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "warning",
+			fmt.Sprintf("You logged in with a recovery code, %d left. Make new ones from your profile", left))
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// AdminTwoFactor shows the two-factor authentication of the logged in user: a QR code to enrol when
+// it is off, and the recovery codes left when it is on
+func (m *Repository) AdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserById(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderTwoFactor(w, r, user, forms.New(nil))
+}
+
+func (m *Repository) renderTwoFactor(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+
+	if user.TOTPSecret == "" {
+		// the secret waits in the session until the user proves their app has it
+		secret := m.App.Session.GetString(r.Context(), "totp_setup_secret")
+		if secret == "" {
+			var err error
+			secret, err = totp.NewSecret()
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			m.App.Session.Put(r.Context(), "totp_setup_secret", secret)
+		}
+
+		png, err := qrcode.Encode(totp.URL(secret, totpIssuer, user.Email), qrcode.Medium, 256)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["secret"] = secret
+		data["qr_code"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	} else {
+		left, err := m.DB.CountRecoveryCodes(user.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["recovery_codes_left"] = left
+	}
+
+	// new recovery codes are only ever shown once
+	if codes := m.App.Session.PopString(r.Context(), "recovery_codes"); codes != "" {
+		data["recovery_codes"] = strings.Split(codes, "\n")
+	}
+
+	data["required"] = m.App.RequireTwoFactor
+
+	render.Template(w, r, "admin-two-factor.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostTwoFactor turns on two-factor authentication for the logged in user once they enter a
+// code from their authenticator app
+func (m *Repository) AdminPostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, err := m.DB.GetUserById(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	secret := m.App.Session.GetString(r.Context(), "totp_setup_secret")
+	if user.TOTPSecret != "" || secret == "" {
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	step, ok := totp.Validate(secret, r.Form.Get("code"), time.Now())
+	if form.Valid() && !ok {
+		form.Errors.Add("code", "This code is not valid, check the time of your device")
+	}
+	if !form.Valid() {
+		m.renderTwoFactor(w, r, user, form)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.EnableTOTP(user.ID, secret, hashes)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the code used to enrol can't log in too
+	_, err = m.DB.UseTOTPStep(user.ID, step)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityUser, user.ID, twoFactorState{false}, twoFactorState{true})
+
+	m.App.Session.Remove(r.Context(), "totp_setup_secret")
+	m.App.Session.Put(r.Context(), "recovery_codes", strings.Join(codes, "\n"))
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication is on")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminPostRecoveryCodes replaces the recovery codes of the logged in user
+func (m *Repository) AdminPostRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserById(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ReplaceRecoveryCodes(user.ID, hashes)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "recovery_codes", strings.Join(codes, "\n"))
+	m.App.Session.Put(r.Context(), "flash", "Your old recovery codes no longer work")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminDisableTwoFactor turns off two-factor authentication for the logged in user. It takes their
+// password, and isn't allowed when the site requires two-factor authentication
+func (m *Repository) AdminDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if m.App.RequireTwoFactor {
+		m.App.Session.Put(r.Context(), "error", "Two-factor authentication is required for every user")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	user, err := m.DB.GetUserById(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("current_password")
+	if form.Valid() {
+		id, _, err := m.DB.Authenticate(user.Email, r.Form.Get("current_password"))
+		if err != nil || id != user.ID {
+			form.Errors.Add("current_password", "This is not your current password")
+		}
+	}
+	if !form.Valid() {
+		m.renderTwoFactor(w, r, user, form)
+		return
+	}
+
+	err = m.DB.DisableTOTP(user.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityUser, user.ID, twoFactorState{true}, twoFactorState{false})
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication is off")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminResetTwoFactor turns off two-factor authentication for another user, who lost their device
+// and their recovery codes, so they can log in with their password and enrol again
+func (m *Repository) AdminResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if m.isCurrentUser(r, id) {
+		m.refuseCurrentUser(w, r)
+		return
+	}
+
+	err = m.DB.DisableTOTP(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityUser, id, twoFactorState{true}, twoFactorState{false})
+
+	m.App.InfoLog.Printf("user %d reset the two-factor authentication of user %d",
+		m.App.Session.GetInt(r.Context(), "user_id"), id)
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication reset")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
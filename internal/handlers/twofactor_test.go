@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/totp"
+)
+
+// testTOTPSecret is the secret of user 10 in the test repo
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// pendingLogin puts a login of user 10 that still needs a code in the session, started at started
+// and after attempts wrong codes
+func pendingLogin(started time.Time, attempts int) func(ctx context.Context) {
+	return pendingUserLogin(10, started, attempts)
+}
+
+// pendingUserLogin is pendingLogin for the user with id
+func pendingUserLogin(id int, started time.Time, attempts int) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		session.Put(ctx, "two_factor_user_id", id)
+		session.Put(ctx, "two_factor_started", int(started.Unix()))
+		session.Put(ctx, "two_factor_attempts", attempts)
+	}
+}
+
+// loggedIn puts user id in the session, and the secret they are enrolling with when there is one
+func loggedIn(id int, setupSecret string) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		session.Put(ctx, "user_id", id)
+		if setupSecret != "" {
+			session.Put(ctx, "totp_setup_secret", setupSecret)
+		}
+	}
+}
+
+func TestRepository_TwoFactor(t *testing.T) {
+	code, err := totp.Code(testTOTPSecret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	theTests := []struct {
+		name               string
+		url                string
+		method             string
+		postedData         url.Values
+		setup              func(ctx context.Context)
+		handler            func(*Repository, http.ResponseWriter, *http.Request)
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"login asks for a code", "/user/login", "POST", url.Values{"email": {"two-factor@here.com"}, "password": {"secret"}}, nil, (*Repository).PostShowLogin, http.StatusSeeOther, "/user/two-factor"},
+
+		{"code form", "/user/two-factor", "GET", nil, pendingLogin(time.Now(), 0), (*Repository).ShowTwoFactorLogin, http.StatusOK, ""},
+		{"code form without login", "/user/two-factor", "GET", nil, nil, (*Repository).ShowTwoFactorLogin, http.StatusSeeOther, "/user/login"},
+		{"code form expired", "/user/two-factor", "GET", nil, pendingLogin(time.Now().Add(-10*time.Minute), 0), (*Repository).ShowTwoFactorLogin, http.StatusSeeOther, "/user/login"},
+		{"code", "/user/two-factor", "POST", url.Values{"code": {code}}, pendingLogin(time.Now(), 0), (*Repository).PostTwoFactorLogin, http.StatusSeeOther, "/"},
+		{"recovery code", "/user/two-factor", "POST", url.Values{"code": {" ABCDE-12345 "}}, pendingLogin(time.Now(), 0), (*Repository).PostTwoFactorLogin, http.StatusSeeOther, "/"},
+		{"wrong code", "/user/two-factor", "POST", url.Values{"code": {"000000"}}, pendingLogin(time.Now(), 0), (*Repository).PostTwoFactorLogin, http.StatusOK, ""},
+		{"no code", "/user/two-factor", "POST", url.Values{}, pendingLogin(time.Now(), 0), (*Repository).PostTwoFactorLogin, http.StatusOK, ""},
+		{"too many codes", "/user/two-factor", "POST", url.Values{"code": {code}}, pendingLogin(time.Now(), 5), (*Repository).PostTwoFactorLogin, http.StatusSeeOther, "/user/login"},
+		{"code expired", "/user/two-factor", "POST", url.Values{"code": {code}}, pendingLogin(time.Now().Add(-10*time.Minute), 0), (*Repository).PostTwoFactorLogin, http.StatusSeeOther, "/user/login"},
+		{"code for locked account", "/user/two-factor", "POST", url.Values{"code": {code}}, pendingUserLogin(11, time.Now(), 0), (*Repository).PostTwoFactorLogin, http.StatusSeeOther, "/user/login"},
+
+		{"enrol form", "/admin/two-factor", "GET", nil, loggedIn(1, ""), (*Repository).AdminTwoFactor, http.StatusOK, ""},
+		{"enrolled", "/admin/two-factor", "GET", nil, loggedIn(10, ""), (*Repository).AdminTwoFactor, http.StatusOK, ""},
+		{"enrol", "/admin/two-factor", "POST", url.Values{"code": {code}}, loggedIn(1, testTOTPSecret), (*Repository).AdminPostTwoFactor, http.StatusSeeOther, "/admin/two-factor"},
+		{"enrol wrong code", "/admin/two-factor", "POST", url.Values{"code": {"000000"}}, loggedIn(1, testTOTPSecret), (*Repository).AdminPostTwoFactor, http.StatusOK, ""},
+		{"enrol without secret", "/admin/two-factor", "POST", url.Values{"code": {code}}, loggedIn(1, ""), (*Repository).AdminPostTwoFactor, http.StatusSeeOther, "/admin/two-factor"},
+		{"new recovery codes", "/admin/two-factor/recovery-codes", "POST", url.Values{}, loggedIn(10, ""), (*Repository).AdminPostRecoveryCodes, http.StatusSeeOther, "/admin/two-factor"},
+		{"disable", "/admin/two-factor/disable", "POST", url.Values{"current_password": {"secret"}}, loggedIn(10, ""), (*Repository).AdminDisableTwoFactor, http.StatusSeeOther, "/admin/two-factor"},
+		{"disable wrong password", "/admin/two-factor/disable", "POST", url.Values{"current_password": {"wrong"}}, loggedIn(10, ""), (*Repository).AdminDisableTwoFactor, http.StatusOK, ""},
+
+		{"reset", "/admin/users/10/reset-two-factor", "POST", url.Values{}, loggedIn(1, ""), (*Repository).AdminResetTwoFactor, http.StatusSeeOther, "/admin/users"},
+		{"reset self", "/admin/users/1/reset-two-factor", "POST", url.Values{}, loggedIn(1, ""), (*Repository).AdminResetTwoFactor, http.StatusSeeOther, "/admin/users"},
+		{"reset bad id", "/admin/users/x/reset-two-factor", "POST", url.Values{}, loggedIn(1, ""), (*Repository).AdminResetTwoFactor, http.StatusBadRequest, ""},
+	}
+
+	for _, e := range theTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.setup != nil {
+			e.setup(ctx)
+		}
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+
+		// the password alone must not log in
+		if e.name == "login asks for a code" && session.Exists(ctx, "user_id") {
+			t.Errorf("for %s, expected no user in the session", e.name)
+		}
+		if e.name == "code" && session.GetInt(ctx, "user_id") != 10 {
+			t.Errorf("for %s, expected user 10 to be logged in", e.name)
+		}
+		if e.name == "code for locked account" && (session.Exists(ctx, "user_id") || session.Exists(ctx, "two_factor_user_id")) {
+			t.Errorf("for %s, expected the login to end without the user logged in", e.name)
+		}
+	}
+}
+
+func TestRepository_TwoFactorRequired(t *testing.T) {
+	Repo.App.RequireTwoFactor = true
+	defer func() { Repo.App.RequireTwoFactor = false }()
+
+	theTests := []struct {
+		name               string
+		url                string
+		userID             int
+		expectedStatusCode int
+	}{
+		{"not enrolled", "/admin/dashboard", 1, http.StatusSeeOther},
+		{"not enrolled enrolling", "/admin/two-factor", 1, http.StatusOK},
+		{"enrolled", "/admin/dashboard", 10, http.StatusOK},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, e := range theTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+
+		rr := httptest.NewRecorder()
+		Repo.LoadUser(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	// turning it off isn't allowed either
+	req, _ := http.NewRequest("POST", "/admin/two-factor/disable", strings.NewReader("current_password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 10)
+
+	rr := httptest.NewRecorder()
+	Repo.AdminDisableTwoFactor(rr, req)
+
+	if rr.Code != http.StatusSeeOther || session.GetString(ctx, "error") == "" {
+		t.Errorf("expected disabling to be refused but got %d", rr.Code)
+	}
+}
@@ -0,0 +1,609 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/forms"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/rbac"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+)
+
+// Purposes of the tokens sent to users, and how long their links work
+const (
+	tokenInvitation        = "invitation"
+	tokenPasswordReset     = "password-reset"
+	tokenEmailVerification = "email-verification"
+
+	invitationTTL        = 72 * time.Hour
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 72 * time.Hour
+)
+
+// minPasswordLength is the shortest password a user can choose
+const minPasswordLength = 8
+
+// newUserToken returns a new random token for a link sent to a user, and the hash it is stored by
+func newUserToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// userIDFromURL returns the user id of /admin/users/{id}/..., where "new" is user 0
+func userIDFromURL(r *http.Request) (int, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 {
+		return 0, errors.New("missing user id")
+	}
+
+	if exploded[3] == "new" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(exploded[3])
+}
+
+// isCurrentUser reports whether id is the logged in user. Users change their own account through
+// their profile, so they can't lock themselves out
+func (m *Repository) isCurrentUser(r *http.Request, id int) bool {
+	return id == m.App.Session.GetInt(r.Context(), "user_id")
+}
+
+// refuseCurrentUser sends users that try to manage their own account back to the list of users
+func (m *Repository) refuseCurrentUser(w http.ResponseWriter, r *http.Request) {
+	m.App.Session.Put(r.Context(), "error", "Use your profile to change your own account")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminUsers lists the users of the admin tool
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	throttles, err := m.DB.LockedLogins()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	lockedKeys := make(map[string]bool)
+	for _, t := range throttles {
+		lockedKeys[t.Key] = true
+	}
+
+	// users whose account is locked out after too many failed logins, by id
+	locked := make(map[int]bool)
+	for _, u := range users {
+		if lockedKeys[accountLoginKey(u.Email)] {
+			locked[u.ID] = true
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+	data["locked"] = locked
+
+	intMap := make(map[string]int)
+	intMap["user_id"] = m.App.Session.GetInt(r.Context(), "user_id")
+
+	render.Template(w, r, "admin-users.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminShowUser shows the form to invite or edit a user
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if m.isCurrentUser(r, id) {
+		http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+		return
+	}
+
+	user := models.User{
+		AccessLevel: int(rbac.ReadOnly),
+		Active:      true,
+	}
+	if id > 0 {
+		user, err = m.DB.GetUserById(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.renderUser(w, r, user, forms.New(nil))
+}
+
+func (m *Repository) renderUser(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["roles"] = rbac.Roles
+
+	render.Template(w, r, "admin-user.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// checkUserEmail adds a form error when another user has the email. It reports false when the
+// lookup failed and a response was written
+func (m *Repository) checkUserEmail(w http.ResponseWriter, form *forms.Form, user models.User) bool {
+	existing, err := m.DB.GetUserByEmail(user.Email)
+	if err == nil && existing.ID != user.ID {
+		form.Errors.Add("email", "This email is already used by another user")
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return false
+	}
+	return true
+}
+
+// AdminPostUser invites a new user or updates an existing one
+func (m *Repository) AdminPostUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := userIDFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if m.isCurrentUser(r, id) {
+		m.refuseCurrentUser(w, r)
+		return
+	}
+
+	user := models.User{
+		Active: true,
+	}
+	if id > 0 {
+		user, err = m.DB.GetUserById(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	before := user
+	previousEmail := user.Email
+
+	user.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	user.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	user.Email = strings.TrimSpace(r.Form.Get("email"))
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+
+	user.AccessLevel, err = strconv.Atoi(r.Form.Get("access_level"))
+	if err != nil || !rbac.Role(user.AccessLevel).Valid() {
+		form.Errors.Add("access_level", "Choose a role")
+	}
+
+	if form.Valid() && !m.checkUserEmail(w, form, user) {
+		return
+	}
+
+	if !form.Valid() {
+		m.renderUser(w, r, user, form)
+		return
+	}
+
+	if user.ID == 0 {
+		user.ID, err = m.DB.InsertUser(user)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.audit(r, audit.ActionCreate, audit.EntityUser, user.ID, nil, user)
+
+		err = m.sendInvitation(user)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.App.Session.Put(r.Context(), "flash", "Invitation sent")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateUser(user)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityUser, user.ID, before, user)
+
+	// the new address has to be checked, and earlier invitations went to the old one
+	if user.Email != previousEmail {
+		if user.Password == "" {
+			err = m.sendInvitation(user)
+		} else {
+			err = m.sendEmailVerification(user)
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "User saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// newUserLink stores a new token for purpose and returns the link to path/{token}. Earlier links
+// of the user for the same purpose stop working
+func (m *Repository) newUserLink(user models.User, purpose string, ttl time.Duration, path string) (string, error) {
+	token, hash, err := newUserToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = m.DB.DeleteUserTokens(user.ID, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.DB.InsertUserToken(models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%s/%s", m.App.BaseURL, path, token), nil
+}
+
+// sendInvitation mails a user a link to choose their password
+func (m *Repository) sendInvitation(user models.User) error {
+	link, err := m.newUserLink(user, tokenInvitation, invitationTTL, "/user/set-password")
+	if err != nil {
+		return err
+	}
+
+	m.sendMail(user.Email, "Invitation", mailer.TemplateInvitation, models.UserLinkMail{
+		FirstName: user.FirstName,
+		Link:      link,
+		ValidFor:  invitationTTL,
+	})
+	return nil
+}
+
+// AdminResendInvitation sends a new invitation to a user who hasn't chosen a password yet
+func (m *Repository) AdminResendInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := m.DB.GetUserById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if user.Password != "" {
+		m.App.Session.Put(r.Context(), "error", "This user has already accepted their invitation")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = m.sendInvitation(user)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Invitation sent")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminDeactivateUser stops a user from logging in
+func (m *Repository) AdminDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	m.updateUserActive(w, r, false, "User deactivated")
+}
+
+// AdminActivateUser lets a deactivated user log in again
+func (m *Repository) AdminActivateUser(w http.ResponseWriter, r *http.Request) {
+	m.updateUserActive(w, r, true, "User activated")
+}
+
+func (m *Repository) updateUserActive(w http.ResponseWriter, r *http.Request, active bool, msg string) {
+	id, err := userIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if m.isCurrentUser(r, id) {
+		m.refuseCurrentUser(w, r)
+		return
+	}
+
+	err = m.DB.UpdateUserActive(id, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityUser, id, activeState{!active}, activeState{active})
+
+	m.App.Session.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminDeleteUser deletes a user
+func (m *Repository) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDFromURL(r)
+	if err != nil || id == 0 {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if m.isCurrentUser(r, id) {
+		m.refuseCurrentUser(w, r)
+		return
+	}
+
+	user, err := m.DB.GetUserById(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteUser(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionDelete, audit.EntityUser, id, user, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "User deleted")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// userFromTokenURL loads the user of a url like /user/set-password/{token} with a token for purpose,
+// sending them to the login page with an error when the link is not good
+func (m *Repository) userFromTokenURL(w http.ResponseWriter, r *http.Request, purpose string) (models.User, string, bool) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.User{}, "", false
+	}
+	token := exploded[3]
+
+	t, err := m.DB.GetUserTokenByHash(purpose, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This link is not valid or has expired")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return models.User{}, "", false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return models.User{}, "", false
+	}
+
+	user, err := m.DB.GetUserById(t.UserID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !user.Active) {
+		m.App.Session.Put(r.Context(), "error", "This link is not valid or has expired")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return models.User{}, "", false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return models.User{}, "", false
+	}
+
+	return user, token, true
+}
+
+// ShowSetPassword shows the form an invited user chooses their password with
+func (m *Repository) ShowSetPassword(w http.ResponseWriter, r *http.Request) {
+	user, token, ok := m.userFromTokenURL(w, r, tokenInvitation)
+	if !ok {
+		return
+	}
+
+	m.renderSetPassword(w, r, user, token, forms.New(nil))
+}
+
+func (m *Repository) renderSetPassword(w http.ResponseWriter, r *http.Request, user models.User, token string, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	render.Template(w, r, "set-password.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// checkNewPassword validates the password and confirm_password fields of form
+func checkNewPassword(form *forms.Form) {
+	form.Required("password", "confirm_password")
+	form.MinLength("password", minPasswordLength)
+	if form.Get("password") != form.Get("confirm_password") {
+		form.Errors.Add("confirm_password", "The passwords don't match")
+	}
+}
+
+// PostSetPassword stores the password chosen by an invited user
+func (m *Repository) PostSetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, token, ok := m.userFromTokenURL(w, r, tokenInvitation)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	checkNewPassword(form)
+	if !form.Valid() {
+		m.renderSetPassword(w, r, user, token, form)
+		return
+	}
+
+	err = m.DB.UpdatePassword(user.ID, r.Form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the link is single use
+	err = m.DB.DeleteUserTokens(user.ID, tokenInvitation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the invitation was mailed to them, so the address is theirs
+	err = m.DB.VerifyEmail(user.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.recordAudit(r, models.AuditEntry{UserID: user.ID, Actor: user.Email, Action: audit.ActionSetPassword,
+		Entity: audit.EntityUser, EntityID: user.ID}, nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Your password is set, you can log in now")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminProfile shows the profile of the logged in user
+func (m *Repository) AdminProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserById(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderProfile(w, r, user, forms.New(nil))
+}
+
+func (m *Repository) renderProfile(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+
+	render.Template(w, r, "admin-profile.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostProfile updates the name, email and, when a new one is given, the password of the
+// logged in user. Changing the password takes the current one
+func (m *Repository) AdminPostProfile(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, err := m.DB.GetUserById(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	before := user
+	currentEmail := user.Email
+
+	user.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	user.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	user.Email = strings.TrimSpace(r.Form.Get("email"))
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+
+	changePassword := form.Has("password")
+	if changePassword {
+		form.Required("current_password")
+		checkNewPassword(form)
+		if form.Has("current_password") {
+			id, _, err := m.DB.Authenticate(currentEmail, r.Form.Get("current_password"))
+			if err != nil || id != user.ID {
+				form.Errors.Add("current_password", "This is not your current password")
+			}
+		}
+	}
+
+	if form.Valid() && !m.checkUserEmail(w, form, user) {
+		return
+	}
+
+	if !form.Valid() {
+		m.renderProfile(w, r, user, form)
+		return
+	}
+
+	err = m.DB.UpdateUser(user)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityUser, user.ID, before, user)
+
+	if user.Email != currentEmail {
+		err = m.sendEmailVerification(user)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if changePassword {
+		err = m.DB.UpdatePassword(user.ID, r.Form.Get("password"))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		// other sessions of the user end, this one goes on
+		err = m.renewLogin(r, user.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.audit(r, audit.ActionSetPassword, audit.EntityUser, user.ID, nil, nil)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Profile saved")
+	http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var userForm = url.Values{
+	"first_name":   {"Jane"},
+	"last_name":    {"Doe"},
+	"email":        {"jane@here.com"},
+	"access_level": {"2"},
+}
+
+// withUserForm returns userForm with the given fields replaced
+func withUserForm(fields url.Values) url.Values {
+	values := url.Values{}
+	for k, v := range userForm {
+		values[k] = v
+	}
+	for k, v := range fields {
+		values[k] = v
+	}
+	return values
+}
+
+var usersTests = []struct {
+	name               string
+	url                string
+	method             string
+	postedData         url.Values
+	handler            func(*Repository, http.ResponseWriter, *http.Request)
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"list users", "/admin/users", "GET", nil, (*Repository).AdminUsers, http.StatusOK, ""},
+	{"invite form", "/admin/users/new", "GET", nil, (*Repository).AdminShowUser, http.StatusOK, ""},
+	{"edit form", "/admin/users/5", "GET", nil, (*Repository).AdminShowUser, http.StatusOK, ""},
+	{"edit form of own account", "/admin/users/1", "GET", nil, (*Repository).AdminShowUser, http.StatusSeeOther, "/admin/profile"},
+	{"edit form fails", "/admin/users/2", "GET", nil, (*Repository).AdminShowUser, http.StatusInternalServerError, ""},
+	{"edit form bad id", "/admin/users/x", "GET", nil, (*Repository).AdminShowUser, http.StatusBadRequest, ""},
+
+	{"invite", "/admin/users/new", "POST", userForm, (*Repository).AdminPostUser, http.StatusSeeOther, "/admin/users"},
+	{"invite without fields", "/admin/users/new", "POST", url.Values{}, (*Repository).AdminPostUser, http.StatusOK, ""},
+	{"invite bad role", "/admin/users/new", "POST", withUserForm(url.Values{"access_level": {"9"}}), (*Repository).AdminPostUser, http.StatusOK, ""},
+	{"invite taken email", "/admin/users/new", "POST", withUserForm(url.Values{"email": {"taken@here.com"}}), (*Repository).AdminPostUser, http.StatusOK, ""},
+	{"invite email lookup fails", "/admin/users/new", "POST", withUserForm(url.Values{"email": {"fail@here.com"}}), (*Repository).AdminPostUser, http.StatusInternalServerError, ""},
+	{"invite fails", "/admin/users/new", "POST", withUserForm(url.Values{"first_name": {"fail"}}), (*Repository).AdminPostUser, http.StatusInternalServerError, ""},
+	{"update", "/admin/users/5", "POST", userForm, (*Repository).AdminPostUser, http.StatusSeeOther, "/admin/users"},
+	{"update fails", "/admin/users/5", "POST", withUserForm(url.Values{"first_name": {"fail"}}), (*Repository).AdminPostUser, http.StatusInternalServerError, ""},
+	{"update self", "/admin/users/1", "POST", userForm, (*Repository).AdminPostUser, http.StatusSeeOther, "/admin/users"},
+
+	{"resend invitation", "/admin/users/5/invite", "POST", url.Values{}, (*Repository).AdminResendInvitation, http.StatusSeeOther, "/admin/users"},
+	{"resend accepted invitation", "/admin/users/6/invite", "POST", url.Values{}, (*Repository).AdminResendInvitation, http.StatusSeeOther, "/admin/users"},
+	{"resend invitation fails", "/admin/users/2/invite", "POST", url.Values{}, (*Repository).AdminResendInvitation, http.StatusInternalServerError, ""},
+	{"deactivate", "/admin/users/5/deactivate", "POST", url.Values{}, (*Repository).AdminDeactivateUser, http.StatusSeeOther, "/admin/users"},
+	{"deactivate self", "/admin/users/1/deactivate", "POST", url.Values{}, (*Repository).AdminDeactivateUser, http.StatusSeeOther, "/admin/users"},
+	{"activate", "/admin/users/4/activate", "POST", url.Values{}, (*Repository).AdminActivateUser, http.StatusSeeOther, "/admin/users"},
+	{"delete", "/admin/users/5/delete", "POST", url.Values{}, (*Repository).AdminDeleteUser, http.StatusSeeOther, "/admin/users"},
+	{"delete self", "/admin/users/1/delete", "POST", url.Values{}, (*Repository).AdminDeleteUser, http.StatusSeeOther, "/admin/users"},
+	{"delete bad id", "/admin/users/x/delete", "POST", url.Values{}, (*Repository).AdminDeleteUser, http.StatusBadRequest, ""},
+
+	{"set password form", "/user/set-password/invite-token", "GET", nil, (*Repository).ShowSetPassword, http.StatusOK, ""},
+	{"set password unknown link", "/user/set-password/nope", "GET", nil, (*Repository).ShowSetPassword, http.StatusSeeOther, "/user/login"},
+	{"set password deleted user", "/user/set-password/deleted-user-token", "GET", nil, (*Repository).ShowSetPassword, http.StatusSeeOther, "/user/login"},
+	{"set password", "/user/set-password/invite-token", "POST", url.Values{"password": {"long enough"}, "confirm_password": {"long enough"}}, (*Repository).PostSetPassword, http.StatusSeeOther, "/user/login"},
+	{"set short password", "/user/set-password/invite-token", "POST", url.Values{"password": {"short"}, "confirm_password": {"short"}}, (*Repository).PostSetPassword, http.StatusOK, ""},
+	{"set password mismatch", "/user/set-password/invite-token", "POST", url.Values{"password": {"long enough"}, "confirm_password": {"long enougg"}}, (*Repository).PostSetPassword, http.StatusOK, ""},
+
+	{"profile", "/admin/profile", "GET", nil, (*Repository).AdminProfile, http.StatusOK, ""},
+	{"update profile", "/admin/profile", "POST", userForm, (*Repository).AdminPostProfile, http.StatusSeeOther, "/admin/profile"},
+	{"update profile without email", "/admin/profile", "POST", withUserForm(url.Values{"email": {""}}), (*Repository).AdminPostProfile, http.StatusOK, ""},
+	{"update profile taken email", "/admin/profile", "POST", withUserForm(url.Values{"email": {"taken@here.com"}}), (*Repository).AdminPostProfile, http.StatusOK, ""},
+	{"change password", "/admin/profile", "POST", withUserForm(url.Values{"current_password": {"secret"}, "password": {"long enough"}, "confirm_password": {"long enough"}}), (*Repository).AdminPostProfile, http.StatusSeeOther, "/admin/profile"},
+	{"change password wrong current", "/admin/profile", "POST", withUserForm(url.Values{"current_password": {"wrong"}, "password": {"long enough"}, "confirm_password": {"long enough"}}), (*Repository).AdminPostProfile, http.StatusOK, ""},
+	{"change password without current", "/admin/profile", "POST", withUserForm(url.Values{"password": {"long enough"}, "confirm_password": {"long enough"}}), (*Repository).AdminPostProfile, http.StatusOK, ""},
+}
+
+func TestRepository_Users(t *testing.T) {
+	for _, e := range usersTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+
+		if strings.HasSuffix(e.name, "self") && session.GetString(ctx, "error") == "" {
+			t.Errorf("for %s, expected an error message in the session", e.name)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/audit"
+	"github.com/adrialopezbou/bookings-go/internal/forms"
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/render"
+	"github.com/adrialopezbou/bookings-go/internal/repository"
+	"github.com/adrialopezbou/bookings-go/internal/waitlist"
+)
+
+// waitlistPageSize is how many entries the waitlist page shows
+const waitlistPageSize = 200
+
+// waitlistToken returns the signed token of the link offering a guest on the waitlist the room
+// they waited for. It carries the entry id, and the offer it is for expires with the entry
+func (m *Repository) waitlistToken(id int) string {
+	return fmt.Sprintf("%d.%s", id, m.signLink(fmt.Sprintf("waitlist.%d", id)))
+}
+
+// waitlistLink returns the link to book the room offered to a guest on the waitlist
+func (m *Repository) waitlistLink(id int) string {
+	return fmt.Sprintf("%s/waitlist/offer/%s", m.App.BaseURL, m.waitlistToken(id))
+}
+
+// waitlistIDFromToken checks the signature of a waitlist token and returns the entry it is for
+func (m *Repository) waitlistIDFromToken(token string) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return 0, errInvalidLink
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, errInvalidLink
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(m.signLink(fmt.Sprintf("waitlist.%d", id)))) {
+		return 0, errInvalidLink
+	}
+
+	return id, nil
+}
+
+// waitlistEntryIDFromURL returns the id of the entry in urls like /admin/waitlist/{id}/remove
+func waitlistEntryIDFromURL(r *http.Request) (int, error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 {
+		return 0, errors.New("missing waitlist entry id")
+	}
+
+	return strconv.Atoi(exploded[3])
+}
+
+// ShowWaitlist shows the form to join the waitlist, with the dates of the search that found no
+// room when it comes from one
+func (m *Repository) ShowWaitlist(w http.ResponseWriter, r *http.Request) {
+	m.renderWaitlistForm(w, r, forms.New(r.URL.Query()))
+}
+
+func (m *Repository) renderWaitlistForm(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.ActiveRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "waitlist.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// PostWaitlist adds a guest to the waitlist for their dates, and for a room or any of them
+func (m *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	entry := models.WaitlistEntry{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     r.Form.Get("email"),
+		Phone:     r.Form.Get("phone"),
+		Status:    waitlist.StatusWaiting,
+	}
+
+	form := forms.New(r.Form)
+	form.Required("first_name", "last_name", "email", "start_date", "end_date")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	layout := "02-01-2006"
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if form.Has("start_date") && form.Has("end_date") {
+		entry.StartDate, err = time.Parse(layout, r.Form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		} else if entry.StartDate.Before(today) {
+			form.Errors.Add("start_date", "Arrival can't be in the past")
+		}
+
+		entry.EndDate, err = time.Parse(layout, r.Form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		} else if !entry.EndDate.After(entry.StartDate) {
+			form.Errors.Add("end_date", "Departure must be after arrival")
+		}
+	}
+
+	// no room means any of them
+	if r.Form.Get("room_id") != "" {
+		entry.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
+		if err != nil {
+			form.Errors.Add("room_id", "Invalid room")
+		}
+	}
+
+	rooms, err := m.DB.ActiveRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if entry.RoomID != 0 {
+		found := false
+		for _, room := range rooms {
+			if room.ID == entry.RoomID {
+				entry.Room = room
+				found = true
+			}
+		}
+		if !found {
+			form.Errors.Add("room_id", "Invalid room")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderWaitlistForm(w, r, form)
+		return
+	}
+
+	entry.ID, err = m.DB.InsertWaitlistEntry(entry)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionCreate, audit.EntityWaitlist, entry.ID, nil, entry)
+
+	m.sendMail(entry.Email, "Waitlist Confirmation", mailer.TemplateWaitlistJoined, models.WaitlistMail{
+		FirstName: entry.FirstName,
+		StartDate: entry.StartDate,
+		EndDate:   entry.EndDate,
+		RoomName:  entry.Room.RoomName,
+	})
+
+	m.App.Session.Put(r.Context(), "flash", "You're on the waitlist, we will email you as soon as a room frees up")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// waitlistURL returns the url of the form to join the waitlist for the dates of a search
+func waitlistURL(start, end string) string {
+	q := url.Values{}
+	q.Set("start_date", start)
+	q.Set("end_date", end)
+	return "/waitlist?" + q.Encode()
+}
+
+// WaitlistOffer takes a guest following the link of an offer to the reservation form, for the
+// room and dates they waited for
+func (m *Repository) WaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.URL.Path, "/")
+	if len(exploded) < 4 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	id, err := m.waitlistIDFromToken(exploded[3])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This link is not valid")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	entry, err := m.DB.GetWaitlistEntryByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This link is not valid")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if entry.Status != waitlist.StatusOffered || time.Now().After(entry.OfferExpiresAt) {
+		m.App.Session.Put(r.Context(), "error", "This offer has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res := models.Reservation{
+		FirstName: entry.FirstName,
+		LastName:  entry.LastName,
+		Email:     entry.Email,
+		Phone:     entry.Phone,
+		StartDate: entry.StartDate,
+		EndDate:   entry.EndDate,
+		RoomID:    entry.OfferedRoomID,
+	}
+
+	// the room is kept from the rest of the waitlist, not from other guests booking it, who may have
+	// got it first
+	err = m.holdRoom(r, res)
+	if errors.Is(err, repository.ErrRoomTaken) {
+		err = m.DB.UpdateWaitlistStatus(entry.ID, waitlist.StatusWaiting)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.App.Session.Put(r.Context(), "error", "Sorry, the room was booked in the meantime. You're back on the waitlist")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	m.App.Session.Put(r.Context(), "waitlist_id", entry.ID)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// bookedFromWaitlist takes the guest who booked res off the waitlist, when they booked the room
+// offered to them. Failing to is logged, since the booking went through
+func (m *Repository) bookedFromWaitlist(r *http.Request, res models.Reservation) {
+	id := m.App.Session.PopInt(r.Context(), "waitlist_id")
+	if id == 0 {
+		return
+	}
+
+	entry, err := m.DB.GetWaitlistEntryByID(id)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+	if entry.OfferedRoomID != res.RoomID || !entry.StartDate.Equal(res.StartDate) || !entry.EndDate.Equal(res.EndDate) {
+		return
+	}
+
+	err = m.DB.UpdateWaitlistStatus(id, waitlist.StatusBooked)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// NotifyWaitlist expires the offers not taken by now, and offers the rooms that are free to the
+// guests waiting for them, in the order they joined. It returns how many were offered a room.
+// Each offer is made once, even when several servers look at the waitlist at the same time.
+// Failures to offer one are logged
+func (m *Repository) NotifyWaitlist(now time.Time) (int, error) {
+	_, err := m.DB.ExpireWaitlistEntries(now)
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := m.DB.OpenWaitlistEntries()
+	if err != nil {
+		return 0, err
+	}
+
+	// the names of the rooms found free, by id, for the emails
+	roomNames := make(map[int]string)
+	offers, err := waitlist.Offers(entries, now, func(start, end time.Time) ([]int, error) {
+		rooms, err := m.DB.SearchAvailabilityForAllRooms(start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]int, len(rooms))
+		for i, room := range rooms {
+			ids[i] = room.ID
+			roomNames[room.ID] = room.RoomName
+		}
+		return ids, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	offered := 0
+	for _, o := range offers {
+		e := o.Entry
+		mail := m.mail(e.Email, "A Room Is Available", mailer.TemplateWaitlistOffer, models.WaitlistMail{
+			FirstName: e.FirstName,
+			StartDate: e.StartDate,
+			EndDate:   e.EndDate,
+			RoomName:  roomNames[o.RoomID],
+			Link:      m.waitlistLink(e.ID),
+			ValidFor:  waitlist.OfferValidFor,
+		})
+
+		ok, err := m.DB.OfferWaitlistEntry(e.ID, o.RoomID, now.Add(waitlist.OfferValidFor), mail)
+		if err != nil {
+			// the other guests still get their offers, and this one is tried again next time
+			m.App.ErrorLog.Printf("cannot offer room %d to waitlist entry %d: %s", o.RoomID, e.ID, err)
+			continue
+		}
+		if ok {
+			offered++
+		}
+	}
+
+	return offered, nil
+}
+
+// AdminWaitlist shows the latest entries of the waitlist with a status, the waiting ones by default
+func (m *Repository) AdminWaitlist(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = waitlist.StatusWaiting
+	}
+
+	valid := false
+	for _, s := range waitlist.Statuses {
+		if s == status {
+			valid = true
+		}
+	}
+	if !valid {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := m.DB.WaitlistEntries(status, waitlistPageSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["statuses"] = waitlist.Statuses
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	intMap := make(map[string]int)
+	intMap["page_size"] = waitlistPageSize
+	intMap["offer_hours"] = int(waitlist.OfferValidFor.Hours())
+
+	render.Template(w, r, "admin-waitlist.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// AdminRemoveWaitlistEntry takes a guest off the waitlist
+func (m *Repository) AdminRemoveWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	m.setWaitlistStatus(w, r, waitlist.StatusRemoved, "%s %s is off the waitlist")
+}
+
+// AdminRequeueWaitlistEntry puts a guest back on the waitlist, like one whose offer expired, to be
+// offered the next room that frees up
+func (m *Repository) AdminRequeueWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	m.setWaitlistStatus(w, r, waitlist.StatusWaiting, "%s %s is back on the waitlist")
+}
+
+// setWaitlistStatus sets the status of the entry in the url and goes back to the waitlist, with
+// flash saying what happened to the guest
+func (m *Repository) setWaitlistStatus(w http.ResponseWriter, r *http.Request, status, flash string) {
+	id, err := waitlistEntryIDFromURL(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	entry, err := m.DB.GetWaitlistEntryByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateWaitlistStatus(id, status)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	after := entry
+	after.Status = status
+	if status == waitlist.StatusWaiting {
+		after.OfferedRoomID = 0
+		after.OfferExpiresAt = time.Time{}
+	}
+	m.audit(r, audit.ActionUpdate, audit.EntityWaitlist, id, entry, after)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf(flash, entry.FirstName, entry.LastName))
+	http.Redirect(w, r, "/admin/waitlist?status="+entry.Status, http.StatusSeeOther)
+}
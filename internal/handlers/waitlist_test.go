@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+var waitlistForm = url.Values{
+	"first_name": {"John"},
+	"last_name":  {"Smith"},
+	"email":      {"john@smith.com"},
+	"phone":      {"555-555-5555"},
+	"start_date": {"10-02-2051"},
+	"end_date":   {"12-02-2051"},
+	"room_id":    {"1"},
+}
+
+// withWaitlistForm returns waitlistForm with the given fields replaced
+func withWaitlistForm(fields url.Values) url.Values {
+	values := url.Values{}
+	for k, v := range waitlistForm {
+		values[k] = v
+	}
+	for k, v := range fields {
+		values[k] = v
+	}
+	return values
+}
+
+func TestRepository_Waitlist(t *testing.T) {
+	tests := []struct {
+		name               string
+		url                string
+		method             string
+		postedData         url.Values
+		handler            func(*Repository, http.ResponseWriter, *http.Request)
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"join form", "/waitlist?start_date=10-02-2051&end_date=12-02-2051", "GET", nil, (*Repository).ShowWaitlist, http.StatusOK, ""},
+		{"join", "/waitlist", "POST", waitlistForm, (*Repository).PostWaitlist, http.StatusSeeOther, "/"},
+		{"join any room", "/waitlist", "POST", withWaitlistForm(url.Values{"room_id": {""}}), (*Repository).PostWaitlist, http.StatusSeeOther, "/"},
+		{"join without fields", "/waitlist", "POST", url.Values{}, (*Repository).PostWaitlist, http.StatusOK, ""},
+		{"join in the past", "/waitlist", "POST", withWaitlistForm(url.Values{"start_date": {"10-02-2020"}}), (*Repository).PostWaitlist, http.StatusOK, ""},
+		{"join leaving before arriving", "/waitlist", "POST", withWaitlistForm(url.Values{"end_date": {"09-02-2051"}}), (*Repository).PostWaitlist, http.StatusOK, ""},
+		{"join unknown room", "/waitlist", "POST", withWaitlistForm(url.Values{"room_id": {"9"}}), (*Repository).PostWaitlist, http.StatusOK, ""},
+		{"join fails", "/waitlist", "POST", withWaitlistForm(url.Values{"first_name": {"fail"}}), (*Repository).PostWaitlist, http.StatusInternalServerError, ""},
+
+		{"open offer", "/waitlist/offer/" + Repo.waitlistToken(2), "GET", nil, (*Repository).WaitlistOffer, http.StatusSeeOther, "/make-reservation"},
+		{"expired offer", "/waitlist/offer/" + Repo.waitlistToken(3), "GET", nil, (*Repository).WaitlistOffer, http.StatusSeeOther, "/"},
+		{"waiting without offer", "/waitlist/offer/" + Repo.waitlistToken(1), "GET", nil, (*Repository).WaitlistOffer, http.StatusSeeOther, "/"},
+		{"offered room booked since", "/waitlist/offer/" + Repo.waitlistToken(6), "GET", nil, (*Repository).WaitlistOffer, http.StatusSeeOther, "/"},
+		{"missing entry", "/waitlist/offer/" + Repo.waitlistToken(9), "GET", nil, (*Repository).WaitlistOffer, http.StatusSeeOther, "/"},
+		{"offer fails", "/waitlist/offer/" + Repo.waitlistToken(99), "GET", nil, (*Repository).WaitlistOffer, http.StatusInternalServerError, ""},
+		{"forged offer", "/waitlist/offer/2." + Repo.signLink("waitlist.3"), "GET", nil, (*Repository).WaitlistOffer, http.StatusSeeOther, "/"},
+
+		{"list waiting", "/admin/waitlist", "GET", nil, (*Repository).AdminWaitlist, http.StatusOK, ""},
+		{"list offered", "/admin/waitlist?status=offered", "GET", nil, (*Repository).AdminWaitlist, http.StatusOK, ""},
+		{"list bad status", "/admin/waitlist?status=lost", "GET", nil, (*Repository).AdminWaitlist, http.StatusBadRequest, ""},
+		{"list fails", "/admin/waitlist?status=removed", "GET", nil, (*Repository).AdminWaitlist, http.StatusInternalServerError, ""},
+
+		{"remove", "/admin/waitlist/1/remove", "POST", url.Values{}, (*Repository).AdminRemoveWaitlistEntry, http.StatusSeeOther, "/admin/waitlist?status=waiting"},
+		{"remove bad id", "/admin/waitlist/x/remove", "POST", url.Values{}, (*Repository).AdminRemoveWaitlistEntry, http.StatusBadRequest, ""},
+		{"remove missing entry", "/admin/waitlist/9/remove", "POST", url.Values{}, (*Repository).AdminRemoveWaitlistEntry, http.StatusNotFound, ""},
+		{"remove fails", "/admin/waitlist/99/remove", "POST", url.Values{}, (*Repository).AdminRemoveWaitlistEntry, http.StatusInternalServerError, ""},
+		{"requeue", "/admin/waitlist/3/requeue", "POST", url.Values{}, (*Repository).AdminRequeueWaitlistEntry, http.StatusSeeOther, "/admin/waitlist?status=offered"},
+	}
+
+	for _, e := range tests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			location, err := rr.Result().Location()
+			if err != nil {
+				t.Errorf("for %s, expected location %s but got none", e.name, e.expectedLocation)
+			} else if location.String() != e.expectedLocation {
+				t.Errorf("for %s, expected location %s but got %s", e.name, e.expectedLocation, location.String())
+			}
+		}
+	}
+}
+
+func TestRepository_NotifyWaitlist(t *testing.T) {
+	// room 1 is free in 2051. John joined first for those nights, and Max after him for some of them,
+	// Bob waits for room 2, and the open offer of Jane is for other nights
+	mailRecorder.Reset()
+
+	offered, err := Repo.NotifyWaitlist(time.Date(2050, 1, 1, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if offered != 1 {
+		t.Errorf("expected 1 offer but got %d", offered)
+	}
+
+	mail := mailRecorder.Messages()
+	if len(mail) != 1 {
+		t.Fatalf("expected 1 email but got %d: %+v", len(mail), mail)
+	}
+	if mail[0].To != "john@smith.com" || mail[0].Template != mailer.TemplateWaitlistOffer {
+		t.Errorf("expected the offer to john@smith.com but got %s to %s", mail[0].Template, mail[0].To)
+	}
+
+	data, ok := mail[0].Data.(models.WaitlistMail)
+	if !ok || data.RoomName != "General's Quarters" || !strings.Contains(data.Link, "/waitlist/offer/"+Repo.waitlistToken(1)) {
+		t.Errorf("expected an offer of General's Quarters with a link to book it but got %+v", mail[0].Data)
+	}
+}
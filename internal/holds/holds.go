@@ -0,0 +1,22 @@
+// Package holds keeps a room for a guest while they fill in the reservation form, so it can't be
+// booked from under them
+package holds
+
+import "time"
+
+// RestrictionID is the restriction type of the nights held for a guest booking them
+const RestrictionID = 4
+
+// Duration is how long a room is held after the guest chose it or last did something on the
+// reservation form
+const Duration = 15 * time.Minute
+
+// Remaining returns how long a hold expiring at expiresAt has left at now, in whole seconds, and 0
+// once it has expired
+func Remaining(expiresAt, now time.Time) time.Duration {
+	left := expiresAt.Sub(now).Truncate(time.Second)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
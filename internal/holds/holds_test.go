@@ -0,0 +1,28 @@
+package holds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemaining(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name      string
+		expiresAt time.Time
+		expected  time.Duration
+	}{
+		{"just held", now.Add(Duration), 15 * time.Minute},
+		{"part of a second left", now.Add(90*time.Second + 400*time.Millisecond), 90 * time.Second},
+		{"expiring now", now, 0},
+		{"expired", now.Add(-time.Minute), 0},
+	}
+
+	for _, e := range tests {
+		left := Remaining(e.expiresAt, now)
+		if left != e.expected {
+			t.Errorf("for %s, expected %s but got %s", e.name, e.expected, left)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package ical
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ExternalRestrictionID is the restriction type of nights blocked by an external calendar
+const ExternalRestrictionID = 3
+
+// maxFeedSize limits how much of an external calendar is read
+const maxFeedSize = 5 << 20
+
+const dateLayout = "20060102"
+
+// Event is a booked or blocked range of nights, from Start up to, but not including, End
+type Event struct {
+	UID     string
+	Start   time.Time
+	End     time.Time
+	Summary string
+}
+
+// ErrNotCalendar is returned when the data read is not an iCalendar file
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// Write writes events as an iCalendar file of all day events
+func Write(w io.Writer, name string, events []Event) error {
+	b := bufio.NewWriter(w)
+
+	writeLine(b, "BEGIN:VCALENDAR")
+	writeLine(b, "VERSION:2.0")
+	writeLine(b, "PRODID:-//bookings-go//calendar//EN")
+	writeLine(b, "CALSCALE:GREGORIAN")
+	writeLine(b, "METHOD:PUBLISH")
+	writeLine(b, "X-WR-CALNAME:"+escapeText(name))
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		writeLine(b, "BEGIN:VEVENT")
+		writeLine(b, "UID:"+escapeText(e.UID))
+		writeLine(b, "DTSTAMP:"+stamp)
+		writeLine(b, "DTSTART;VALUE=DATE:"+e.Start.Format(dateLayout))
+		writeLine(b, "DTEND;VALUE=DATE:"+e.End.Format(dateLayout))
+		writeLine(b, "SUMMARY:"+escapeText(e.Summary))
+		writeLine(b, "TRANSP:OPAQUE")
+		writeLine(b, "END:VEVENT")
+	}
+
+	writeLine(b, "END:VCALENDAR")
+
+	return b.Flush()
+}
+
+// writeLine writes a content line, folded at 75 octets as the spec requires
+func writeLine(b *bufio.Writer, line string) {
+	// continuation lines start with a space, which counts towards their length
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// don't split a multi byte character
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+func escapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+func unescapeText(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
+
+// Parse reads the events of an iCalendar file. Times are reduced to their date, cancelled
+// events are skipped, and events without an end last one night. Recurring events are read
+// as their first occurrence only
+func Parse(r io.Reader) ([]Event, error) {
+	var events []Event
+
+	lines, err := unfold(r)
+	if err != nil {
+		return events, err
+	}
+
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return events, ErrNotCalendar
+	}
+
+	var e Event
+	inEvent := false
+	cancelled := false
+
+	for _, line := range lines {
+		name, value := splitLine(line)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			e = Event{}
+			inEvent = true
+			cancelled = false
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			inEvent = false
+			if cancelled || e.Start.IsZero() {
+				continue
+			}
+			if !e.End.After(e.Start) {
+				e.End = e.Start.AddDate(0, 0, 1)
+			}
+			if e.UID == "" {
+				// without a uid the dates are all that identify the event
+				e.UID = e.Start.Format(dateLayout) + "-" + e.End.Format(dateLayout)
+			}
+			events = append(events, e)
+		case !inEvent:
+			continue
+		case name == "UID":
+			e.UID = value
+		case name == "SUMMARY":
+			e.Summary = unescapeText(value)
+		case name == "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case name == "DTSTART":
+			e.Start, err = parseDate(value)
+			if err != nil {
+				return events, err
+			}
+		case name == "DTEND":
+			e.End, err = parseDate(value)
+			if err != nil {
+				return events, err
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// unfold splits data into content lines, joining the lines folded by the writer
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxFeedSize)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// splitLine returns the upper cased property name and the value of a content line,
+// dropping any parameters
+func splitLine(line string) (string, string) {
+	quoted := false
+	for i, c := range line {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if quoted {
+				continue
+			}
+			name := line[:i]
+			if j := strings.IndexByte(name, ';'); j >= 0 {
+				name = name[:j]
+			}
+			return strings.ToUpper(name), line[i+1:]
+		}
+	}
+	return strings.ToUpper(line), ""
+}
+
+// parseDate reads the date of a DATE or DATE-TIME value
+func parseDate(value string) (time.Time, error) {
+	if len(value) < len(dateLayout) {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	t, err := time.Parse(dateLayout, value[:len(dateLayout)])
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q", value)
+	}
+	return t, nil
+}
+
+// Fetch reads the events of an external calendar, from an http(s) url or a local file
+func Fetch(source string) ([]Event, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := &http.Client{Timeout: 20 * time.Second}
+
+		resp, err := client.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching calendar: %s", resp.Status)
+		}
+
+		return Parse(io.LimitReader(resp.Body, maxFeedSize))
+	}
+
+	f, err := os.Open(strings.TrimPrefix(source, "file://"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(io.LimitReader(f, maxFeedSize))
+}
+
+// NewToken returns a random token for the secret url of a calendar feed
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,118 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("02-01-2006", s)
+	return t
+}
+
+const feed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Other site//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:first@example.com\r\n" +
+	"DTSTART;VALUE=DATE:20500101\r\n" +
+	"DTEND;VALUE=DATE:20500104\r\n" +
+	"SUMMARY:Reserved\\, not available\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:second@exam\r\n" +
+	" ple.com\r\n" +
+	"DTSTART;TZID=\"Europe/Madrid\":20500110T150000\r\n" +
+	"DTEND:20500112T110000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:cancelled@example.com\r\n" +
+	"DTSTART;VALUE=DATE:20500120\r\n" +
+	"DTEND;VALUE=DATE:20500122\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20500201\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		uid     string
+		start   string
+		end     string
+		summary string
+	}{
+		{"first@example.com", "01-01-2050", "04-01-2050", "Reserved, not available"},
+		{"second@example.com", "10-01-2050", "12-01-2050", ""},
+		{"20500201-20500202", "01-02-2050", "02-02-2050", ""},
+	}
+
+	if len(events) != len(tests) {
+		t.Fatalf("expected %d events but got %d", len(tests), len(events))
+	}
+
+	for i, e := range tests {
+		got := events[i]
+		if got.UID != e.uid {
+			t.Errorf("event %d: expected uid %s but got %s", i, e.uid, got.UID)
+		}
+		if !got.Start.Equal(date(e.start)) || !got.End.Equal(date(e.end)) {
+			t.Errorf("event %d: expected %s to %s but got %s to %s", i, e.start, e.end, got.Start, got.End)
+		}
+		if got.Summary != e.summary {
+			t.Errorf("event %d: expected summary %q but got %q", i, e.summary, got.Summary)
+		}
+	}
+
+	_, err = Parse(strings.NewReader("<html></html>"))
+	if err != ErrNotCalendar {
+		t.Error("parsing something that is not a calendar did not fail")
+	}
+
+	_, err = Parse(strings.NewReader("BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:tomorrow\nEND:VEVENT\nEND:VCALENDAR\n"))
+	if err == nil {
+		t.Error("parsing an invalid date did not fail")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	events := []Event{
+		{UID: "1@bookings", Start: date("01-01-2050"), End: date("03-01-2050"), Summary: "Reserved"},
+		{UID: "2@bookings", Start: date("05-01-2050"), End: date("06-01-2050"), Summary: strings.Repeat("Blocked, ", 20)},
+	}
+
+	var buf bytes.Buffer
+	err := Write(&buf, "General's Quarters", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %s", line)
+		}
+	}
+
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(events) {
+		t.Fatalf("expected %d events back but got %d", len(events), len(got))
+	}
+
+	for i, e := range events {
+		if got[i].UID != e.UID || !got[i].Start.Equal(e.Start) || !got[i].End.Equal(e.End) || got[i].Summary != e.Summary {
+			t.Errorf("event %d: expected %+v but got %+v", i, e, got[i])
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ical
+
+import (
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/repository"
+)
+
+// Diff compares the restrictions imported from a feed with the events it holds now. Events
+// are matched by uid: new ones are inserted, moved ones updated and missing ones removed
+func Diff(feed models.CalendarFeed, existing []models.RoomRestriction, events []Event) (insert, update []models.RoomRestriction, remove []int) {
+	byUID := make(map[string]models.RoomRestriction)
+	for _, r := range existing {
+		byUID[r.ExternalUID] = r
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range events {
+		// a uid listed twice is taken once
+		if seen[e.UID] {
+			continue
+		}
+		seen[e.UID] = true
+
+		r, ok := byUID[e.UID]
+		if !ok {
+			insert = append(insert, models.RoomRestriction{
+				StartDate:      e.Start,
+				EndDate:        e.End,
+				RoomID:         feed.RoomID,
+				RestrictionID:  ExternalRestrictionID,
+				CalendarFeedID: feed.ID,
+				ExternalUID:    e.UID,
+			})
+			continue
+		}
+
+		if !r.StartDate.Equal(e.Start) || !r.EndDate.Equal(e.End) {
+			r.StartDate = e.Start
+			r.EndDate = e.End
+			update = append(update, r)
+		}
+	}
+
+	for _, r := range existing {
+		if !seen[r.ExternalUID] {
+			remove = append(remove, r.ID)
+		}
+	}
+
+	return insert, update, remove
+}
+
+// Sync imports an external calendar into the restrictions of its room and records the
+// outcome on the feed. Nothing is changed when the calendar can't be read
+func Sync(db repository.DatabaseRepo, feed models.CalendarFeed) error {
+	err := syncFeed(db, feed)
+
+	syncErr := ""
+	if err != nil {
+		syncErr = err.Error()
+	}
+
+	updateErr := db.UpdateCalendarFeedSynced(feed.ID, syncErr)
+	if err == nil {
+		err = updateErr
+	}
+
+	return err
+}
+
+func syncFeed(db repository.DatabaseRepo, feed models.CalendarFeed) error {
+	events, err := Fetch(feed.URL)
+	if err != nil {
+		return err
+	}
+
+	existing, err := db.GetExternalRestrictionsForFeed(feed.ID)
+	if err != nil {
+		return err
+	}
+
+	insert, update, remove := Diff(feed, existing, events)
+	if len(insert) == 0 && len(update) == 0 && len(remove) == 0 {
+		return nil
+	}
+
+	return db.SyncExternalRestrictions(feed.ID, insert, update, remove)
+}
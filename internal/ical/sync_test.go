@@ -0,0 +1,65 @@
+package ical
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrialopezbou/bookings-go/internal/config"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/repository/dbrepo"
+)
+
+func TestDiff(t *testing.T) {
+	feed := models.CalendarFeed{ID: 4, RoomID: 1}
+
+	existing := []models.RoomRestriction{
+		{ID: 10, ExternalUID: "kept", StartDate: date("01-01-2050"), EndDate: date("03-01-2050")},
+		{ID: 11, ExternalUID: "moved", StartDate: date("05-01-2050"), EndDate: date("07-01-2050")},
+		{ID: 12, ExternalUID: "gone", StartDate: date("10-01-2050"), EndDate: date("12-01-2050")},
+	}
+
+	events := []Event{
+		{UID: "kept", Start: date("01-01-2050"), End: date("03-01-2050")},
+		{UID: "moved", Start: date("06-01-2050"), End: date("08-01-2050")},
+		{UID: "new", Start: date("15-01-2050"), End: date("16-01-2050")},
+		{UID: "new", Start: date("15-01-2050"), End: date("16-01-2050")},
+	}
+
+	insert, update, remove := Diff(feed, existing, events)
+
+	if len(insert) != 1 || insert[0].ExternalUID != "new" {
+		t.Fatalf("expected the new event to be inserted once but got %+v", insert)
+	}
+	if insert[0].RoomID != 1 || insert[0].CalendarFeedID != 4 || insert[0].RestrictionID != ExternalRestrictionID {
+		t.Errorf("inserted restriction is not an external restriction of the feed: %+v", insert[0])
+	}
+
+	if len(update) != 1 || update[0].ID != 11 || !update[0].StartDate.Equal(date("06-01-2050")) {
+		t.Errorf("expected the moved event to be updated but got %+v", update)
+	}
+
+	if len(remove) != 1 || remove[0] != 12 {
+		t.Errorf("expected the missing event to be removed but got %v", remove)
+	}
+}
+
+func TestSync(t *testing.T) {
+	db := dbrepo.NewTestRepo(&config.AppConfig{})
+
+	path := filepath.Join(t.TempDir(), "calendar.ics")
+	err := os.WriteFile(path, []byte(feed), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Sync(db, models.CalendarFeed{ID: 1, RoomID: 1, URL: path})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = Sync(db, models.CalendarFeed{ID: 1, RoomID: 1, URL: filepath.Join(t.TempDir(), "missing.ics")})
+	if err == nil {
+		t.Error("syncing a missing calendar did not fail")
+	}
+}
@@ -0,0 +1,170 @@
+// Package jobs runs background work on cron schedules, like importing calendars and sending guest
+// messages. Every server runs the jobs, and a lock in the database makes sure each scheduled run
+// happens on only one of them
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// Statuses of the runs of a job
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Func is the work of a job. It should stop early when ctx is done
+type Func func(ctx context.Context) error
+
+// Job is work run on a schedule
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      Func
+}
+
+// Store is where the jobs are locked and their runs recorded
+type Store interface {
+	// LockJob takes the lock of a job, unless another server holds it, and returns how to release it
+	LockJob(name string) (unlock func() error, locked bool, err error)
+	// LastJobRun returns the latest run of a job, or sql.ErrNoRows if it never ran
+	LastJobRun(name string) (models.JobRun, error)
+	StartJobRun(name string, startedAt time.Time) (int, error)
+	// FinishJobRun records the end of a run, which failed with runErr unless it is empty
+	FinishJobRun(id int, finishedAt time.Time, runErr string) error
+}
+
+// Runner runs the registered jobs on their schedules
+type Runner struct {
+	Store    Store
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+
+	jobs []Job
+}
+
+// Register adds a job running run on the cron schedule spec. Names must be unique
+func (r *Runner) Register(name, spec string, run Func) error {
+	for _, j := range r.jobs {
+		if j.Name == name {
+			return fmt.Errorf("job %s is registered already", name)
+		}
+	}
+
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	r.jobs = append(r.jobs, Job{Name: name, Schedule: schedule, Run: run})
+	return nil
+}
+
+// Jobs returns the registered jobs, in the order they were registered
+func (r *Runner) Jobs() []Job {
+	jobs := make([]Job, len(r.jobs))
+	copy(jobs, r.jobs)
+	return jobs
+}
+
+// Run runs every job on its schedule until ctx is done, and then waits for the runs in progress
+func (r *Runner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range r.jobs {
+		wg.Add(1)
+		go func(j Job) {
+			defer wg.Done()
+			for {
+				at := j.Schedule.Next(time.Now())
+				if at.IsZero() {
+					r.ErrorLog.Printf("job %s has no next run on schedule %q", j.Name, j.Schedule)
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Until(at)):
+				}
+
+				r.runScheduled(ctx, j, at)
+			}
+		}(j)
+	}
+	wg.Wait()
+}
+
+// runScheduled runs j for its run scheduled at, unless another server is running it or already has
+func (r *Runner) runScheduled(ctx context.Context, j Job, at time.Time) {
+	unlock, locked, err := r.Store.LockJob(j.Name)
+	if err != nil {
+		r.ErrorLog.Printf("cannot lock job %s: %s", j.Name, err)
+		return
+	}
+	if !locked {
+		return
+	}
+	defer func() {
+		err := unlock()
+		if err != nil {
+			r.ErrorLog.Printf("cannot unlock job %s: %s", j.Name, err)
+		}
+	}()
+
+	last, err := r.Store.LastJobRun(j.Name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		r.ErrorLog.Printf("cannot look up the last run of job %s: %s", j.Name, err)
+		return
+	}
+	if err == nil && !last.StartedAt.Before(at) {
+		// another server took the lock first and has run it
+		return
+	}
+
+	r.record(ctx, j)
+}
+
+// record runs j and records the run. A job that panics fails rather than stopping the server
+func (r *Runner) record(ctx context.Context, j Job) {
+	start := time.Now()
+	id, err := r.Store.StartJobRun(j.Name, start)
+	if err != nil {
+		r.ErrorLog.Printf("cannot record the start of job %s: %s", j.Name, err)
+		return
+	}
+
+	runErr := run(ctx, j)
+
+	var msg string
+	if runErr != nil {
+		msg = runErr.Error()
+		r.ErrorLog.Printf("job %s failed: %s", j.Name, runErr)
+	} else {
+		r.InfoLog.Printf("job %s done in %s", j.Name, time.Since(start).Round(time.Millisecond))
+	}
+
+	err = r.Store.FinishJobRun(id, time.Now(), msg)
+	if err != nil {
+		r.ErrorLog.Printf("cannot record the end of job %s: %s", j.Name, err)
+	}
+}
+
+// run runs j, turning a panic into an error
+func run(ctx context.Context, j Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	return j.Run(ctx)
+}
@@ -0,0 +1,183 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// testStore keeps the runs of the jobs in memory. Jobs in held are locked by another server
+type testStore struct {
+	held     map[string]bool
+	locked   map[string]bool
+	runs     []models.JobRun
+	unlocked int
+}
+
+func newTestStore() *testStore {
+	return &testStore{held: make(map[string]bool), locked: make(map[string]bool)}
+}
+
+func (s *testStore) LockJob(name string) (func() error, bool, error) {
+	if s.held[name] || s.locked[name] {
+		return nil, false, nil
+	}
+	s.locked[name] = true
+	return func() error {
+		delete(s.locked, name)
+		s.unlocked++
+		return nil
+	}, true, nil
+}
+
+func (s *testStore) LastJobRun(name string) (models.JobRun, error) {
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		if s.runs[i].JobName == name {
+			return s.runs[i], nil
+		}
+	}
+	return models.JobRun{}, sql.ErrNoRows
+}
+
+func (s *testStore) StartJobRun(name string, startedAt time.Time) (int, error) {
+	s.runs = append(s.runs, models.JobRun{ID: len(s.runs) + 1, JobName: name, Status: StatusRunning, StartedAt: startedAt})
+	return len(s.runs), nil
+}
+
+func (s *testStore) FinishJobRun(id int, finishedAt time.Time, runErr string) error {
+	run := &s.runs[id-1]
+	run.FinishedAt = finishedAt
+	run.Error = runErr
+	run.Status = StatusSucceeded
+	if runErr != "" {
+		run.Status = StatusFailed
+	}
+	return nil
+}
+
+func newTestRunner(store Store) *Runner {
+	return &Runner{
+		Store:    store,
+		InfoLog:  log.New(ioutil.Discard, "", 0),
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func TestRunner_Register(t *testing.T) {
+	r := newTestRunner(newTestStore())
+	noop := func(ctx context.Context) error { return nil }
+
+	if err := r.Register("sync", "*/15 * * * *", noop); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.Register("sync", "@daily", noop); err == nil {
+		t.Error("expected an error registering a job twice")
+	}
+	if err := r.Register("purge", "every day", noop); err == nil {
+		t.Error("expected an error registering a bad schedule")
+	}
+
+	jobs := r.Jobs()
+	if len(jobs) != 1 || jobs[0].Name != "sync" || jobs[0].Schedule.String() != "*/15 * * * *" {
+		t.Errorf("expected the sync job but got %+v", jobs)
+	}
+}
+
+func TestRunner_RunScheduled(t *testing.T) {
+	store := newTestStore()
+	r := newTestRunner(store)
+
+	calls := 0
+	j := Job{Name: "sync", Run: func(ctx context.Context) error {
+		calls++
+		return nil
+	}}
+	at := time.Now().Add(-time.Minute)
+
+	r.runScheduled(context.Background(), j, at)
+	if calls != 1 || len(store.runs) != 1 || store.runs[0].Status != StatusSucceeded {
+		t.Fatalf("expected a successful run but got %d calls and %+v", calls, store.runs)
+	}
+	if store.unlocked != 1 || store.locked["sync"] {
+		t.Errorf("expected the job to be unlocked after running")
+	}
+
+	// another server getting the lock once the run is done doesn't run it again
+	r.runScheduled(context.Background(), j, at)
+	if calls != 1 {
+		t.Errorf("expected the scheduled run to happen once but got %d calls", calls)
+	}
+
+	// nor while another server holds the lock
+	store.held["sync"] = true
+	r.runScheduled(context.Background(), j, time.Now())
+	if calls != 1 {
+		t.Errorf("expected no run while another server holds the lock but got %d calls", calls)
+	}
+
+	delete(store.held, "sync")
+	r.runScheduled(context.Background(), j, time.Now())
+	if calls != 2 || len(store.runs) != 2 {
+		t.Errorf("expected the next scheduled run but got %d calls", calls)
+	}
+}
+
+func TestRunner_RunScheduledFailures(t *testing.T) {
+	store := newTestStore()
+	r := newTestRunner(store)
+
+	failing := Job{Name: "failing", Run: func(ctx context.Context) error {
+		return errors.New("calendar unreachable")
+	}}
+	panicking := Job{Name: "panicking", Run: func(ctx context.Context) error {
+		panic("nil map")
+	}}
+
+	r.runScheduled(context.Background(), failing, time.Now())
+	r.runScheduled(context.Background(), panicking, time.Now())
+
+	if len(store.runs) != 2 {
+		t.Fatalf("expected 2 runs but got %+v", store.runs)
+	}
+	if store.runs[0].Status != StatusFailed || store.runs[0].Error != "calendar unreachable" {
+		t.Errorf("expected the failing job to fail but got %+v", store.runs[0])
+	}
+	if store.runs[1].Status != StatusFailed || store.runs[1].Error != "panic: nil map" {
+		t.Errorf("expected the panicking job to fail but got %+v", store.runs[1])
+	}
+	if store.unlocked != 2 {
+		t.Errorf("expected both jobs to be unlocked but got %d", store.unlocked)
+	}
+}
+
+func TestRunner_Run(t *testing.T) {
+	r := newTestRunner(newTestStore())
+
+	err := r.Register("every-minute", "* * * * *", func(ctx context.Context) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	// the first run is at the start of the next minute, so this only checks Run stops waiting for it
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return once ctx is done")
+	}
+}
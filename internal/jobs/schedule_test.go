@@ -0,0 +1,81 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	var tests = []struct {
+		spec  string
+		valid bool
+	}{
+		{"* * * * *", true},
+		{"*/15 * * * *", true},
+		{"0 8-18/2 * * 1-5", true},
+		{"30 3 1,15 * *", true},
+		{"0 0 * * 7", true},
+		{"5/20 * * * *", true},
+		{"@daily", true},
+		{"@hourly", true},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"a * * * *", false},
+		{"@yearly", false},
+		{"", false},
+	}
+
+	for _, e := range tests {
+		_, err := ParseSchedule(e.spec)
+		if e.valid && err != nil {
+			t.Errorf("for %q, unexpected error: %s", e.spec, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("for %q, expected an error but got none", e.spec)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2050, 1, 5, 10, 7, 30, 0, time.UTC)
+
+	var tests = []struct {
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"* * * * *", from, time.Date(2050, 1, 5, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2050, 1, 5, 10, 15, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2050, 1, 5, 10, 15, 0, 0, time.UTC), time.Date(2050, 1, 5, 10, 30, 0, 0, time.UTC)},
+		{"@hourly", from, time.Date(2050, 1, 5, 11, 0, 0, 0, time.UTC)},
+		{"@daily", from, time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", from, time.Date(2050, 1, 6, 3, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1", from, time.Date(2050, 1, 10, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2050, 1, 9, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", from, time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2050, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", from, time.Date(2052, 2, 29, 12, 0, 0, 0, time.UTC)},
+		// the 10th or any Friday, whichever comes first
+		{"0 0 10 * 5", from, time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", from, time.Time{}},
+	}
+
+	for _, e := range tests {
+		s, err := ParseSchedule(e.spec)
+		if err != nil {
+			t.Errorf("for %q, unexpected error: %s", e.spec, err)
+			continue
+		}
+		if got := s.Next(e.from); !got.Equal(e.expected) {
+			t.Errorf("for %q from %s, expected %s but got %s", e.spec, e.from, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// unsafeFileChars are the characters of an address left out of file names
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+// File writes every email to a .eml file in a directory instead of sending it, for development
+type File struct {
+	// Dir is the directory the files are written to, created when missing
+	Dir string
+	// Templates are the templates emails are laid out in
+	Templates *Templates
+}
+
+// Send writes m to a file named after the time and who it is for
+func (f *File) Send(m models.MailData) error {
+	email, err := compose(m, f.Templates)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(f.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), unsafeFileChars.ReplaceAllString(m.To, "_"))
+	return ioutil.WriteFile(filepath.Join(f.Dir, name), []byte(email.GetMessage()), 0644)
+}
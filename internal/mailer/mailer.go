@@ -0,0 +1,59 @@
+// Package mailer sends email through an smtp server, drops it in a directory as .eml files in
+// development, or records it in memory for tests
+package mailer
+
+import (
+	"errors"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Mailer sends emails
+type Mailer interface {
+	Send(m models.MailData) error
+}
+
+// permanentError is a failure retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as a failure retrying won't fix, like a missing template or an address the
+// server rejects
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// compose lays m out in its template and returns it as an email ready to send, in html with a
+// plain text alternative. Failures are permanent
+func compose(m models.MailData, templates *Templates) (*mail.Email, error) {
+	html, text, err := templates.Render(m)
+	if err != nil {
+		return nil, err
+	}
+
+	email := mail.NewMSG()
+	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+	email.SetBody(mail.TextPlain, text)
+	email.AddAlternative(mail.TextHTML, html)
+
+	if email.Error != nil {
+		return nil, Permanent(email.Error)
+	}
+	return email, nil
+}
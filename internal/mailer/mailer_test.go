@@ -0,0 +1,259 @@
+package mailer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// testTemplates are the email templates of the site
+func testTemplates(t *testing.T) *Templates {
+	t.Helper()
+	templates, err := ParseTemplates("./../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return templates
+}
+
+func testMail(to string) models.MailData {
+	return models.MailData{
+		To:       to,
+		From:     "me@here.com",
+		Subject:  "Reservation Confirmation",
+		Template: TemplateReservationConfirmation,
+		Data: models.ReservationMail{
+			FirstName:  "John",
+			StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:    time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+			ManageLink: "http://localhost:8080/manage-reservation/token",
+		},
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var r Recorder
+	for _, to := range []string{"guest@here.com", "owner@here.com"} {
+		err := r.Send(testMail(to))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sent := r.Messages()
+	if len(sent) != 2 || sent[0].To != "guest@here.com" || sent[1].To != "owner@here.com" {
+		t.Errorf("expected the emails in the order they were sent but got %+v", sent)
+	}
+
+	sent[0].To = "changed@here.com"
+	if r.Messages()[0].To != "guest@here.com" {
+		t.Error("expected the recorded emails not to change with the copy")
+	}
+
+	r.Reset()
+	if len(r.Messages()) != 0 {
+		t.Errorf("expected no emails after reset but got %+v", r.Messages())
+	}
+}
+
+func TestFile_Send(t *testing.T) {
+	f := &File{Dir: filepath.Join(t.TempDir(), "mail"), Templates: testTemplates(t)}
+
+	err := f.Send(testMail("guest@here.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(f.Dir, "*-guest@here.com.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one .eml file but got %v (%v)", files, err)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"To: <guest@here.com>", "Subject: Reservation Confirmation", "multipart/alternative", "Content-Type: text/plain", "Content-Type: text/html"} {
+		if !strings.Contains(string(data), part) {
+			t.Errorf("expected %q in\n%s", part, data)
+		}
+	}
+
+	err = f.Send(models.MailData{To: "guest@here.com", From: "me@here.com", Template: "missing"})
+	if !IsPermanent(err) {
+		t.Errorf("expected a missing template to be a permanent failure but got %v", err)
+	}
+}
+
+// smtpServer is a fake smtp server that accepts every email, except those to rejected addresses
+type smtpServer struct {
+	listener net.Listener
+	rejected string
+
+	mu          sync.Mutex
+	connections int
+	delivered   []string
+}
+
+func newSMTPServer(t *testing.T, rejected string) *smtpServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: l, rejected: rejected}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.connections++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	reply("220 localhost ready")
+	var to string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			to = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			if to == s.rejected {
+				reply("550 no such user")
+				continue
+			}
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+			}
+			s.mu.Lock()
+			s.delivered = append(s.delivered, to)
+			s.mu.Unlock()
+			reply("250 ok")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTP_Send(t *testing.T) {
+	server := newSMTPServer(t, "nobody@here.com")
+	s := &SMTP{Host: "127.0.0.1", Port: server.port(), Encryption: EncryptionNone, Templates: testTemplates(t), MaxIdle: 1}
+	defer s.Close()
+
+	for _, to := range []string{"guest@here.com", "owner@here.com", "guest@here.com"} {
+		err := s.Send(testMail(to))
+		if err != nil {
+			t.Fatalf("cannot send to %s: %s", to, err)
+		}
+	}
+
+	err := s.Send(testMail("nobody@here.com"))
+	if !IsPermanent(err) {
+		t.Errorf("expected a rejected address to be a permanent failure but got %v", err)
+	}
+
+	err = s.Send(testMail("guest@here.com"))
+	if err != nil {
+		t.Fatalf("cannot send after a rejected address: %s", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.delivered) != 4 {
+		t.Errorf("expected 4 emails to be delivered but got %v", server.delivered)
+	}
+	// the connection is reused until the rejected address closes it
+	if server.connections != 2 {
+		t.Errorf("expected 2 connections but got %d", server.connections)
+	}
+}
+
+func TestSMTP_SendReconnects(t *testing.T) {
+	server := newSMTPServer(t, "")
+	s := &SMTP{Host: "127.0.0.1", Port: server.port(), Templates: testTemplates(t), MaxIdle: 1}
+	defer s.Close()
+
+	err := s.Send(testMail("guest@here.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server drops the idle connection
+	s.mu.Lock()
+	s.idle[0].Close()
+	s.mu.Unlock()
+
+	err = s.Send(testMail("guest@here.com"))
+	if err != nil {
+		t.Errorf("expected to connect again but got %s", err)
+	}
+}
+
+func TestSMTP_SendUnknownEncryption(t *testing.T) {
+	s := &SMTP{Host: "127.0.0.1", Port: 25, Encryption: "ssl", Templates: testTemplates(t)}
+
+	err := s.Send(testMail("guest@here.com"))
+	if !IsPermanent(err) || !strings.Contains(err.Error(), strconv.Quote("ssl")) {
+		t.Errorf("expected a permanent failure for the encryption but got %v", err)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	err := errors.New("550 no such user")
+
+	if IsPermanent(err) {
+		t.Error("expected an error not to be permanent")
+	}
+	if !IsPermanent(fmt.Errorf("sending: %w", Permanent(err))) {
+		t.Error("expected a wrapped permanent error to be permanent")
+	}
+	if !errors.Is(Permanent(err), err) {
+		t.Error("expected a permanent error to wrap its error")
+	}
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"sync"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// Recorder keeps the emails it is given in memory instead of sending them, for tests
+type Recorder struct {
+	mu       sync.Mutex
+	messages []models.MailData
+}
+
+// Send records m
+func (r *Recorder) Send(m models.MailData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+// Messages returns the emails recorded so far, in the order they were sent
+func (r *Recorder) Messages() []models.MailData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := make([]models.MailData, len(r.messages))
+	copy(messages, r.messages)
+	return messages
+}
+
+// Reset forgets the emails recorded so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
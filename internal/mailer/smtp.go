@@ -0,0 +1,150 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Encryptions of the connection to the smtp server
+const (
+	EncryptionNone     = "none"
+	EncryptionSTARTTLS = "starttls"
+	EncryptionTLS      = "tls"
+)
+
+// smtpTimeout bounds connecting to the smtp server and sending an email through it
+const smtpTimeout = 10 * time.Second
+
+// SMTP sends email through an smtp server. Connections are kept open after sending, so the next
+// emails don't have to connect and log in again
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Encryption is EncryptionNone, EncryptionSTARTTLS or EncryptionTLS
+	Encryption string
+	// Templates are the templates emails are laid out in
+	Templates *Templates
+	// MaxIdle is how many open connections are kept for the next emails
+	MaxIdle int
+
+	mu   sync.Mutex
+	idle []*mail.SMTPClient
+}
+
+// Send sends m. Addresses the server rejects are permanent failures
+func (s *SMTP) Send(m models.MailData) error {
+	email, err := compose(m, s.Templates)
+	if err != nil {
+		return err
+	}
+
+	client, reused, err := s.take()
+	if err != nil {
+		return err
+	}
+
+	err = email.Send(client)
+	if err != nil && reused && !isReply(err) {
+		// the server may have closed the connection while it was idle
+		_ = client.Close()
+		client, err = s.dial()
+		if err != nil {
+			return err
+		}
+		err = email.Send(client)
+	}
+	if err != nil {
+		_ = client.Close()
+
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return Permanent(err)
+		}
+		return err
+	}
+
+	s.put(client)
+	return nil
+}
+
+// Close closes the open connections
+func (s *SMTP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, client := range s.idle {
+		_ = client.Quit()
+		_ = client.Close()
+	}
+	s.idle = nil
+	return nil
+}
+
+// take returns an open connection, and whether it was used before
+func (s *SMTP) take() (*mail.SMTPClient, bool, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		client := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return client, true, nil
+	}
+	s.mu.Unlock()
+
+	client, err := s.dial()
+	return client, false, err
+}
+
+// put keeps client open for the next emails, unless enough connections are open already
+func (s *SMTP) put(client *mail.SMTPClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.idle) >= s.MaxIdle {
+		_ = client.Quit()
+		_ = client.Close()
+		return
+	}
+	s.idle = append(s.idle, client)
+}
+
+// dial connects and logs in to the smtp server
+func (s *SMTP) dial() (*mail.SMTPClient, error) {
+	server := mail.NewSMTPClient()
+	server.Host = s.Host
+	server.Port = s.Port
+	if s.Username != "" {
+		server.Username = s.Username
+		server.Password = s.Password
+	}
+	server.KeepAlive = true
+	server.ConnectTimeout = smtpTimeout
+	server.SendTimeout = smtpTimeout
+
+	switch s.Encryption {
+	case EncryptionNone, "":
+		server.Encryption = mail.EncryptionNone
+	case EncryptionSTARTTLS:
+		server.Encryption = mail.EncryptionSTARTTLS
+	case EncryptionTLS:
+		server.Encryption = mail.EncryptionSSLTLS
+	default:
+		return nil, Permanent(fmt.Errorf("unknown smtp encryption %q", s.Encryption))
+	}
+
+	return server.Connect()
+}
+
+// isReply reports whether err is an answer of the smtp server, rather than a broken connection
+func isReply(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply)
+}
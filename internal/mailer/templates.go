@@ -0,0 +1,163 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"reflect"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/pricing"
+)
+
+// Names of the email templates. Each one is laid out by <name>.html.tmpl in the basic layout, and
+// by <name>.txt.tmpl for the plain text alternative
+const (
+	TemplateReservationConfirmation   = "reservation-confirmation"
+	TemplateReservationNotification   = "reservation-notification"
+	TemplateReservationChanged        = "reservation-changed"
+	TemplateReservationChangedOwner   = "reservation-changed-owner"
+	TemplateReservationCancelled      = "reservation-cancelled"
+	TemplateReservationCancelledOwner = "reservation-cancelled-owner"
+	TemplateContactChanged            = "contact-changed"
+	TemplateGuestMessage              = "guest-message"
+	TemplateWaitlistJoined            = "waitlist-joined"
+	TemplateWaitlistOffer             = "waitlist-offer"
+	TemplatePasswordReset             = "password-reset"
+	TemplateEmailVerification         = "email-verification"
+	TemplateInvitation                = "invitation"
+)
+
+// templateData is the type of the data each email template takes
+var templateData = map[string]interface{}{
+	TemplateReservationConfirmation:   models.ReservationMail{},
+	TemplateReservationNotification:   models.ReservationMail{},
+	TemplateReservationChanged:        models.ReservationMail{},
+	TemplateReservationChangedOwner:   models.ReservationMail{},
+	TemplateReservationCancelled:      models.ReservationMail{},
+	TemplateReservationCancelledOwner: models.ReservationMail{},
+	TemplateContactChanged:            models.ReservationMail{},
+	TemplateGuestMessage:              models.GuestMessageMail{},
+	TemplateWaitlistJoined:            models.WaitlistMail{},
+	TemplateWaitlistOffer:             models.WaitlistMail{},
+	TemplatePasswordReset:             models.UserLinkMail{},
+	TemplateEmailVerification:         models.UserLinkMail{},
+	TemplateInvitation:                models.UserLinkMail{},
+}
+
+// layout is the html layout every email is shown in, defining "basic" around their "content"
+const layout = "basic.layout.tmpl"
+
+// functions are the functions the email templates can use
+var functions = map[string]interface{}{
+	"formatDate":  formatDate,
+	"formatPrice": formatPrice,
+	"lines":       lines,
+	"validFor":    validFor,
+}
+
+// formatDate formats the dates shown in emails
+func formatDate(t time.Time) string {
+	return t.Format("02-01-2006")
+}
+
+// formatPrice formats an amount in cents with its currency
+func formatPrice(cents int) string {
+	return fmt.Sprintf("%s %s", pricing.FormatAmount(cents), pricing.Currency)
+}
+
+// lines splits text written by the admins into its lines, to show them in html
+func lines(text string) []string {
+	return strings.Split(strings.ReplaceAll(strings.TrimSpace(text), "\r\n", "\n"), "\n")
+}
+
+// validFor says how long a link works, in hours or in minutes when it's less than an hour
+func validFor(d time.Duration) string {
+	n, unit := int(d.Hours()), "hour"
+	if d < time.Hour {
+		n, unit = int(d.Minutes()), "minute"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// Templates are the email templates, parsed once
+type Templates struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// ParseTemplates parses the email templates in dir. Every template must be there
+func ParseTemplates(dir string) (*Templates, error) {
+	t := &Templates{
+		html: make(map[string]*htmltemplate.Template),
+		text: make(map[string]*texttemplate.Template),
+	}
+
+	for name := range templateData {
+		html, err := htmltemplate.New(name+".html.tmpl").Funcs(functions).ParseFiles(
+			filepath.Join(dir, name+".html.tmpl"),
+			filepath.Join(dir, layout),
+		)
+		if err != nil {
+			return nil, err
+		}
+		t.html[name] = html
+
+		text, err := texttemplate.New(name + ".txt.tmpl").Funcs(functions).ParseFiles(filepath.Join(dir, name+".txt.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		t.text[name] = text
+	}
+
+	return t, nil
+}
+
+// Render lays m out in its template, as html and as plain text. Failures are permanent
+func (t *Templates) Render(m models.MailData) (string, string, error) {
+	html, ok := t.html[m.Template]
+	if !ok {
+		return "", "", Permanent(fmt.Errorf("unknown email template %q", m.Template))
+	}
+	if expected := reflect.TypeOf(templateData[m.Template]); reflect.TypeOf(m.Data) != expected {
+		return "", "", Permanent(fmt.Errorf("email template %s takes %s, not %T", m.Template, expected, m.Data))
+	}
+
+	var htmlBuf bytes.Buffer
+	err := html.Execute(&htmlBuf, m.Data)
+	if err != nil {
+		return "", "", Permanent(err)
+	}
+
+	var textBuf bytes.Buffer
+	err = t.text[m.Template].Execute(&textBuf, m.Data)
+	if err != nil {
+		return "", "", Permanent(err)
+	}
+
+	return htmlBuf.String(), strings.TrimSpace(textBuf.String()) + "\n", nil
+}
+
+// DecodeData decodes the data of an email of a template from json. Data of unknown templates is
+// returned as it is, so the email fails when it is sent instead
+func DecodeData(template string, data []byte) (interface{}, error) {
+	zero, ok := templateData[template]
+	if !ok {
+		return json.RawMessage(data), nil
+	}
+
+	v := reflect.New(reflect.TypeOf(zero))
+	err := json.Unmarshal(data, v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
@@ -0,0 +1,123 @@
+package mailer
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+func TestTemplates_Render(t *testing.T) {
+	templates := testTemplates(t)
+
+	m := testMail("guest@here.com")
+	data := m.Data.(models.ReservationMail)
+	data.FirstName = `<script>alert("hi")</script>`
+	m.Data = data
+
+	html, text, err := templates.Render(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(html, "<script>alert") {
+		t.Error("expected the name of the guest to be escaped in the html")
+	}
+	for _, part := range []string{"&lt;script&gt;", "from 01-01-2050 to 02-01-2050", `href="http://localhost:8080/manage-reservation/token"`} {
+		if !strings.Contains(html, part) {
+			t.Errorf("expected %q in the html", part)
+		}
+	}
+
+	if strings.Contains(text, "<strong>") {
+		t.Errorf("expected no markup in the text but got\n%s", text)
+	}
+	for _, part := range []string{`Dear <script>alert("hi")</script>,`, "from 01-01-2050 to 02-01-2050", "http://localhost:8080/manage-reservation/token"} {
+		if !strings.Contains(text, part) {
+			t.Errorf("expected %q in the text but got\n%s", part, text)
+		}
+	}
+}
+
+func TestTemplates_RenderEveryTemplate(t *testing.T) {
+	templates := testTemplates(t)
+
+	for name, data := range templateData {
+		html, text, err := templates.Render(models.MailData{Template: name, Data: data})
+		if err != nil {
+			t.Errorf("for %s, expected no error but got %s", name, err)
+			continue
+		}
+		if !strings.Contains(html, "</html>") || strings.TrimSpace(text) == "" {
+			t.Errorf("for %s, expected the layout and a text version but got\n%s\n%s", name, html, text)
+		}
+	}
+}
+
+func TestTemplates_RenderErrors(t *testing.T) {
+	templates := testTemplates(t)
+
+	var tests = []struct {
+		name     string
+		mail     models.MailData
+		expected string
+	}{
+		{"unknown template", models.MailData{Template: "missing", Data: models.ReservationMail{}}, "unknown email template"},
+		{"wrong data", models.MailData{Template: TemplatePasswordReset, Data: models.ReservationMail{}}, "takes models.UserLinkMail"},
+		{"no data", models.MailData{Template: TemplatePasswordReset}, "takes models.UserLinkMail"},
+	}
+
+	for _, e := range tests {
+		_, _, err := templates.Render(e.mail)
+		if !IsPermanent(err) || !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("for %s, expected a permanent error with %q but got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestDecodeData(t *testing.T) {
+	sent := models.UserLinkMail{FirstName: "John", Link: "http://localhost:8080/user/reset-password", ValidFor: 30 * time.Minute}
+	raw, err := json.Marshal(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := DecodeData(TemplatePasswordReset, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, sent) {
+		t.Errorf("expected %+v but got %+v", sent, data)
+	}
+
+	data, err = DecodeData("missing", raw)
+	if err != nil || string(data.(json.RawMessage)) != string(raw) {
+		t.Errorf("expected the data of an unknown template as it is but got %v (%v)", data, err)
+	}
+
+	_, err = DecodeData(TemplatePasswordReset, []byte("{"))
+	if err == nil {
+		t.Error("expected an error for bad json")
+	}
+}
+
+func TestValidFor(t *testing.T) {
+	var tests = []struct {
+		d        time.Duration
+		expected string
+	}{
+		{30 * time.Minute, "30 minutes"},
+		{time.Minute, "1 minute"},
+		{time.Hour, "1 hour"},
+		{48 * time.Hour, "48 hours"},
+	}
+
+	for _, e := range tests {
+		if got := validFor(e.d); got != e.expected {
+			t.Errorf("for %s, expected %s but got %s", e.d, e.expected, got)
+		}
+	}
+}
@@ -2,26 +2,41 @@ package models
 
 import (
 	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/pricing"
 )
 
 // User is the user model
 type User struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID                int
+	FirstName         string
+	LastName          string
+	Email             string
+	Password          string
+	AccessLevel       int
+	Active            bool
+	EmailVerifiedAt   time.Time
+	PasswordChangedAt time.Time
+	TOTPSecret        string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
 // Room is the room model
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID            int
+	RoomName      string
+	Slug          string
+	Description   string
+	Capacity      int
+	Amenities     []string
+	Images        []string
+	Active        bool
+	BaseRate      int
+	WeekendUplift int
+	ICalToken     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 // Restriction is the restriction model
@@ -34,39 +49,269 @@ type Restriction struct {
 
 // Reservation is the reservation model
 type Reservation struct {
+	ID          int
+	FirstName   string
+	LastName    string
+	Email       string
+	Phone       string
+	StartDate   time.Time
+	EndDate     time.Time
+	RoomID      int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Processed   int
+	Quote       pricing.Quote
+	CancelledAt time.Time
+	APIKeyID    int
+}
+
+// RoomRestriction is the room restriction model
+type RoomRestriction struct {
+	ID             int
+	StartDate      time.Time
+	EndDate        time.Time
+	RoomID         int
+	ReservationID  int
+	RestrictionID  int
+	CalendarFeedID int
+	ExternalUID    string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Room           Room
+	Reservation    Reservation
+	Restriction    Restriction
+}
+
+// SeasonalRate is the seasonal rate model
+type SeasonalRate struct {
+	ID          int
+	RoomID      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// StayDiscount is the length of stay discount model
+type StayDiscount struct {
 	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
 	RoomID    int
+	MinNights int
+	Percent   int
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Room      Room
 }
 
-// RoomRestriction is the room restriction model
-type RoomRestriction struct {
+// Payment is the payment model
+type Payment struct {
 	ID            int
-	StartDate     time.Time
-	EndDate       time.Time
-	RoomID        int
 	ReservationID int
-	RestrictionID int
+	Provider      string
+	ProviderRef   string
+	Amount        int
+	Currency      string
+	Status        string
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
-	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
 }
 
-// MailData holds an email message
+// CalendarFeed is an external calendar whose events block a room
+type CalendarFeed struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	LastError    string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// APIKey is a key that grants access to the JSON API. Only a hash of the key is stored
+type APIKey struct {
+	ID         int
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Revoked    bool
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// UserToken is a one-time link sent to a user, like an invitation. Only a hash of the token is stored
+type UserToken struct {
+	ID        int
+	UserID    int
+	Purpose   string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// LoginThrottle counts the failed logins of an account or an address, to slow down password guessing
+type LoginThrottle struct {
+	ID            int
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// LockoutEvent records that an account or an address was locked out after too many failed logins
+type LockoutEvent struct {
+	ID          int
+	Key         string
+	IP          string
+	Failures    int
+	LockedUntil time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// AuditEntry records who did what to which entity, and what changed. Changes is the JSON of the
+// fields that changed, with their values before and after
+type AuditEntry struct {
+	ID        int
+	UserID    int
+	Actor     string
+	Action    string
+	Entity    string
+	EntityID  int
+	Changes   string
+	IP        string
+	CreatedAt time.Time
+}
+
+// AuditFilter selects entries of the audit log. Zero values match every entry, and a zero Limit
+// returns them all
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	UserID   int
+	Limit    int
+}
+
+// MailData holds an email message. Template names the email template it is laid out with, and
+// Data is what the template shows, of the type the template takes
 type MailData struct {
 	To       string
 	From     string
 	Subject  string
-	Content  string
 	Template string
+	Data     interface{}
+}
+
+// ReservationMail is the data of the emails about a reservation. PreviousStartDate and
+// PreviousEndDate are the dates before they were changed, and Total is in cents
+type ReservationMail struct {
+	FirstName         string
+	LastName          string
+	Email             string
+	Phone             string
+	RoomName          string
+	StartDate         time.Time
+	EndDate           time.Time
+	PreviousStartDate time.Time
+	PreviousEndDate   time.Time
+	Total             int
+	ManageLink        string
+}
+
+// GuestMessageMail is the data of the scheduled messages to guests, with the Body the admins wrote.
+// Upcoming is set before arrival, while the guest can still manage their reservation
+type GuestMessageMail struct {
+	ReservationMail
+	Body     string
+	Upcoming bool
+}
+
+// UserLinkMail is the data of the emails sending a user a link, which works for ValidFor
+type UserLinkMail struct {
+	FirstName string
+	Link      string
+	ValidFor  time.Duration
+}
+
+// WaitlistMail is the data of the emails to a guest on the waitlist. RoomName is empty when they
+// wait for any room, and Link and ValidFor are set when they are offered one
+type WaitlistMail struct {
+	FirstName string
+	StartDate time.Time
+	EndDate   time.Time
+	RoomName  string
+	Link      string
+	ValidFor  time.Duration
+}
+
+// OutboxMail is an email in the mail outbox, with how sending it went. Attempts counts the tries
+// so far, including the one in progress
+type OutboxMail struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// GuestMessage is an email sent to the guest of every reservation OffsetDays days from its arrival
+// or departure, depending on Anchor. Negative offsets are before it
+type GuestMessage struct {
+	ID         int
+	Name       string
+	Subject    string
+	Body       string
+	Anchor     string
+	OffsetDays int
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// PendingGuestMessage is a guest message not sent yet for a reservation
+type PendingGuestMessage struct {
+	MessageID   int
+	Reservation Reservation
+}
+
+// JobRun is a run of a background job. FinishedAt is zero while it is running
+type JobRun struct {
+	ID         int
+	JobName    string
+	Status     string
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// WaitlistEntry is a guest waiting for a room to free up from StartDate to EndDate. RoomID is 0
+// when any room will do. OfferedRoomID and OfferExpiresAt are set while a room is offered to them
+type WaitlistEntry struct {
+	ID             int
+	FirstName      string
+	LastName       string
+	Email          string
+	Phone          string
+	StartDate      time.Time
+	EndDate        time.Time
+	RoomID         int
+	Status         string
+	OfferedRoomID  int
+	OfferExpiresAt time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Room           Room
+	OfferedRoom    Room
 }
@@ -14,4 +14,6 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	// AccessLevel is the role of the logged in user, to hide what they can't do
+	AccessLevel int
 }
@@ -0,0 +1,211 @@
+// Package openapi holds the OpenAPI 3 document of the json api and checks requests and responses against it
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+)
+
+// Spec is the OpenAPI document, as served to clients
+//
+//go:embed openapi.json
+var Spec []byte
+
+// ErrNoRoute is returned when the document describes no operation for a request
+var ErrNoRoute = errors.New("no operation matches the request")
+
+// RequestError tells why a request doesn't match the document. Fields holds the problem with each
+// invalid parameter or body field; Message is set when the request is wrong as a whole
+type RequestError struct {
+	Message string
+	Fields  map[string]string
+}
+
+func (e *RequestError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("invalid fields: %v", e.Fields)
+}
+
+// Validator checks requests and responses against the document
+type Validator struct {
+	router routers.Router
+}
+
+// NewValidator loads the document and checks that it is a valid OpenAPI 3 document
+func NewValidator() (*Validator, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	err = doc.Validate(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validator{router: router}, nil
+}
+
+// options skip the security requirements: api keys are checked by the handlers
+func options() *openapi3filter.Options {
+	return &openapi3filter.Options{
+		MultiError:            true,
+		IncludeResponseStatus: true,
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+	}
+}
+
+// findRoute returns the operation for r. A path parameter of the wrong type means no operation matches
+func (v *Validator) findRoute(r *http.Request) (*openapi3filter.RequestValidationInput, error) {
+	route, params, err := v.router.FindRoute(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoRoute, err)
+	}
+
+	return &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: params,
+		Route:      route,
+		Options:    options(),
+	}, nil
+}
+
+// ValidateRequest checks r against its operation. It returns ErrNoRoute when the document doesn't
+// describe r and a *RequestError when r doesn't match. The body of r can still be read afterwards
+func (v *Validator) ValidateRequest(r *http.Request) error {
+	input, err := v.findRoute(r)
+	if err != nil {
+		return err
+	}
+
+	err = openapi3filter.ValidateRequest(r.Context(), input)
+	if err == nil {
+		return nil
+	}
+
+	reqErr := &RequestError{
+		Fields: make(map[string]string),
+	}
+
+	noRoute := collectErrors(err, reqErr)
+	if noRoute {
+		return fmt.Errorf("%w: %s", ErrNoRoute, err)
+	}
+
+	if reqErr.Message == "" && len(reqErr.Fields) == 0 {
+		reqErr.Message = err.Error()
+	}
+
+	return reqErr
+}
+
+// collectErrors adds the errors found by the filter to reqErr. It reports whether a path parameter is wrong
+func collectErrors(err error, reqErr *RequestError) bool {
+	noRoute := false
+
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		for _, inner := range e {
+			if collectErrors(inner, reqErr) {
+				noRoute = true
+			}
+		}
+	case *openapi3filter.RequestError:
+		if p := e.Parameter; p != nil {
+			if p.In == openapi3.ParameterInPath {
+				return true
+			}
+			reqErr.Fields[p.Name] = fieldMessage(e.Err)
+		} else if !collectSchemaErrors(e.Err, reqErr) {
+			reqErr.Message = e.Error()
+		}
+	default:
+		reqErr.Message = err.Error()
+	}
+
+	return noRoute
+}
+
+// collectSchemaErrors adds the body fields that don't match their schema to reqErr. It reports
+// whether every error could be tied to a field
+func collectSchemaErrors(err error, reqErr *RequestError) bool {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		all := len(e) > 0
+		for _, inner := range e {
+			if !collectSchemaErrors(inner, reqErr) {
+				all = false
+			}
+		}
+		return all
+	case *openapi3.SchemaError:
+		pointer := e.JSONPointer()
+		if len(pointer) == 0 {
+			return false
+		}
+		reqErr.Fields[pointer[0]] = fieldMessage(e)
+		return true
+	}
+
+	return false
+}
+
+// fieldMessage describes the problem with a field the way the handlers do
+func fieldMessage(err error) string {
+	if errors.Is(err, openapi3filter.ErrInvalidRequired) {
+		return "This field is required"
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if !errors.As(err, &schemaErr) {
+		return "This field is not valid"
+	}
+
+	switch schemaErr.SchemaField {
+	case "required":
+		return "This field is required"
+	case "format":
+		if schemaErr.Schema.Format == "date" {
+			return "Use a date like 2050-01-31"
+		}
+		return fmt.Sprintf("This field must be a valid %s", schemaErr.Schema.Format)
+	case "minLength":
+		return fmt.Sprintf("This field must be at least %d characters long", schemaErr.Schema.MinLength)
+	}
+
+	return schemaErr.Reason
+}
+
+// ValidateResponse checks a response sent to r against its operation. It returns ErrNoRoute when
+// the document doesn't describe r
+func (v *Validator) ValidateResponse(r *http.Request, status int, header http.Header, body []byte) error {
+	input, err := v.findRoute(r)
+	if err != nil {
+		return err
+	}
+
+	out := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 status,
+		Header:                 header,
+		Options:                input.Options,
+	}
+	out.SetBodyBytes(body)
+
+	return openapi3filter.ValidateResponse(r.Context(), out)
+}
@@ -0,0 +1,92 @@
+package openapi
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateRequest(t *testing.T) {
+	v, err := NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name            string
+		method          string
+		url             string
+		body            string
+		expectedNoRoute bool
+		expectedFields  []string
+		expectedMessage bool
+	}{
+		{"valid query", "GET", "/api/v1/availability?start_date=2050-01-01&end_date=2050-01-02", "", false, nil, false},
+		{"missing query", "GET", "/api/v1/availability?start_date=2050-01-01", "", false, []string{"end_date"}, false},
+		{"bad date", "GET", "/api/v1/availability?start_date=01-01-2050&end_date=2050-01-02", "", false, []string{"start_date"}, false},
+		{"unknown path", "GET", "/api/v1/guests", "", true, nil, false},
+		{"unknown method", "PUT", "/api/v1/rooms", "", true, nil, false},
+		{"bad path parameter", "GET", "/api/v1/reservations/x", "", true, nil, false},
+		{"missing body fields", "POST", "/api/v1/reservations", `{"room_id": 1, "start_date": "2050-01-01", "end_date": "2050-01-02"}`, false,
+			[]string{"first_name", "last_name", "email"}, false},
+		{"unknown body field", "POST", "/api/v1/reservations", `{"room_id": 1, "start_date": "2050-01-01", "end_date": "2050-01-02", "first_name": "adria", "last_name": "lopez", "email": "a@b.es", "mobile": "1"}`, false, nil, true},
+		{"not json", "POST", "/api/v1/reservations", `room_id=1`, false, nil, true},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+
+		err := v.ValidateRequest(req)
+
+		if errors.Is(err, ErrNoRoute) != e.expectedNoRoute {
+			t.Errorf("for %s, expected no route to be %t but got %v", e.name, e.expectedNoRoute, err)
+			continue
+		}
+
+		var reqErr *RequestError
+		if !errors.As(err, &reqErr) {
+			if len(e.expectedFields) > 0 || e.expectedMessage {
+				t.Errorf("for %s, expected a request error but got %v", e.name, err)
+			}
+			continue
+		}
+
+		if e.expectedMessage && reqErr.Message == "" {
+			t.Errorf("for %s, expected a message but got fields %v", e.name, reqErr.Fields)
+		}
+
+		if len(reqErr.Fields) != len(e.expectedFields) {
+			t.Errorf("for %s, expected %d invalid fields but got %v", e.name, len(e.expectedFields), reqErr.Fields)
+		}
+		for _, field := range e.expectedFields {
+			if reqErr.Fields[field] == "" {
+				t.Errorf("for %s, expected %s to be invalid", e.name, field)
+			}
+		}
+	}
+}
+
+func TestValidateRequestKeepsBody(t *testing.T) {
+	v, err := NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"room_id": 1, "start_date": "2050-01-01", "end_date": "2050-01-02", "first_name": "adria", "last_name": "lopez", "email": "a@b.es"}`
+	req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	err = v.ValidateRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(strings.Builder)
+	_, _ = io.Copy(buf, req.Body)
+	if buf.String() != body {
+		t.Errorf("expected the body to be readable after validation but got %q", buf.String())
+	}
+}
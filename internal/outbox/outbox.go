@@ -0,0 +1,143 @@
+// Package outbox sends the mail queued in the mail outbox, retrying failures with exponential
+// backoff and dead-lettering the ones that can't be sent
+package outbox
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// Statuses of the mail in the outbox
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusDead    = "dead"
+)
+
+// Statuses lists the statuses, in the order the admin pages show them
+var Statuses = []string{StatusPending, StatusDead, StatusSent}
+
+const (
+	// MaxAttempts is how many times an email is tried before it is dead-lettered
+	MaxAttempts = 8
+	// firstBackoff is how long the first retry waits, each one after it waits twice as long
+	firstBackoff = time.Minute
+	// maxBackoff caps how long a retry waits
+	maxBackoff = 6 * time.Hour
+	// lease is how long a worker has to send the emails it claims before other workers can take them
+	lease = 5 * time.Minute
+)
+
+// Store is where the outbox is kept
+type Store interface {
+	ClaimMail(limit int, lease time.Duration) ([]models.OutboxMail, error)
+	MarkMailSent(id int) error
+	MarkMailFailed(id int, sendErr string, nextAttemptAt time.Time, dead bool) error
+}
+
+// Backoff returns how long to wait before trying an email again after attempts failed tries
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	d := firstBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+// Pool sends the mail in the outbox with a few workers
+type Pool struct {
+	Store        Store
+	Mailer       mailer.Mailer
+	Workers      int
+	PollInterval time.Duration
+	InfoLog      *log.Logger
+	ErrorLog     *log.Logger
+}
+
+// Run sends mail until ctx is done, and then waits for the emails being sent
+func (p *Pool) Run(ctx context.Context) {
+	jobs := make(chan models.OutboxMail)
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for mail := range jobs {
+				p.deliver(mail)
+			}
+		}()
+	}
+
+	for {
+		p.dispatch(ctx, jobs)
+
+		select {
+		case <-ctx.Done():
+			close(jobs)
+			wg.Wait()
+			return
+		case <-time.After(p.PollInterval):
+		}
+	}
+}
+
+// dispatch hands the mail that is due to the workers until none is left or ctx is done. Emails
+// claimed and not handed out are taken again once their lease runs out
+func (p *Pool) dispatch(ctx context.Context, jobs chan<- models.OutboxMail) {
+	for ctx.Err() == nil {
+		mail, err := p.Store.ClaimMail(p.Workers, lease)
+		if err != nil {
+			p.ErrorLog.Println("cannot claim mail from the outbox:", err)
+			return
+		}
+
+		for _, m := range mail {
+			select {
+			case jobs <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if len(mail) < p.Workers {
+			return
+		}
+	}
+}
+
+// deliver sends mail and records how it went
+func (p *Pool) deliver(mail models.OutboxMail) {
+	sendErr := p.Mailer.Send(mail.Mail)
+	if sendErr == nil {
+		err := p.Store.MarkMailSent(mail.ID)
+		if err != nil {
+			p.ErrorLog.Printf("mail %d was sent but cannot be marked as sent: %s", mail.ID, err)
+		}
+		return
+	}
+
+	dead := mailer.IsPermanent(sendErr) || mail.Attempts >= MaxAttempts
+	if dead {
+		p.ErrorLog.Printf("giving up on mail %d to %s after %d attempts: %s", mail.ID, mail.Mail.To, mail.Attempts, sendErr)
+	} else {
+		p.InfoLog.Printf("mail %d to %s failed, retrying: %s", mail.ID, mail.Mail.To, sendErr)
+	}
+
+	err := p.Store.MarkMailFailed(mail.ID, sendErr.Error(), time.Now().Add(Backoff(mail.Attempts)), dead)
+	if err != nil {
+		p.ErrorLog.Printf("cannot record the failure of mail %d: %s", mail.ID, err)
+	}
+}
@@ -0,0 +1,150 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+func TestBackoff(t *testing.T) {
+	var tests = []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, maxBackoff},
+		{100, maxBackoff},
+	}
+
+	for _, e := range tests {
+		if got := Backoff(e.attempts); got != e.expected {
+			t.Errorf("for %d attempts, expected %s but got %s", e.attempts, e.expected, got)
+		}
+	}
+}
+
+// failure is how sending an email of the test store went
+type failure struct {
+	sendErr string
+	dead    bool
+}
+
+// testStore is an outbox in memory
+type testStore struct {
+	mu      sync.Mutex
+	pending []models.OutboxMail
+	sent    []int
+	failed  map[int]failure
+}
+
+func (s *testStore) ClaimMail(limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if limit > len(s.pending) {
+		limit = len(s.pending)
+	}
+	mail := s.pending[:limit]
+	s.pending = s.pending[limit:]
+	for i := range mail {
+		mail[i].Attempts++
+	}
+	return mail, nil
+}
+
+func (s *testStore) MarkMailSent(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, id)
+	return nil
+}
+
+func (s *testStore) MarkMailFailed(id int, sendErr string, nextAttemptAt time.Time, dead bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed[id] = failure{sendErr, dead}
+	return nil
+}
+
+// testMailer fails the emails to later@here.com, and those to nobody@here.com for good
+type testMailer struct{}
+
+func (testMailer) Send(m models.MailData) error {
+	switch m.To {
+	case "later@here.com":
+		return errors.New("connection refused")
+	case "nobody@here.com":
+		return mailer.Permanent(errors.New("550 no such user"))
+	}
+	return nil
+}
+
+// done is how many emails of the store were sent or failed
+func (s *testStore) done() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sent) + len(s.failed)
+}
+
+func TestPool_Run(t *testing.T) {
+	store := &testStore{failed: make(map[int]failure)}
+	for i, to := range []string{"guest@here.com", "later@here.com", "guest@here.com", "later@here.com", "nobody@here.com"} {
+		store.pending = append(store.pending, models.OutboxMail{ID: i + 1, Mail: models.MailData{To: to}})
+	}
+	// the last try of an email that keeps failing
+	store.pending[3].Attempts = MaxAttempts - 1
+
+	pool := &Pool{
+		Store:        store,
+		Mailer:       testMailer{},
+		Workers:      2,
+		PollInterval: time.Hour,
+		InfoLog:      log.New(ioutil.Discard, "", 0),
+		ErrorLog:     log.New(ioutil.Discard, "", 0),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(stopped)
+	}()
+
+	// the first round sends everything that is due, without waiting for the next poll
+	deadline := time.Now().Add(5 * time.Second)
+	for store.done() < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-stopped
+
+	if len(store.sent) != 2 {
+		t.Errorf("expected 2 emails to be sent but got %v", store.sent)
+	}
+
+	var tests = []struct {
+		name     string
+		id       int
+		expected failure
+	}{
+		{"failure", 2, failure{"connection refused", false}},
+		{"last attempt", 4, failure{"connection refused", true}},
+		{"permanent failure", 5, failure{"550 no such user", true}},
+	}
+	for _, e := range tests {
+		if got := store.failed[e.id]; got != e.expected {
+			t.Errorf("for %s, expected %+v but got %+v", e.name, e.expected, got)
+		}
+	}
+}
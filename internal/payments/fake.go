@@ -0,0 +1,182 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// DeclinedCard is the test card the fake gateway always declines
+const DeclinedCard = "4000000000000002"
+
+// FakeSignatureHeader carries the signature of fake gateway webhooks
+const FakeSignatureHeader = "X-Fake-Signature"
+
+type fakePayment struct {
+	amount   int
+	captured int
+	refunded int
+	voided   bool
+}
+
+// FakeGateway is a payment provider that keeps everything in memory, for development and tests.
+// Every card is accepted except DeclinedCard
+type FakeGateway struct {
+	secret   []byte
+	mu       sync.Mutex
+	next     int
+	payments map[string]*fakePayment
+}
+
+// NewFakeGateway creates a fake gateway whose webhooks are signed with secret
+func NewFakeGateway(secret string) *FakeGateway {
+	return &FakeGateway{
+		secret:   []byte(secret),
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+// Name identifies the provider in stored payment records
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+// Authorize holds an amount on a card without taking it
+func (g *FakeGateway) Authorize(req AuthorizeRequest) (Authorization, error) {
+	if req.Amount <= 0 {
+		return Authorization{}, errors.New("amount must be positive")
+	}
+	if req.Token == "" || req.Token == DeclinedCard {
+		return Authorization{}, ErrDeclined
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.next++
+	id := fmt.Sprintf("fake_%d", g.next)
+	g.payments[id] = &fakePayment{amount: req.Amount}
+
+	return Authorization{
+		ID:     id,
+		Amount: req.Amount,
+	}, nil
+}
+
+// Capture takes an authorized amount
+func (g *FakeGateway) Capture(authorizationID string, amount int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[authorizationID]
+	if !ok {
+		return fmt.Errorf("unknown authorization %s", authorizationID)
+	}
+	if p.voided {
+		return fmt.Errorf("authorization %s was voided", authorizationID)
+	}
+	if amount <= 0 || p.captured+amount > p.amount {
+		return fmt.Errorf("can't capture %d of authorization %s", amount, authorizationID)
+	}
+
+	p.captured += amount
+	return nil
+}
+
+// Void releases an authorization that will not be captured
+func (g *FakeGateway) Void(authorizationID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[authorizationID]
+	if !ok {
+		return fmt.Errorf("unknown authorization %s", authorizationID)
+	}
+	if p.captured > 0 {
+		return fmt.Errorf("can't void authorization %s, it was captured", authorizationID)
+	}
+
+	p.voided = true
+	return nil
+}
+
+// Held returns the amount held on or taken from cards: authorized, and neither voided nor refunded
+func (g *FakeGateway) Held() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	held := 0
+	for _, p := range g.payments {
+		if !p.voided {
+			held += p.amount - p.refunded
+		}
+	}
+	return held
+}
+
+// Refund gives back a captured amount
+func (g *FakeGateway) Refund(paymentID string, amount int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[paymentID]
+	if !ok {
+		return fmt.Errorf("unknown payment %s", paymentID)
+	}
+	if amount <= 0 || p.refunded+amount > p.captured {
+		return fmt.Errorf("can't refund %d of payment %s", amount, paymentID)
+	}
+
+	p.refunded += amount
+	return nil
+}
+
+type fakeEvent struct {
+	Type      string `json:"type"`
+	PaymentID string `json:"payment_id"`
+}
+
+// VerifyWebhook checks the hmac signature of a webhook request and reads its event
+func (g *FakeGateway) VerifyWebhook(r *http.Request) (Event, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return Event{}, err
+	}
+
+	sig, err := hex.DecodeString(r.Header.Get(FakeSignatureHeader))
+	if err != nil || !hmac.Equal(sig, g.sign(body)) {
+		return Event{}, ErrInvalidSignature
+	}
+
+	var e fakeEvent
+	err = json.Unmarshal(body, &e)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		Type:      e.Type,
+		PaymentID: e.PaymentID,
+	}, nil
+}
+
+// Webhook builds a signed webhook body, as the provider would send it
+func (g *FakeGateway) Webhook(eventType, paymentID string) (body []byte, signature string) {
+	body, _ = json.Marshal(fakeEvent{
+		Type:      eventType,
+		PaymentID: paymentID,
+	})
+	return body, hex.EncodeToString(g.sign(body))
+}
+
+func (g *FakeGateway) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
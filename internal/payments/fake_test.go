@@ -0,0 +1,121 @@
+package payments
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestFakeGateway_Payment(t *testing.T) {
+	g := NewFakeGateway("secret")
+
+	_, err := g.Authorize(AuthorizeRequest{Amount: 1000, Token: DeclinedCard})
+	if err != ErrDeclined {
+		t.Error("declined card was authorized")
+	}
+
+	auth, err := g.Authorize(AuthorizeRequest{Amount: 1000, Token: "4242424242424242"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.Capture(auth.ID, 2000); err == nil {
+		t.Error("captured more than was authorized")
+	}
+
+	if err := g.Capture(auth.ID, 1000); err != nil {
+		t.Error(err)
+	}
+
+	if err := g.Refund(auth.ID, 400); err != nil {
+		t.Error(err)
+	}
+	if g.Held() != 600 {
+		t.Errorf("expected 600 held after refunding 400 but got %d", g.Held())
+	}
+
+	if err := g.Refund(auth.ID, 1000); err == nil {
+		t.Error("refunded more than was captured")
+	}
+
+	if err := g.Capture("fake_unknown", 100); err == nil {
+		t.Error("captured an unknown authorization")
+	}
+
+	if err := g.Void(auth.ID); err == nil {
+		t.Error("voided a captured authorization")
+	}
+}
+
+func TestFakeGateway_Void(t *testing.T) {
+	g := NewFakeGateway("secret")
+
+	auth, err := g.Authorize(AuthorizeRequest{Amount: 1000, Token: "4242424242424242"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Held() != 1000 {
+		t.Errorf("expected 1000 held but got %d", g.Held())
+	}
+
+	if err := g.Void(auth.ID); err != nil {
+		t.Fatal(err)
+	}
+	if g.Held() != 0 {
+		t.Errorf("expected nothing held after voiding but got %d", g.Held())
+	}
+
+	if err := g.Capture(auth.ID, 1000); err == nil {
+		t.Error("captured a voided authorization")
+	}
+
+	if err := g.Void("fake_unknown"); err == nil {
+		t.Error("voided an unknown authorization")
+	}
+}
+
+func TestFakeGateway_VerifyWebhook(t *testing.T) {
+	g := NewFakeGateway("secret")
+
+	body, sig := g.Webhook(EventRefunded, "fake_1")
+
+	r, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewReader(body))
+	r.Header.Set(FakeSignatureHeader, sig)
+
+	e, err := g.VerifyWebhook(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventRefunded || e.PaymentID != "fake_1" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	other := NewFakeGateway("other")
+	r, _ = http.NewRequest("POST", "/payments/webhook", bytes.NewReader(body))
+	r.Header.Set(FakeSignatureHeader, sig)
+
+	_, err = other.VerifyWebhook(r)
+	if err != ErrInvalidSignature {
+		t.Error("webhook signed with another secret was accepted")
+	}
+}
+
+func TestAmountDue(t *testing.T) {
+	var tests = []struct {
+		total    int
+		percent  int
+		expected int
+	}{
+		{10000, 0, 0},
+		{10000, 30, 3000},
+		{10001, 50, 5001},
+		{10000, 100, 10000},
+		{10000, 150, 10000},
+	}
+
+	for _, e := range tests {
+		if got := AmountDue(e.total, e.percent); got != e.expected {
+			t.Errorf("%d%% of %d: expected %d but got %d", e.percent, e.total, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package payments
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Payment statuses, as stored with every payment record
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusRefunded   = "refunded"
+	StatusFailed     = "failed"
+)
+
+// Webhook event types
+const (
+	EventCaptured = "payment.captured"
+	EventRefunded = "payment.refunded"
+	EventFailed   = "payment.failed"
+)
+
+// ErrDeclined is returned when the provider refuses to authorize a payment
+var ErrDeclined = errors.New("payment declined")
+
+// ErrInvalidSignature is returned when a webhook does not come from the provider
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// AuthorizeRequest asks the provider to hold an amount on the guest's card
+type AuthorizeRequest struct {
+	Amount      int
+	Currency    string
+	Token       string
+	Description string
+	Email       string
+}
+
+// Authorization is an amount held on a card, ready to be captured
+type Authorization struct {
+	ID     string
+	Amount int
+}
+
+// Event is a payment status change notified by the provider
+type Event struct {
+	Type      string
+	PaymentID string
+}
+
+// Gateway is implemented by every payment provider. Amounts are in cents
+type Gateway interface {
+	// Name identifies the provider in stored payment records
+	Name() string
+	// Authorize holds an amount on a card without taking it
+	Authorize(req AuthorizeRequest) (Authorization, error)
+	// Capture takes an authorized amount
+	Capture(authorizationID string, amount int) error
+	// Void releases an authorization that will not be captured
+	Void(authorizationID string) error
+	// Refund gives back a captured amount
+	Refund(paymentID string, amount int) error
+	// VerifyWebhook checks that a webhook request comes from the provider and reads its event
+	VerifyWebhook(r *http.Request) (Event, error)
+}
+
+// AmountDue returns the share of total the guest pays when booking, where
+// percent is 0 for no prepayment, a deposit percentage or 100 for full prepayment
+func AmountDue(total, percent int) int {
+	if percent <= 0 || total <= 0 {
+		return 0
+	}
+	if percent >= 100 {
+		return total
+	}
+	return (total*percent + 50) / 100
+}
+
+// StatusForEvent returns the payment status a webhook event moves a payment to
+func StatusForEvent(eventType string) (string, bool) {
+	switch eventType {
+	case EventCaptured:
+		return StatusCaptured, true
+	case EventRefunded:
+		return StatusRefunded, true
+	case EventFailed:
+		return StatusFailed, true
+	}
+	return "", false
+}
@@ -0,0 +1,163 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Currency is the currency every amount is expressed in
+const Currency = "EUR"
+
+// Season overrides the base rate of a room for every night from StartDate to EndDate, both included
+type Season struct {
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int
+}
+
+// StayDiscount takes Percent off stays of at least MinNights nights
+type StayDiscount struct {
+	MinNights int
+	Percent   int
+}
+
+// Rules holds everything needed to price a stay in a room. Amounts are in cents
+type Rules struct {
+	BaseRate      int
+	WeekendUplift int
+	Seasons       []Season
+	StayDiscounts []StayDiscount
+}
+
+// Night is the price of a single night of a stay
+type Night struct {
+	Date    time.Time `json:"date"`
+	Rate    int       `json:"rate"`
+	Season  string    `json:"season,omitempty"`
+	Weekend bool      `json:"weekend"`
+}
+
+// Quote is the itemised price of a stay. Amounts are in cents
+type Quote struct {
+	Nights          []Night `json:"nights"`
+	Subtotal        int     `json:"subtotal"`
+	DiscountPercent int     `json:"discount_percent"`
+	Discount        int     `json:"discount"`
+	Total           int     `json:"total"`
+	Currency        string  `json:"currency"`
+}
+
+// ErrInvalidRange is returned when a stay does not last at least one night
+var ErrInvalidRange = errors.New("departure must be after arrival")
+
+// Quote prices every night from start up to, but not including, end
+func (r Rules) Quote(start, end time.Time) (Quote, error) {
+	q := Quote{
+		Currency: Currency,
+	}
+
+	start = truncateDay(start)
+	end = truncateDay(end)
+	if !end.After(start) {
+		return q, ErrInvalidRange
+	}
+
+	// when seasons overlap, the one that starts latest wins, so a short
+	// peak season can be set inside a longer one
+	seasons := make([]Season, len(r.Seasons))
+	copy(seasons, r.Seasons)
+	sort.SliceStable(seasons, func(i, j int) bool {
+		return seasons[i].StartDate.After(seasons[j].StartDate)
+	})
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := Night{
+			Date: d,
+			Rate: r.BaseRate,
+		}
+
+		for _, s := range seasons {
+			if !d.Before(truncateDay(s.StartDate)) && !d.After(truncateDay(s.EndDate)) {
+				night.Rate = s.NightlyRate
+				night.Season = s.Name
+				break
+			}
+		}
+
+		// friday and saturday nights are weekend nights
+		if d.Weekday() == time.Friday || d.Weekday() == time.Saturday {
+			night.Weekend = true
+			night.Rate += percentOf(night.Rate, r.WeekendUplift)
+		}
+
+		q.Nights = append(q.Nights, night)
+		q.Subtotal += night.Rate
+	}
+
+	for _, sd := range r.StayDiscounts {
+		if len(q.Nights) >= sd.MinNights && sd.Percent > q.DiscountPercent {
+			q.DiscountPercent = sd.Percent
+		}
+	}
+
+	q.Discount = percentOf(q.Subtotal, q.DiscountPercent)
+	q.Total = q.Subtotal - q.Discount
+
+	return q, nil
+}
+
+// percentOf returns pct percent of amount, rounded to the nearest cent
+func percentOf(amount, pct int) int {
+	return (amount*pct + 50) / 100
+}
+
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// FormatAmount formats an amount in cents, like 12050 as 120.50
+func FormatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// ParseAmount parses an amount like 120.50 or 120,50 into cents
+func ParseAmount(s string) (int, error) {
+	s = strings.TrimSpace(strings.Replace(s, ",", ".", 1))
+	if s == "" {
+		return 0, errors.New("empty amount")
+	}
+
+	parts := strings.SplitN(s, ".", 2)
+	units, err := strconv.Atoi(parts[0])
+	if err != nil || units < 0 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	cents := 0
+	if len(parts) == 2 {
+		frac := parts[1]
+		if len(frac) == 0 || len(frac) > 2 {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+		if len(frac) == 1 {
+			frac += "0"
+		}
+		cents, err = strconv.Atoi(frac)
+		if err != nil || cents < 0 {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+
+	return units*100 + cents, nil
+}
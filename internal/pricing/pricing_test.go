@@ -0,0 +1,116 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("02-01-2006", s)
+	return t
+}
+
+func TestRules_Quote(t *testing.T) {
+	rules := Rules{
+		BaseRate:      10000,
+		WeekendUplift: 20,
+		Seasons: []Season{
+			{Name: "Summer", StartDate: date("01-07-2050"), EndDate: date("31-08-2050"), NightlyRate: 15000},
+			{Name: "Festival", StartDate: date("15-08-2050"), EndDate: date("16-08-2050"), NightlyRate: 20000},
+		},
+		StayDiscounts: []StayDiscount{
+			{MinNights: 7, Percent: 10},
+			{MinNights: 14, Percent: 15},
+		},
+	}
+
+	var tests = []struct {
+		name     string
+		start    string
+		end      string
+		nights   int
+		subtotal int
+		discount int
+		total    int
+	}{
+		// 03-01-2050 is a monday
+		{"weekday nights", "03-01-2050", "05-01-2050", 2, 20000, 0, 20000},
+		{"weekend nights", "07-01-2050", "09-01-2050", 2, 24000, 0, 24000},
+		{"season", "04-07-2050", "06-07-2050", 2, 30000, 0, 30000},
+		{"overlapping season", "14-08-2050", "16-08-2050", 2, 35000, 0, 35000},
+		{"season ends", "31-08-2050", "02-09-2050", 2, 25000, 0, 25000},
+		{"week discount", "03-01-2050", "10-01-2050", 7, 74000, 7400, 66600},
+		{"two week discount", "03-01-2050", "17-01-2050", 14, 148000, 22200, 125800},
+	}
+
+	for _, e := range tests {
+		q, err := rules.Quote(date(e.start), date(e.end))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+
+		if len(q.Nights) != e.nights {
+			t.Errorf("%s: expected %d nights but got %d", e.name, e.nights, len(q.Nights))
+		}
+		if q.Subtotal != e.subtotal {
+			t.Errorf("%s: expected subtotal %d but got %d", e.name, e.subtotal, q.Subtotal)
+		}
+		if q.Discount != e.discount {
+			t.Errorf("%s: expected discount %d but got %d", e.name, e.discount, q.Discount)
+		}
+		if q.Total != e.total {
+			t.Errorf("%s: expected total %d but got %d", e.name, e.total, q.Total)
+		}
+	}
+
+	_, err := rules.Quote(date("05-01-2050"), date("05-01-2050"))
+	if err != ErrInvalidRange {
+		t.Error("quote for a stay without nights did not fail")
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	var tests = []struct {
+		cents    int
+		expected string
+	}{
+		{12050, "120.50"},
+		{5, "0.05"},
+		{0, "0.00"},
+		{-150, "-1.50"},
+	}
+
+	for _, e := range tests {
+		if got := FormatAmount(e.cents); got != e.expected {
+			t.Errorf("formatting %d: expected %s but got %s", e.cents, e.expected, got)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	var tests = []struct {
+		amount   string
+		expected int
+		isError  bool
+	}{
+		{"120", 12000, false},
+		{"120.5", 12050, false},
+		{"120,50", 12050, false},
+		{" 0.05 ", 5, false},
+		{"", 0, true},
+		{"abc", 0, true},
+		{"1.234", 0, true},
+		{"-1", 0, true},
+	}
+
+	for _, e := range tests {
+		got, err := ParseAmount(e.amount)
+		if e.isError && err == nil {
+			t.Errorf("parsing %q: expected an error", e.amount)
+		}
+		if !e.isError && got != e.expected {
+			t.Errorf("parsing %q: expected %d but got %d", e.amount, e.expected, got)
+		}
+	}
+}
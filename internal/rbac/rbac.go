@@ -0,0 +1,96 @@
+// Package rbac maps the access level of admin users to named roles and the permissions they grant
+package rbac
+
+import "context"
+
+// Role is the access level of a user
+type Role int
+
+// Roles, stored in users.access_level. Each one can do everything the one below it can
+const (
+	ReadOnly  Role = 1
+	FrontDesk Role = 2
+	Manager   Role = 3
+	Owner     Role = 4
+)
+
+// Roles lists the roles, from the most to the least powerful
+var Roles = []Role{Owner, Manager, FrontDesk, ReadOnly}
+
+// Permission is an action in the admin area
+type Permission string
+
+// Permissions checked by the admin routes and templates
+const (
+	ViewReservations    Permission = "view-reservations"
+	EditReservations    Permission = "edit-reservations"
+	DeleteReservations  Permission = "delete-reservations"
+	BlockDates          Permission = "block-dates"
+	ViewRooms           Permission = "view-rooms"
+	EditRooms           Permission = "edit-rooms"
+	EditRates           Permission = "edit-rates"
+	ManageCalendars     Permission = "manage-calendars"
+	ManageAPIKeys       Permission = "manage-api-keys"
+	ManageUsers         Permission = "manage-users"
+	ViewAuditLog        Permission = "view-audit-log"
+	ManageMail          Permission = "manage-mail"
+	ManageGuestMessages Permission = "manage-guest-messages"
+	ViewJobs            Permission = "view-jobs"
+)
+
+var grants = map[Role][]Permission{
+	ReadOnly:  {ViewReservations, ViewRooms},
+	FrontDesk: {EditReservations, BlockDates},
+	Manager:   {DeleteReservations, EditRooms, EditRates, ManageCalendars, ManageGuestMessages},
+	Owner:     {ManageAPIKeys, ManageUsers, ViewAuditLog, ManageMail, ViewJobs},
+}
+
+// String returns the name of the role
+func (r Role) String() string {
+	switch r {
+	case ReadOnly:
+		return "Read only"
+	case FrontDesk:
+		return "Front desk"
+	case Manager:
+		return "Manager"
+	case Owner:
+		return "Owner"
+	}
+	return "None"
+}
+
+// Valid reports whether r is one of the roles
+func (r Role) Valid() bool {
+	return r >= ReadOnly && r <= Owner
+}
+
+// Can reports whether the role grants p, directly or through the roles below it
+func (r Role) Can(p Permission) bool {
+	if !r.Valid() {
+		return false
+	}
+
+	for role := ReadOnly; role <= r; role++ {
+		for _, granted := range grants[role] {
+			if granted == p {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type contextKey struct{}
+
+// WithRole returns a copy of ctx that carries the role of the current user
+func WithRole(ctx context.Context, r Role) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// RoleFromContext returns the role of the current user, or 0 when there is none
+func RoleFromContext(ctx context.Context) Role {
+	r, _ := ctx.Value(contextKey{}).(Role)
+	return r
+}
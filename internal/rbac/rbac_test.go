@@ -0,0 +1,52 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRole_Can(t *testing.T) {
+	var tests = []struct {
+		role       Role
+		permission Permission
+		expected   bool
+	}{
+		{ReadOnly, ViewReservations, true},
+		{ReadOnly, EditReservations, false},
+		{FrontDesk, ViewRooms, true},
+		{FrontDesk, BlockDates, true},
+		{FrontDesk, DeleteReservations, false},
+		{Manager, EditReservations, true},
+		{Manager, EditRates, true},
+		{Manager, ManageAPIKeys, false},
+		{Manager, ViewAuditLog, false},
+		{Owner, ViewAuditLog, true},
+		{Manager, ManageMail, false},
+		{Owner, ManageMail, true},
+		{Manager, ViewJobs, false},
+		{Owner, ViewJobs, true},
+		{FrontDesk, ManageGuestMessages, false},
+		{Manager, ManageGuestMessages, true},
+		{Owner, ManageUsers, true},
+		{Owner, ViewReservations, true},
+		{Role(0), ViewReservations, false},
+		{Role(9), ViewReservations, false},
+	}
+
+	for _, e := range tests {
+		if e.role.Can(e.permission) != e.expected {
+			t.Errorf("for %s and %s, expected %t", e.role, e.permission, e.expected)
+		}
+	}
+}
+
+func TestRoleFromContext(t *testing.T) {
+	if RoleFromContext(context.Background()) != 0 {
+		t.Error("expected no role in an empty context")
+	}
+
+	ctx := WithRole(context.Background(), Manager)
+	if RoleFromContext(ctx) != Manager {
+		t.Error("expected the role put in the context")
+	}
+}
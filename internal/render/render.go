@@ -3,16 +3,29 @@ package render
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"time"
 
 	"github.com/adrialopezbou/bookings-go/internal/config"
 	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/pricing"
+	"github.com/adrialopezbou/bookings-go/internal/rbac"
 	"github.com/justinas/nosurf"
 )
 
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":    HumanDate,
+	"formatDate":   FormatDate,
+	"iterate":      Iterate,
+	"add":          Add,
+	"formatAmount": pricing.FormatAmount,
+	"formatPrice":  FormatPrice,
+	"can":          Can,
+	"roleName":     RoleName,
+}
 
 var app *config.AppConfig
 
@@ -23,6 +36,45 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+// HumanDate returns time in DD-MM-YYYY format
+func HumanDate(t time.Time) string {
+	return t.Format("02-01-2006")
+}
+
+// FormatDate returns time in the given layout
+func FormatDate(t time.Time, f string) string {
+	return t.Format(f)
+}
+
+// Iterate returns a slice of ints, starting at 1, going to count
+func Iterate(count int) []int {
+	var items []int
+	for i := 1; i <= count; i++ {
+		items = append(items, i)
+	}
+	return items
+}
+
+// Add returns the sum of two ints
+func Add(a, b int) int {
+	return a + b
+}
+
+// FormatPrice formats an amount in cents with its currency, like 120.50 EUR
+func FormatPrice(cents int) string {
+	return fmt.Sprintf("%s %s", pricing.FormatAmount(cents), pricing.Currency)
+}
+
+// Can reports whether a user with the access level may do something, like {{if can .AccessLevel "edit-rooms"}}
+func Can(accessLevel int, permission string) bool {
+	return rbac.Role(accessLevel).Can(rbac.Permission(permission))
+}
+
+// RoleName returns the name of the role of an access level
+func RoleName(accessLevel int) string {
+	return rbac.Role(accessLevel).String()
+}
+
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
 	td.Error = app.Session.PopString(r.Context(), "error")
@@ -31,6 +83,7 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	td.AccessLevel = int(rbac.RoleFromContext(r.Context()))
 	return td
 }
 
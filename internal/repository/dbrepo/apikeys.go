@@ -0,0 +1,33 @@
+package dbrepo
+
+import (
+	"database/sql"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// scanAPIKey scans an api_keys row into an api key
+func scanAPIKey(row scanner) (models.APIKey, error) {
+	var k models.APIKey
+	var lastUsed sql.NullTime
+
+	err := row.Scan(
+		&k.ID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&k.Revoked,
+		&lastUsed,
+		&k.CreatedAt,
+		&k.UpdatedAt,
+	)
+	if err != nil {
+		return k, err
+	}
+
+	if lastUsed.Valid {
+		k.LastUsedAt = lastUsed.Time
+	}
+
+	return k, nil
+}
@@ -0,0 +1,38 @@
+package dbrepo
+
+import (
+	"database/sql"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// auditEntryColumns are the columns scanned by scanAuditEntry, in order
+const auditEntryColumns = `id, user_id, actor, action, entity, entity_id, changes, ip, created_at`
+
+// scanAuditEntry scans a row selected with auditEntryColumns into an audit entry
+func scanAuditEntry(row scanner) (models.AuditEntry, error) {
+	var e models.AuditEntry
+	var userID sql.NullInt64
+
+	err := row.Scan(
+		&e.ID,
+		&userID,
+		&e.Actor,
+		&e.Action,
+		&e.Entity,
+		&e.EntityID,
+		&e.Changes,
+		&e.IP,
+		&e.CreatedAt,
+	)
+	if err != nil {
+		return e, err
+	}
+
+	// guests have no user
+	if userID.Valid {
+		e.UserID = int(userID.Int64)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,37 @@
+package dbrepo
+
+import (
+	"database/sql"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// calendarFeedColumns are the columns scanned by scanCalendarFeed, in order
+const calendarFeedColumns = `id, room_id, name, url, last_synced_at, last_error, created_at, updated_at`
+
+// scanCalendarFeed scans a row selected with calendarFeedColumns into a calendar feed
+func scanCalendarFeed(row scanner) (models.CalendarFeed, error) {
+	var f models.CalendarFeed
+	var lastSynced sql.NullTime
+
+	err := row.Scan(
+		&f.ID,
+		&f.RoomID,
+		&f.Name,
+		&f.URL,
+		&lastSynced,
+		&f.LastError,
+		&f.CreatedAt,
+		&f.UpdatedAt,
+	)
+	if err != nil {
+		return f, err
+	}
+
+	// feeds that were never synced keep a zero time
+	if lastSynced.Valid {
+		f.LastSyncedAt = lastSynced.Time
+	}
+
+	return f, nil
+}
@@ -0,0 +1,27 @@
+package dbrepo
+
+import (
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// guestMessageColumns are the columns scanned by scanGuestMessage, in order
+const guestMessageColumns = `id, name, subject, body, anchor, offset_days, active, created_at, updated_at`
+
+// scanGuestMessage scans a row selected with guestMessageColumns into a guest message
+func scanGuestMessage(row scanner) (models.GuestMessage, error) {
+	var g models.GuestMessage
+
+	err := row.Scan(
+		&g.ID,
+		&g.Name,
+		&g.Subject,
+		&g.Body,
+		&g.Anchor,
+		&g.OffsetDays,
+		&g.Active,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+	)
+
+	return g, err
+}
@@ -0,0 +1,19 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/holds"
+)
+
+// releaseHolds deletes within tx the hold with id, if any, and the holds of a room that expired but
+// haven't been released yet, so they don't keep the room from being held or booked
+func releaseHolds(ctx context.Context, tx *sql.Tx, roomID, id int) error {
+	stmt := `delete from room_restrictions where restriction_id = $1 
+		and (id = $2 or (room_id = $3 and hold_expires_at <= $4))`
+
+	_, err := tx.ExecContext(ctx, stmt, holds.RestrictionID, id, roomID, time.Now())
+	return err
+}
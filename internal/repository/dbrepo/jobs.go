@@ -0,0 +1,40 @@
+package dbrepo
+
+import (
+	"database/sql"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// jobRunColumns are the columns scanned by scanJobRun, in order
+const jobRunColumns = `id, job_name, status, error, started_at, finished_at, created_at, updated_at`
+
+// jobLockSpace is the first key of the advisory locks of the jobs, keeping them apart from other
+// advisory locks
+const jobLockSpace = 1
+
+// scanJobRun scans a row selected with jobRunColumns into a run of a job
+func scanJobRun(row scanner) (models.JobRun, error) {
+	var r models.JobRun
+	var finishedAt sql.NullTime
+
+	err := row.Scan(
+		&r.ID,
+		&r.JobName,
+		&r.Status,
+		&r.Error,
+		&r.StartedAt,
+		&finishedAt,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	if err != nil {
+		return r, err
+	}
+
+	if finishedAt.Valid {
+		r.FinishedAt = finishedAt.Time
+	}
+
+	return r, nil
+}
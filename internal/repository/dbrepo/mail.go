@@ -0,0 +1,85 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/outbox"
+)
+
+// outboxMailColumns are the columns scanned by scanOutboxMail, in order
+const outboxMailColumns = `id, to_address, from_address, subject, template, data, status, attempts,
+	next_attempt_at, last_error, sent_at, created_at, updated_at`
+
+// scanOutboxMail scans a row selected with outboxMailColumns into an email of the outbox
+func scanOutboxMail(row scanner) (models.OutboxMail, error) {
+	var m models.OutboxMail
+	var data []byte
+	var sentAt sql.NullTime
+
+	err := row.Scan(
+		&m.ID,
+		&m.Mail.To,
+		&m.Mail.From,
+		&m.Mail.Subject,
+		&m.Mail.Template,
+		&data,
+		&m.Status,
+		&m.Attempts,
+		&m.NextAttemptAt,
+		&m.LastError,
+		&sentAt,
+		&m.CreatedAt,
+		&m.UpdatedAt,
+	)
+	if err != nil {
+		return m, err
+	}
+
+	m.Mail.Data, err = mailer.DecodeData(m.Mail.Template, data)
+	if err != nil {
+		return m, err
+	}
+
+	if sentAt.Valid {
+		m.SentAt = sentAt.Time
+	}
+
+	return m, nil
+}
+
+// queueMail adds mail to the outbox within tx, to be sent right away
+func queueMail(ctx context.Context, tx *sql.Tx, mail []models.MailData) error {
+	stmt := `insert into mail_outbox (to_address, from_address, subject, template, data, status,
+		next_attempt_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	now := time.Now()
+	for _, msg := range mail {
+		data, err := json.Marshal(msg.Data)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, stmt,
+			msg.To,
+			msg.From,
+			msg.Subject,
+			msg.Template,
+			data,
+			outbox.StatusPending,
+			now,
+			now,
+			now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,29 +2,106 @@ package dbrepo
 
 import (
 	"context"
-	"errors"
+	"database/sql"
+	"database/sql/driver"
 	"time"
 
+	"github.com/adrialopezbou/bookings-go/internal/holds"
+	"github.com/adrialopezbou/bookings-go/internal/jobs"
 	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/outbox"
+	"github.com/adrialopezbou/bookings-go/internal/repository"
+	"github.com/adrialopezbou/bookings-go/internal/waitlist"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
+// AllUsers returns every user, ordered by name
+func (m *postgresDBRepo) AllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var users []models.User
+
+	query := `select ` + userColumns + ` from users order by last_name, first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
 }
 
-// InsertReservation inserts a reservation into the database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// BookReservation inserts a reservation, the room restriction that books its room, the payment
+// taken for it, if any, and queues the mail about it, in one transaction, and returns the id of
+// the reservation. mail builds the emails once the reservation has its id. The hold with holdID,
+// if any, becomes the reservation. When any night of the stay is taken, nothing is inserted and it
+// returns repository.ErrRoomTaken
+func (m *postgresDBRepo) BookReservation(res models.Reservation, holdID int, payment models.Payment, mail func(res models.Reservation) []models.MailData) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var newID int
 
+	quote, err := encodeQuote(res.Quote)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, res.RoomID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = releaseHolds(ctx, tx, res.RoomID, holdID)
+	if err != nil {
+		return 0, err
+	}
+
+	// the exclusion constraint keeps reservations and holds from overlapping, even when made at the
+	// same time, but not blocks, so those are looked for first, with the room locked against new ones
+	var numRows int
+	query := `select count(id) from room_restrictions 
+		where room_id = $1 and $2 < end_date and $3 > start_date`
+
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomTaken
+	}
+
+	// reservations made through the api remember the key that made them
+	var apiKeyID sql.NullInt64
+	if res.APIKeyID > 0 {
+		apiKeyID = sql.NullInt64{Int64: int64(res.APIKeyID), Valid: true}
+	}
+
 	stmt := `insert into reservations (first_name, last_name, email, 
-		phone, start_date, end_date, room_id, created_at, updated_at) 
-		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+		phone, start_date, end_date, room_id, total_amount, quote, api_key_id, created_at, updated_at) 
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -32,38 +109,50 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.Quote.Total,
+		quote,
+		apiKeyID,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
-
 	if err != nil {
 		return 0, err
 	}
 
-	return newID, nil
-}
-
-// InsertRoomRestrictions inserts a room restriction into the database
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id) 
+	stmt = `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id) 
 		values ($1, $2, $3, $4, $5, $6, $7)`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
-		r.StartDate,
-		r.EndDate,
-		r.RoomID,
-		r.ReservationID,
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
 		time.Now(),
 		time.Now(),
-		r.RestrictionID,
+		1,
 	)
+	if isOverlap(err) {
+		return 0, repository.ErrRoomTaken
+	} else if err != nil {
+		return 0, err
+	}
+
+	if payment.Amount > 0 {
+		payment.ReservationID = newID
+		_, err = insertPayment(ctx, tx, payment)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// the mail goes out if and only if the room is booked
+	res.ID = newID
+	err = queueMail(ctx, tx, mail(res))
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+
+	return newID, tx.Commit()
 }
 
 // SearchAvailabilityByDates returns true if availability exists
@@ -91,7 +180,7 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time)  ([
 
 	var rooms []models.Room
 
-	query := `select r.id, r.room_name from rooms r where r.id not in 
+	query := `select r.id, r.room_name from rooms r where r.active = true and r.id not in 
 		(select room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date)`
 
 	rows, err := m.DB.QueryContext(ctx, query, start, end)
@@ -123,67 +212,230 @@ func (m *postgresDBRepo) GetRoomById(id int) (models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var room models.Room
-
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1`
+	query := `select ` + roomColumns + ` from rooms where id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&room.ID, 
-		&room.RoomName, 
-		&room.CreatedAt, 
-		&room.UpdatedAt,
+	return scanRoom(row)
+}
+
+// GetRoomBySlug gets a room by its url slug
+func (m *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms where slug = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, slug)
+	return scanRoom(row)
+}
+
+// InsertRoom inserts a room into the database
+func (m *postgresDBRepo) InsertRoom(room models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into rooms (room_name, slug, description, capacity, amenities, images, active, 
+		base_rate, weekend_uplift, ical_token, created_at, updated_at) 
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		joinList(room.Amenities),
+		joinList(room.Images),
+		room.Active,
+		room.BaseRate,
+		room.WeekendUplift,
+		room.ICalToken,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom updates the catalogue details of a room
+func (m *postgresDBRepo) UpdateRoom(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update rooms set room_name = $1, slug = $2, description = $3, capacity = $4, 
+		amenities = $5, images = $6, base_rate = $7, weekend_uplift = $8, updated_at = $9
+		where id = $10`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		joinList(room.Amenities),
+		joinList(room.Images),
+		room.BaseRate,
+		room.WeekendUplift,
+		time.Now(),
+		room.ID,
 	)
 	if err != nil {
-		return room, err
+		return err
+	}
+
+	return nil
+}
+
+// UpdateRoomActive retires or restores a room. Retired rooms keep their reservations
+// but are no longer offered to guests
+func (m *postgresDBRepo) UpdateRoomActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update rooms set active = $1, updated_at = $2 where id = $3"
+
+	_, err := m.DB.ExecContext(ctx, query, active, time.Now(), id)
+	if err != nil {
+		return err
 	}
 
-	return room, nil
+	return nil
 }
 
+// GetUserById returns a user by id
 func (m *postgresDBRepo) GetUserById(id int) (models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at 
-			from users where id = $1`
+	query := `select ` + userColumns + ` from users where id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
+	return scanUser(row)
+}
 
-	var u models.User
-	err := row.Scan(
-		&u.ID,
-		&u.FirstName,
-		&u.LastName,
-		&u.Email,
-		&u.Password,
-		&u.AccessLevel,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-	)
+// GetUserByEmail returns a user by email
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + userColumns + ` from users where lower(email) = lower($1)`
+
+	row := m.DB.QueryRowContext(ctx, query, email)
+	return scanUser(row)
+}
+
+// InsertUser inserts a user without a password. They choose one through their invitation
+func (m *postgresDBRepo) InsertUser(u models.User) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
 
+	stmt := `insert into users (first_name, last_name, email, password, access_level, active, created_at, updated_at)
+		values ($1, $2, $3, '', $4, true, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
 	if err != nil {
-		return u, err
+		return 0, err
 	}
 
-	return u, nil
+	return newID, nil
 }
 
-
+// UpdateUser updates the name, email and access level of a user. A new email is not verified
 func (m *postgresDBRepo) UpdateUser(u models.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `update users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5`
+	query := `update users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5,
+		email_verified_at = case when email = $3 then email_verified_at else null end
+		where id = $6`
 
-	_, err := m.DB.ExecContext(ctx, query, 
-		u.FirstName, 
-		u.LastName, 
-		u.Email, 
-		u.AccessLevel, 
+	_, err := m.DB.ExecContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
 		time.Now(),
+		u.ID,
 	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateUserActive activates or deactivates a user. Inactive users can't log in
+func (m *postgresDBRepo) UpdateUserActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update users set active = $1, updated_at = $2 where id = $3"
+
+	_, err := m.DB.ExecContext(ctx, query, active, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteUser deletes a user and their tokens
+func (m *postgresDBRepo) DeleteUser(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "delete from users where id = $1"
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdatePassword hashes password the way Authenticate checks it and stores it. Sessions started
+// before the change are no longer accepted
+func (m *postgresDBRepo) UpdatePassword(id int, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return err
+	}
+
+	query := "update users set password = $1, password_changed_at = $2, updated_at = $2 where id = $3"
+
+	_, err = m.DB.ExecContext(ctx, query, string(hashedPassword), time.Now(), id)
+	if err != nil {
+		return err
+	}
 
+	return nil
+}
+
+// VerifyEmail records that a user has shown they can read mail sent to their email
+func (m *postgresDBRepo) VerifyEmail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update users set email_verified_at = $1, updated_at = $1 where id = $2"
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
 	if err != nil {
 		return err
 	}
@@ -198,20 +450,2131 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 
 	var id int
 	var hashedPassword string
+	var active bool
 
-	row := m.DB.QueryRowContext(ctx, "select id, password from users where email = $1", email)
-	err := row.Scan(&id, &hashedPassword)
-	if err != nil {
-		return id, "", err
+	row := m.DB.QueryRowContext(ctx, "select id, password, active from users where lower(email) = lower($1)", email)
+	err := row.Scan(&id, &hashedPassword, &active)
+	if err == sql.ErrNoRows {
+		// compare anyway, so unknown emails take as long to answer as known ones
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(testPassword))
+		return 0, "", repository.ErrInvalidCredentials
+	} else if err != nil {
+		return 0, "", err
 	}
 
+	// invited users have no password yet, and nothing matches an empty hash
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, "", errors.New("incorrect password")
+	if err == bcrypt.ErrMismatchedHashAndPassword || err == bcrypt.ErrHashTooShort {
+		return 0, "", repository.ErrInvalidCredentials
 	} else if err != nil {
 		return 0, "", err
 	}
 
+	if !active {
+		return 0, "", repository.ErrInvalidCredentials
+	}
+
 	return id, hashedPassword, nil
 }
 
+// EnableTOTP turns on two-factor authentication for a user with secret, replacing their recovery codes
+func (m *postgresDBRepo) EnableTOTP(userID int, secret string, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set totp_secret = $1, totp_last_step = 0, updated_at = $2
+		where id = $3`, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication for a user and deletes their recovery codes
+func (m *postgresDBRepo) DisableTOTP(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set totp_secret = '', totp_last_step = 0, updated_at = $1
+		where id = $2`, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code of step was used by a user. It returns false when that code,
+// or a later one, was already used
+func (m *postgresDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update users set totp_last_step = $1 where id = $2 and totp_last_step < $1"
+
+	result, err := m.DB.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user
+func (m *postgresDBRepo) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode spends the recovery code of a user with the given hash. It returns false when the
+// user has no such unused code
+func (m *postgresDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update recovery_codes set used_at = $1, updated_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (m *postgresDBRepo) CountRecoveryCodes(userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var n int
+	query := "select count(id) from recovery_codes where user_id = $1 and used_at is null"
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// GetLoginThrottle returns the failed logins counted for key
+func (m *postgresDBRepo) GetLoginThrottle(key string) (models.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + loginThrottleColumns + ` from login_throttles where key = $1`
+
+	return scanLoginThrottle(m.DB.QueryRowContext(ctx, query, key))
+}
+
+// RecordLoginFailure counts a failed login for key and returns how many there are. Failures older
+// than since are forgotten
+func (m *postgresDBRepo) RecordLoginFailure(key string, since time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `insert into login_throttles (key, failures, last_failure_at, created_at, updated_at)
+		values ($1, 1, $2, $2, $2)
+		on conflict (key) do update set
+			failures = case when login_throttles.last_failure_at < $3 then 1 else login_throttles.failures + 1 end,
+			last_failure_at = $2, updated_at = $2
+		returning failures`
+
+	var failures int
+	err := m.DB.QueryRowContext(ctx, query, key, time.Now(), since).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// LockLogin refuses logins for key until the given time
+func (m *postgresDBRepo) LockLogin(key string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update login_throttles set locked_until = $1, updated_at = $2 where key = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, until, time.Now(), key)
+	return err
+}
+
+// ClearLoginThrottle forgets the failed logins of key and lifts its lock
+func (m *postgresDBRepo) ClearLoginThrottle(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from login_throttles where key = $1", key)
+	return err
+}
+
+// LockedLogins returns the keys that are locked now
+func (m *postgresDBRepo) LockedLogins() ([]models.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var throttles []models.LoginThrottle
+
+	query := `select ` + loginThrottleColumns + ` from login_throttles where locked_until > $1 order by key`
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return throttles, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanLoginThrottle(rows)
+		if err != nil {
+			return throttles, err
+		}
+		throttles = append(throttles, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return throttles, err
+	}
+
+	return throttles, nil
+}
+
+// InsertLockoutEvent records a lockout
+func (m *postgresDBRepo) InsertLockoutEvent(e models.LockoutEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into lockout_events (key, ip, failures, locked_until, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, e.Key, e.IP, e.Failures, e.LockedUntil, time.Now(), time.Now())
+	return err
+}
+
+// InsertAuditEntry appends an entry to the audit log
+func (m *postgresDBRepo) InsertAuditEntry(e models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// actions of guests have no user
+	var userID sql.NullInt64
+	if e.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(e.UserID), Valid: true}
+	}
+
+	stmt := `insert into audit_entries (user_id, actor, action, entity, entity_id, changes, ip, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		userID,
+		e.Actor,
+		e.Action,
+		e.Entity,
+		e.EntityID,
+		e.Changes,
+		e.IP,
+		time.Now(),
+		time.Now(),
+	)
+
+	return err
+}
+
+// AuditEntries returns the entries of the audit log that match f, the newest first
+func (m *postgresDBRepo) AuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var entries []models.AuditEntry
+
+	query := `select ` + auditEntryColumns + ` from audit_entries
+		where ($1 = '' or entity = $1) and ($2 = 0 or entity_id = $2) and ($3 = 0 or user_id = $3)
+		order by created_at desc, id desc`
+	args := []interface{}{f.Entity, f.EntityID, f.UserID}
+	if f.Limit > 0 {
+		query += ` limit $4`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// QueueMail adds mail to the outbox, to be sent right away
+func (m *postgresDBRepo) QueueMail(mail []models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = queueMail(ctx, tx, mail)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClaimMail takes up to limit emails of the outbox that are due for lease and counts the attempt
+// to send them. Emails claimed by other workers are skipped, so several can run side by side
+func (m *postgresDBRepo) ClaimMail(limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mail []models.OutboxMail
+	now := time.Now()
+
+	query := `update mail_outbox set locked_until = $1, attempts = attempts + 1, updated_at = $2
+		where id in (
+			select id from mail_outbox
+			where status = $3 and next_attempt_at <= $2 and (locked_until is null or locked_until < $2)
+			order by next_attempt_at, id
+			limit $4
+			for update skip locked
+		)
+		returning ` + outboxMailColumns
+
+	rows, err := m.DB.QueryContext(ctx, query, now.Add(lease), now, outbox.StatusPending, limit)
+	if err != nil {
+		return mail, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanOutboxMail(rows)
+		if err != nil {
+			return mail, err
+		}
+		mail = append(mail, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return mail, err
+	}
+
+	return mail, nil
+}
+
+// MarkMailSent records that an email of the outbox was sent
+func (m *postgresDBRepo) MarkMailSent(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update mail_outbox set status = $1, sent_at = $2, locked_until = null, last_error = '', updated_at = $2
+		where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, outbox.StatusSent, time.Now(), id)
+	return err
+}
+
+// MarkMailFailed records that sending an email of the outbox failed with sendErr. It is tried again
+// at nextAttemptAt, or never when it is dead
+func (m *postgresDBRepo) MarkMailFailed(id int, sendErr string, nextAttemptAt time.Time, dead bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	status := outbox.StatusPending
+	if dead {
+		status = outbox.StatusDead
+	}
+
+	query := `update mail_outbox set status = $1, last_error = $2, next_attempt_at = $3, locked_until = null,
+		updated_at = $4
+		where id = $5`
+
+	_, err := m.DB.ExecContext(ctx, query, status, sendErr, nextAttemptAt, time.Now(), id)
+	return err
+}
+
+// OutboxMail returns the latest limit emails of the outbox with status, the newest first
+func (m *postgresDBRepo) OutboxMail(status string, limit int) ([]models.OutboxMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mail []models.OutboxMail
+
+	query := `select ` + outboxMailColumns + ` from mail_outbox
+		where status = $1
+		order by created_at desc, id desc
+		limit $2`
+
+	rows, err := m.DB.QueryContext(ctx, query, status, limit)
+	if err != nil {
+		return mail, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanOutboxMail(rows)
+		if err != nil {
+			return mail, err
+		}
+		mail = append(mail, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return mail, err
+	}
+
+	return mail, nil
+}
+
+// GetOutboxMailByID returns an email of the outbox
+func (m *postgresDBRepo) GetOutboxMailByID(id int) (models.OutboxMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + outboxMailColumns + ` from mail_outbox where id = $1`
+
+	return scanOutboxMail(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// ResendMail queues an email of the outbox to be sent again right away, with a fresh count of
+// attempts
+func (m *postgresDBRepo) ResendMail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update mail_outbox set status = $1, attempts = 0, next_attempt_at = $2, locked_until = null,
+		updated_at = $2
+		where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, outbox.StatusPending, time.Now(), id)
+	return err
+}
+
+// InsertUserToken stores a new user token
+func (m *postgresDBRepo) InsertUserToken(t models.UserToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into user_tokens (user_id, purpose, token_hash, expires_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		t.UserID,
+		t.Purpose,
+		t.TokenHash,
+		t.ExpiresAt,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetUserTokenByHash returns the unexpired token for purpose with the given hash
+func (m *postgresDBRepo) GetUserTokenByHash(purpose, hash string) (models.UserToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + userTokenColumns + ` from user_tokens
+		where purpose = $1 and token_hash = $2 and expires_at > $3`
+
+	row := m.DB.QueryRowContext(ctx, query, purpose, hash, time.Now())
+	return scanUserToken(row)
+}
+
+// DeleteUserTokens deletes the tokens of a user for purpose, so that none of their links work anymore
+func (m *postgresDBRepo) DeleteUserTokens(userID int, purpose string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "delete from user_tokens where user_id = $1 and purpose = $2"
+
+	_, err := m.DB.ExecContext(ctx, query, userID, purpose)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllReservations returns a slice of all reservations
+func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.quote, r.cancelled_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		order by r.start_date asc`
+
+	return m.queryReservations(query)
+}
+
+// NewReservations returns a slice of the reservations that have not been processed yet
+func (m *postgresDBRepo) NewReservations() ([]models.Reservation, error) {
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.quote, r.cancelled_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.processed = 0 and r.cancelled_at is null
+		order by r.start_date asc`
+
+	return m.queryReservations(query)
+}
+
+// queryReservations runs a reservations query joined with rooms and scans the results
+func (m *postgresDBRepo) queryReservations(query string, args ...interface{}) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		var quote string
+		var cancelledAt sql.NullTime
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&quote,
+			&cancelledAt,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		i.Quote, err = decodeQuote(quote)
+		if err != nil {
+			return reservations, err
+		}
+		if cancelledAt.Valid {
+			i.CancelledAt = cancelledAt.Time
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// GetReservationByID returns one reservation by ID
+func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var res models.Reservation
+	var quote string
+	var cancelledAt sql.NullTime
+	var apiKeyID sql.NullInt64
+
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.quote, r.cancelled_at, r.api_key_id, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Processed,
+		&quote,
+		&cancelledAt,
+		&apiKeyID,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+	if err != nil {
+		return res, err
+	}
+
+	res.Quote, err = decodeQuote(quote)
+	if err != nil {
+		return res, err
+	}
+
+	if cancelledAt.Valid {
+		res.CancelledAt = cancelledAt.Time
+	}
+	res.APIKeyID = int(apiKeyID.Int64)
+
+	return res, nil
+}
+
+// UpdateReservation updates the guest details of a reservation
+func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5
+		where id = $6`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		time.Now(),
+		res.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteReservation deletes a reservation and the room restriction that belongs to it
+func (m *postgresDBRepo) DeleteReservation(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "delete from room_restrictions where reservation_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from reservations where id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelReservation cancels a reservation, freeing the nights it held, and queues the mail about
+// it in the same transaction. The reservation itself is kept, together with its payments
+func (m *postgresDBRepo) CancelReservation(id int, mail []models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "delete from room_restrictions where reservation_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "update reservations set cancelled_at = $1, updated_at = $2 where id = $3",
+		time.Now(), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	err = queueMail(ctx, tx, mail)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ChangeReservationDates moves a reservation, and the nights it holds, to the dates and price in res.
+// It reports false, changing nothing, when the room is taken on any of the new nights
+func (m *postgresDBRepo) ChangeReservationDates(res models.Reservation) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	quote, err := encodeQuote(res.Quote)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, res.RoomID)
+	if err != nil {
+		return false, err
+	}
+
+	// the nights the reservation already holds don't count against it
+	var numRows int
+	query := `select count(id) from room_restrictions 
+		where room_id = $1 and $2 < end_date and $3 > start_date and coalesce(reservation_id, 0) <> $4`
+
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate, res.ID).Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+	if numRows > 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3 
+		where reservation_id = $4`,
+		res.StartDate, res.EndDate, time.Now(), res.ID)
+	if isOverlap(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set start_date = $1, end_date = $2, total_amount = $3, quote = $4, 
+		updated_at = $5 where id = $6`,
+		res.StartDate, res.EndDate, res.Quote.Total, quote, time.Now(), res.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// UpdateProcessed updates processed for a reservation by id
+func (m *postgresDBRepo) UpdateProcessed(id, processed int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update reservations set processed = $1, updated_at = $2 where id = $3"
+
+	_, err := m.DB.ExecContext(ctx, query, processed, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllRooms returns all rooms, including retired ones
+func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
+	query := `select ` + roomColumns + ` from rooms order by room_name`
+
+	return m.queryRooms(query)
+}
+
+// ActiveRooms returns the rooms that are offered to guests
+func (m *postgresDBRepo) ActiveRooms() ([]models.Room, error) {
+	query := `select ` + roomColumns + ` from rooms where active = true order by room_name`
+
+	return m.queryRooms(query)
+}
+
+// queryRooms runs a rooms query and scans the results
+func (m *postgresDBRepo) queryRooms(query string, args ...interface{}) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rm, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// GetRestrictionsForRoomByDate returns restrictions for a room by date range
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
+		from room_restrictions where $1 < end_date and $2 >= start_date
+		and room_id = $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.ReservationID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertBlocksForRoom inserts an owner block for every given night of a room. When any of them is
+// booked or held by a guest, nothing is blocked and it returns repository.ErrRoomTaken
+func (m *postgresDBRepo) InsertBlocksForRoom(roomID int, dates []time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, roomID)
+	if err != nil {
+		return err
+	}
+
+	err = releaseHolds(ctx, tx, roomID, 0)
+	if err != nil {
+		return err
+	}
+
+	// blocks are outside the exclusion constraint, so the nights are checked here, with the room
+	// locked against reservations and holds made at the same time
+	query := `select count(id) from room_restrictions 
+		where room_id = $1 and $2 < end_date and $3 > start_date 
+		and (reservation_id is not null or restriction_id = $4)`
+
+	for _, d := range dates {
+		var numRows int
+		err = tx.QueryRowContext(ctx, query, roomID, d, d.AddDate(0, 0, 1), holds.RestrictionID).Scan(&numRows)
+		if err != nil {
+			return err
+		}
+		if numRows > 0 {
+			return repository.ErrRoomTaken
+		}
+	}
+
+	// restriction 2 is the owner block
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	for _, d := range dates {
+		_, err = tx.ExecContext(ctx, stmt, d, d.AddDate(0, 0, 1), roomID, 2, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBlockNights unblocks nights of a room, given by the id of the owner block each night is in.
+// A block over several nights keeps the nights not given, split in as many blocks as needed
+func (m *postgresDBRepo) DeleteBlockNights(roomID int, nights map[int][]time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, roomID)
+	if err != nil {
+		return err
+	}
+
+	for id, dates := range nights {
+		var start, end time.Time
+		stmt := `delete from room_restrictions where id = $1 and room_id = $2 and restriction_id = 2 
+			returning start_date, end_date`
+
+		err = tx.QueryRowContext(ctx, stmt, id, roomID).Scan(&start, &end)
+		if err == sql.ErrNoRows {
+			// removed since the calendar was shown
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		stmt = `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6)`
+
+		for _, kept := range keptNights(start, end, dates) {
+			_, err = tx.ExecContext(ctx, stmt, kept[0], kept[1], roomID, 2, time.Now(), time.Now())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetSeasonalRatesForRoom returns the seasonal rates of a room
+func (m *postgresDBRepo) GetSeasonalRatesForRoom(roomID int) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rates []models.SeasonalRate
+
+	query := `select id, room_id, name, start_date, end_date, nightly_rate, created_at, updated_at
+		from seasonal_rates where room_id = $1 order by start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.SeasonalRate
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.Name,
+			&r.StartDate,
+			&r.EndDate,
+			&r.NightlyRate,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+
+	return rates, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate into the database
+func (m *postgresDBRepo) InsertSeasonalRate(r models.SeasonalRate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into seasonal_rates (room_id, name, start_date, end_date, nightly_rate, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		r.RoomID,
+		r.Name,
+		r.StartDate,
+		r.EndDate,
+		r.NightlyRate,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate of a room
+func (m *postgresDBRepo) DeleteSeasonalRate(roomID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from seasonal_rates where id = $1 and room_id = $2", id, roomID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetStayDiscountsForRoom returns the length of stay discounts of a room
+func (m *postgresDBRepo) GetStayDiscountsForRoom(roomID int) ([]models.StayDiscount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var discounts []models.StayDiscount
+
+	query := `select id, room_id, min_nights, percent, created_at, updated_at
+		from stay_discounts where room_id = $1 order by min_nights`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return discounts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.StayDiscount
+		err := rows.Scan(
+			&d.ID,
+			&d.RoomID,
+			&d.MinNights,
+			&d.Percent,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+		)
+		if err != nil {
+			return discounts, err
+		}
+		discounts = append(discounts, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return discounts, err
+	}
+
+	return discounts, nil
+}
+
+// InsertStayDiscount inserts a length of stay discount into the database
+func (m *postgresDBRepo) InsertStayDiscount(d models.StayDiscount) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into stay_discounts (room_id, min_nights, percent, created_at, updated_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		d.RoomID,
+		d.MinNights,
+		d.Percent,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteStayDiscount deletes a length of stay discount of a room
+func (m *postgresDBRepo) DeleteStayDiscount(roomID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from stay_discounts where id = $1 and room_id = $2", id, roomID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// insertPayment inserts a payment within tx
+func insertPayment(ctx context.Context, tx *sql.Tx, p models.Payment) (int, error) {
+	var newID int
+
+	stmt := `insert into payments (reservation_id, provider, provider_ref, amount, currency, status, 
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := tx.QueryRowContext(ctx, stmt,
+		p.ReservationID,
+		p.Provider,
+		p.ProviderRef,
+		p.Amount,
+		p.Currency,
+		p.Status,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdatePaymentStatus updates the status of a payment
+func (m *postgresDBRepo) UpdatePaymentStatus(id int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update payments set status = $1, updated_at = $2 where id = $3"
+
+	_, err := m.DB.ExecContext(ctx, query, status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPaymentsForReservation returns the payments of a reservation
+func (m *postgresDBRepo) GetPaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `select id, reservation_id, provider, provider_ref, amount, currency, status, created_at, updated_at
+		from payments where reservation_id = $1 order by created_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(
+			&p.ID,
+			&p.ReservationID,
+			&p.Provider,
+			&p.ProviderRef,
+			&p.Amount,
+			&p.Currency,
+			&p.Status,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+
+	return payments, nil
+}
+
+// GetPaymentByProviderRef returns a payment by the reference its provider gave it
+func (m *postgresDBRepo) GetPaymentByProviderRef(provider, ref string) (models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p models.Payment
+
+	query := `select id, reservation_id, provider, provider_ref, amount, currency, status, created_at, updated_at
+		from payments where provider = $1 and provider_ref = $2`
+
+	row := m.DB.QueryRowContext(ctx, query, provider, ref)
+	err := row.Scan(
+		&p.ID,
+		&p.ReservationID,
+		&p.Provider,
+		&p.ProviderRef,
+		&p.Amount,
+		&p.Currency,
+		&p.Status,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// UpdateRoomICalToken replaces the secret token of the calendar feed of a room
+func (m *postgresDBRepo) UpdateRoomICalToken(id int, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update rooms set ical_token = $1, updated_at = $2 where id = $3"
+
+	_, err := m.DB.ExecContext(ctx, query, token, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// queryCalendarFeeds returns the calendar feeds selected by query, which must select calendarFeedColumns
+func (m *postgresDBRepo) queryCalendarFeeds(query string, args ...interface{}) ([]models.CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var feeds []models.CalendarFeed
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return feeds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f, err := scanCalendarFeed(rows)
+		if err != nil {
+			return feeds, err
+		}
+		feeds = append(feeds, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return feeds, err
+	}
+
+	return feeds, nil
+}
+
+// AllCalendarFeeds returns the external calendars of every room
+func (m *postgresDBRepo) AllCalendarFeeds() ([]models.CalendarFeed, error) {
+	return m.queryCalendarFeeds(`select ` + calendarFeedColumns + ` from calendar_feeds order by id`)
+}
+
+// GetCalendarFeedsForRoom returns the external calendars of a room
+func (m *postgresDBRepo) GetCalendarFeedsForRoom(roomID int) ([]models.CalendarFeed, error) {
+	return m.queryCalendarFeeds(`select `+calendarFeedColumns+` from calendar_feeds where room_id = $1 order by name`, roomID)
+}
+
+// GetCalendarFeedByID returns one external calendar by id
+func (m *postgresDBRepo) GetCalendarFeedByID(id int) (models.CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `select `+calendarFeedColumns+` from calendar_feeds where id = $1`, id)
+	return scanCalendarFeed(row)
+}
+
+// InsertCalendarFeed adds an external calendar to a room
+func (m *postgresDBRepo) InsertCalendarFeed(f models.CalendarFeed) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into calendar_feeds (room_id, name, url, last_error, created_at, updated_at)
+		values ($1, $2, $3, '', $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		f.RoomID,
+		f.Name,
+		f.URL,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteCalendarFeed removes an external calendar from a room, together with the restrictions imported from it
+func (m *postgresDBRepo) DeleteCalendarFeed(roomID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "delete from room_restrictions where calendar_feed_id = $1 and room_id = $2", id, roomID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from calendar_feeds where id = $1 and room_id = $2", id, roomID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateCalendarFeedSynced records the time and outcome of the last import of an external calendar
+func (m *postgresDBRepo) UpdateCalendarFeedSynced(id int, syncErr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update calendar_feeds set last_synced_at = $1, last_error = $2, updated_at = $3 where id = $4"
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), syncErr, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetExternalRestrictionsForFeed returns the restrictions imported from an external calendar
+func (m *postgresDBRepo) GetExternalRestrictionsForFeed(feedID int) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `select id, room_id, restriction_id, calendar_feed_id, external_uid, start_date, end_date
+		from room_restrictions where calendar_feed_id = $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, feedID)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.RestrictionID,
+			&r.CalendarFeedID,
+			&r.ExternalUID,
+			&r.StartDate,
+			&r.EndDate,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// SyncExternalRestrictions applies the changes found in an external calendar in a single transaction
+func (m *postgresDBRepo) SyncExternalRestrictions(feedID int, insert, update []models.RoomRestriction, remove []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// like lockRoom, for the room of the feed
+	_, err = tx.ExecContext(ctx, `select r.id from rooms r join calendar_feeds f on (f.room_id = r.id)
+		where f.id = $1 for update of r`, feedID)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range insert {
+		stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, 
+			calendar_feed_id, external_uid, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+		_, err = tx.ExecContext(ctx, stmt,
+			r.StartDate,
+			r.EndDate,
+			r.RoomID,
+			r.RestrictionID,
+			feedID,
+			r.ExternalUID,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range update {
+		stmt := `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3
+			where id = $4 and calendar_feed_id = $5`
+
+		_, err = tx.ExecContext(ctx, stmt, r.StartDate, r.EndDate, time.Now(), r.ID, feedID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range remove {
+		_, err = tx.ExecContext(ctx, "delete from room_restrictions where id = $1 and calendar_feed_id = $2", id, feedID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AllAPIKeys returns every api key, revoked ones included
+func (m *postgresDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var keys []models.APIKey
+
+	query := `select id, name, prefix, key_hash, revoked, last_used_at, created_at, updated_at
+		from api_keys order by created_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the api key with the given hash
+func (m *postgresDBRepo) GetAPIKeyByHash(hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, name, prefix, key_hash, revoked, last_used_at, created_at, updated_at
+		from api_keys where key_hash = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, hash)
+	return scanAPIKey(row)
+}
+
+// InsertAPIKey stores a new api key
+func (m *postgresDBRepo) InsertAPIKey(k models.APIKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into api_keys (name, prefix, key_hash, revoked, created_at, updated_at)
+		values ($1, $2, $3, false, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		k.Name,
+		k.Prefix,
+		k.KeyHash,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeAPIKey stops an api key from being accepted
+func (m *postgresDBRepo) RevokeAPIKey(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update api_keys set revoked = true, updated_at = $1 where id = $2"
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateAPIKeyUsed records when an api key was last used
+func (m *postgresDBRepo) UpdateAPIKeyUsed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "update api_keys set last_used_at = $1 where id = $2"
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllGuestMessages returns every guest message, in the order they are sent in a stay
+func (m *postgresDBRepo) AllGuestMessages() ([]models.GuestMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var messages []models.GuestMessage
+
+	query := `select ` + guestMessageColumns + ` from guest_messages
+		order by anchor = 'departure', offset_days, name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		g, err := scanGuestMessage(rows)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+
+	return messages, nil
+}
+
+// GetGuestMessageByID returns a guest message by id
+func (m *postgresDBRepo) GetGuestMessageByID(id int) (models.GuestMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + guestMessageColumns + ` from guest_messages where id = $1`
+
+	return scanGuestMessage(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// InsertGuestMessage inserts a guest message and returns its id
+func (m *postgresDBRepo) InsertGuestMessage(g models.GuestMessage) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into guest_messages (name, subject, body, anchor, offset_days, active, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		g.Name,
+		g.Subject,
+		g.Body,
+		g.Anchor,
+		g.OffsetDays,
+		g.Active,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateGuestMessage updates a guest message. Stays it was already sent for don't get it again
+func (m *postgresDBRepo) UpdateGuestMessage(g models.GuestMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update guest_messages set name = $1, subject = $2, body = $3, anchor = $4, offset_days = $5,
+		active = $6, updated_at = $7
+		where id = $8`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		g.Name,
+		g.Subject,
+		g.Body,
+		g.Anchor,
+		g.OffsetDays,
+		g.Active,
+		time.Now(),
+		g.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteGuestMessage deletes a guest message and the record of the stays it was sent for
+func (m *postgresDBRepo) DeleteGuestMessage(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "delete from guest_messages where id = $1"
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PendingGuestMessages returns the active guest messages not sent yet for the reservations that
+// aren't cancelled and end on or after from and start on or before to
+func (m *postgresDBRepo) PendingGuestMessages(from, to time.Time) ([]models.PendingGuestMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var pending []models.PendingGuestMessage
+
+	query := `select gm.id, r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+		r.room_id, rm.id, rm.room_name
+		from guest_messages gm
+		cross join reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where gm.active and r.cancelled_at is null and r.end_date >= $1 and r.start_date <= $2
+		and not exists (select 1 from guest_message_sends s where s.reservation_id = r.id and s.guest_message_id = gm.id)
+		order by r.start_date, gm.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return pending, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.PendingGuestMessage
+		err := rows.Scan(
+			&p.MessageID,
+			&p.Reservation.ID,
+			&p.Reservation.FirstName,
+			&p.Reservation.LastName,
+			&p.Reservation.Email,
+			&p.Reservation.Phone,
+			&p.Reservation.StartDate,
+			&p.Reservation.EndDate,
+			&p.Reservation.RoomID,
+			&p.Reservation.Room.ID,
+			&p.Reservation.Room.RoomName,
+		)
+		if err != nil {
+			return pending, err
+		}
+		pending = append(pending, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return pending, err
+	}
+
+	return pending, nil
+}
+
+// SendGuestMessage records that a guest message was sent for a reservation and queues mail in the
+// same transaction. It reports false, queueing nothing, when the message was already sent for it
+func (m *postgresDBRepo) SendGuestMessage(reservationID, messageID int, mail models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into guest_message_sends (reservation_id, guest_message_id, created_at, updated_at)
+		values ($1, $2, $3, $4)
+		on conflict (reservation_id, guest_message_id) do nothing`
+
+	result, err := tx.ExecContext(ctx, stmt, reservationID, messageID, time.Now(), time.Now())
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if inserted == 0 {
+		return false, nil
+	}
+
+	err = queueMail(ctx, tx, []models.MailData{mail})
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// LockJob takes the advisory lock of a job on a connection of its own, so only one server runs it
+// at a time. It reports false when another server holds it. Unlocking returns the connection to
+// the pool, or closes it if the lock can't be released, which releases it
+func (m *postgresDBRepo) LockJob(name string) (func() error, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, `select pg_try_advisory_lock($1, hashtext($2))`, jobLockSpace, name).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, false, err
+	}
+
+	unlock := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := conn.ExecContext(ctx, `select pg_advisory_unlock($1, hashtext($2))`, jobLockSpace, name)
+		if err != nil {
+			// a connection closed ends its session, and the locks it holds
+			_ = conn.Raw(func(interface{}) error {
+				return driver.ErrBadConn
+			})
+		}
+		conn.Close()
+		return err
+	}
+
+	return unlock, true, nil
+}
+
+// LastJobRun returns the latest run of a job, or sql.ErrNoRows if it never ran
+func (m *postgresDBRepo) LastJobRun(name string) (models.JobRun, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + jobRunColumns + ` from job_runs
+		where job_name = $1
+		order by started_at desc, id desc
+		limit 1`
+
+	return scanJobRun(m.DB.QueryRowContext(ctx, query, name))
+}
+
+// StartJobRun records that a job started running, and returns the id of the run
+func (m *postgresDBRepo) StartJobRun(name string, startedAt time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	stmt := `insert into job_runs (job_name, status, started_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, name, jobs.StatusRunning, startedAt, time.Now(), time.Now()).Scan(&id)
+	return id, err
+}
+
+// FinishJobRun records the end of a run of a job, which failed with runErr unless it is empty
+func (m *postgresDBRepo) FinishJobRun(id int, finishedAt time.Time, runErr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	status := jobs.StatusSucceeded
+	if runErr != "" {
+		status = jobs.StatusFailed
+	}
+
+	stmt := `update job_runs set status = $1, error = $2, finished_at = $3, updated_at = $4 where id = $5`
+
+	_, err := m.DB.ExecContext(ctx, stmt, status, runErr, finishedAt, time.Now(), id)
+	return err
+}
+
+// FailedJobRuns returns the latest limit runs of any job that failed
+func (m *postgresDBRepo) FailedJobRuns(limit int) ([]models.JobRun, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var runs []models.JobRun
+
+	query := `select ` + jobRunColumns + ` from job_runs
+		where status = $1
+		order by started_at desc, id desc
+		limit $2`
+
+	rows, err := m.DB.QueryContext(ctx, query, jobs.StatusFailed, limit)
+	if err != nil {
+		return runs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		run, err := scanJobRun(rows)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return runs, err
+	}
+
+	return runs, nil
+}
+
+// DeleteJobRunsBefore deletes the runs of the jobs started before t, and returns how many it deleted
+func (m *postgresDBRepo) DeleteJobRunsBefore(t time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from job_runs where started_at < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// InsertWaitlistEntry adds a guest to the waitlist, and returns the id of the entry
+func (m *postgresDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// guests waiting for any room have none
+	var roomID sql.NullInt64
+	if e.RoomID > 0 {
+		roomID = sql.NullInt64{Int64: int64(e.RoomID), Valid: true}
+	}
+
+	var id int
+	stmt := `insert into waitlist_entries (first_name, last_name, email, phone, start_date, end_date, room_id,
+		status, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.FirstName,
+		e.LastName,
+		e.Email,
+		e.Phone,
+		e.StartDate,
+		e.EndDate,
+		roomID,
+		waitlist.StatusWaiting,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+
+	return id, err
+}
+
+// GetWaitlistEntryByID returns an entry of the waitlist
+func (m *postgresDBRepo) GetWaitlistEntryByID(id int) (models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + waitlistEntryColumns + ` from ` + waitlistEntryTables + ` where w.id = $1`
+
+	return scanWaitlistEntry(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// WaitlistEntries returns up to limit entries of the waitlist with a status, in the order they joined
+func (m *postgresDBRepo) WaitlistEntries(status string, limit int) ([]models.WaitlistEntry, error) {
+	query := `select ` + waitlistEntryColumns + ` from ` + waitlistEntryTables + `
+		where w.status = $1
+		order by w.created_at, w.id
+		limit $2`
+
+	return m.queryWaitlistEntries(query, status, limit)
+}
+
+// OpenWaitlistEntries returns the entries of the waitlist waiting for a room or offered one, in the
+// order they joined
+func (m *postgresDBRepo) OpenWaitlistEntries() ([]models.WaitlistEntry, error) {
+	query := `select ` + waitlistEntryColumns + ` from ` + waitlistEntryTables + `
+		where w.status in ($1, $2)
+		order by w.created_at, w.id`
+
+	return m.queryWaitlistEntries(query, waitlist.StatusWaiting, waitlist.StatusOffered)
+}
+
+// queryWaitlistEntries runs a query of entries of the waitlist and scans the results
+func (m *postgresDBRepo) queryWaitlistEntries(query string, args ...interface{}) ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var entries []models.WaitlistEntry
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// ExpireWaitlistEntries expires the offers not taken by now, and the entries for stays that have
+// started, and returns how many it expired
+func (m *postgresDBRepo) ExpireWaitlistEntries(now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update waitlist_entries set status = $1, updated_at = $2
+		where (status = $3 and offer_expires_at < $2)
+		or (status in ($3, $4) and start_date <= $5)`
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	result, err := m.DB.ExecContext(ctx, stmt, waitlist.StatusExpired, now, waitlist.StatusOffered, waitlist.StatusWaiting, today)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// OfferWaitlistEntry offers a room to a guest waiting on the waitlist until expiresAt, and queues
+// mail telling them in the same transaction. It reports false, queueing nothing, when the entry is
+// no longer waiting
+func (m *postgresDBRepo) OfferWaitlistEntry(id, roomID int, expiresAt time.Time, mail models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmt := `update waitlist_entries set status = $1, offered_room_id = $2, offer_expires_at = $3, updated_at = $4
+		where id = $5 and status = $6`
+
+	result, err := tx.ExecContext(ctx, stmt, waitlist.StatusOffered, roomID, expiresAt, time.Now(), id, waitlist.StatusWaiting)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if updated == 0 {
+		return false, nil
+	}
+
+	err = queueMail(ctx, tx, []models.MailData{mail})
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// UpdateWaitlistStatus sets the status of an entry of the waitlist. Entries put back to waiting
+// lose the room they were offered
+func (m *postgresDBRepo) UpdateWaitlistStatus(id int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update waitlist_entries set status = $1, updated_at = $2 where id = $3`
+	if status == waitlist.StatusWaiting {
+		stmt = `update waitlist_entries set status = $1, offered_room_id = null, offer_expires_at = null,
+			updated_at = $2 where id = $3`
+	}
+
+	_, err := m.DB.ExecContext(ctx, stmt, status, time.Now(), id)
+	return err
+}
+
+// HoldRoom holds the nights of a room from start to end for a guest booking them, until expiresAt,
+// and returns the id of the hold. When any of them is taken, nothing is held and it returns
+// repository.ErrRoomTaken
+func (m *postgresDBRepo) HoldRoom(roomID int, start, end, expiresAt time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = releaseHolds(ctx, tx, roomID, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var numRows int
+	query := `select count(id) from room_restrictions 
+		where room_id = $1 and $2 < end_date and $3 > start_date`
+
+	err = tx.QueryRowContext(ctx, query, roomID, start, end).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomTaken
+	}
+
+	var newID int
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, hold_expires_at, 
+		created_at, updated_at) 
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		start,
+		end,
+		roomID,
+		holds.RestrictionID,
+		expiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if isOverlap(err) {
+		return 0, repository.ErrRoomTaken
+	} else if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// ExtendHold keeps a hold until expiresAt. It reports false, changing nothing, when the hold has
+// expired or is gone
+func (m *postgresDBRepo) ExtendHold(id int, expiresAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update room_restrictions set hold_expires_at = $1, updated_at = $2 
+		where id = $3 and restriction_id = $4 and hold_expires_at > $2`
+
+	result, err := m.DB.ExecContext(ctx, stmt, expiresAt, time.Now(), id, holds.RestrictionID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// ReleaseHold lets go of a hold before it expires
+func (m *postgresDBRepo) ReleaseHold(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from room_restrictions where id = $1 and restriction_id = $2", id, holds.RestrictionID)
+	return err
+}
+
+// ReleaseExpiredHolds deletes the holds that expired by now, and returns how many there were
+func (m *postgresDBRepo) ReleaseExpiredHolds(now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "delete from room_restrictions where restriction_id = $1 and hold_expires_at <= $2",
+		holds.RestrictionID, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
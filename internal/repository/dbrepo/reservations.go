@@ -0,0 +1,80 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/pricing"
+	"github.com/jackc/pgconn"
+)
+
+// exclusionViolation is the code postgres fails with when a row breaks an exclusion constraint
+const exclusionViolation = "23P01"
+
+// encodeQuote stores the quote a reservation was booked at, so later rate
+// changes don't rewrite its price
+func encodeQuote(q pricing.Quote) (string, error) {
+	if len(q.Nights) == 0 {
+		return "", nil
+	}
+
+	out, err := json.Marshal(q)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// decodeQuote reads back a quote stored by encodeQuote. Reservations made
+// before prices were stored have an empty quote
+func decodeQuote(s string) (pricing.Quote, error) {
+	var q pricing.Quote
+	if s == "" {
+		return q, nil
+	}
+
+	err := json.Unmarshal([]byte(s), &q)
+	return q, err
+}
+
+// isOverlap reports whether err is postgres refusing to book a room for nights another
+// reservation holds, which room_restrictions has an exclusion constraint for
+func isOverlap(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == exclusionViolation
+}
+
+// lockRoom locks the row of a room until tx ends. Blocks are outside the exclusion constraint, so
+// every transaction that checks or adds restrictions of a room takes this lock first, and the
+// nights of a room are checked and booked or blocked by one of them at a time
+func lockRoom(ctx context.Context, tx *sql.Tx, roomID int) error {
+	_, err := tx.ExecContext(ctx, "select id from rooms where id = $1 for update", roomID)
+	return err
+}
+
+// keptNights returns the nights from start to end that are not in removed, as the start and end
+// dates of each run of consecutive nights
+func keptNights(start, end time.Time, removed []time.Time) [][2]time.Time {
+	gone := make(map[string]bool)
+	for _, d := range removed {
+		gone[d.Format("2006-01-02")] = true
+	}
+
+	var kept [][2]time.Time
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if gone[d.Format("2006-01-02")] {
+			continue
+		}
+		if n := len(kept); n > 0 && kept[n-1][1].Equal(d) {
+			kept[n-1][1] = d.AddDate(0, 0, 1)
+		} else {
+			kept = append(kept, [2]time.Time{d, d.AddDate(0, 0, 1)})
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,32 @@
+package dbrepo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeptNights(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2050, time.January, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		removed  []time.Time
+		expected [][2]time.Time
+	}{
+		{"nothing removed", nil, [][2]time.Time{{day(4), day(8)}}},
+		{"first night", []time.Time{day(4)}, [][2]time.Time{{day(5), day(8)}}},
+		{"middle night", []time.Time{day(5)}, [][2]time.Time{{day(4), day(5)}, {day(6), day(8)}}},
+		{"last nights", []time.Time{day(6), day(7)}, [][2]time.Time{{day(4), day(6)}}},
+		{"every night", []time.Time{day(4), day(5), day(6), day(7)}, nil},
+	}
+
+	for _, e := range tests {
+		kept := keptNights(day(4), day(8), e.removed)
+		if !reflect.DeepEqual(kept, e.expected) {
+			t.Errorf("for %s, expected %v but got %v", e.name, e.expected, kept)
+		}
+	}
+}
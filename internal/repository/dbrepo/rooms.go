@@ -0,0 +1,52 @@
+package dbrepo
+
+import (
+	"strings"
+
+	"github.com/adrialopezbou/bookings-go/internal/helpers"
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// roomColumns are the columns scanned by scanRoom, in order
+const roomColumns = `id, room_name, slug, description, capacity, amenities, images, active, 
+	base_rate, weekend_uplift, ical_token, created_at, updated_at`
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRoom scans a row selected with roomColumns into a room
+func scanRoom(row scanner) (models.Room, error) {
+	var room models.Room
+	var amenities, images string
+
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&amenities,
+		&images,
+		&room.Active,
+		&room.BaseRate,
+		&room.WeekendUplift,
+		&room.ICalToken,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	if err != nil {
+		return room, err
+	}
+
+	room.Amenities = helpers.SplitLines(amenities)
+	room.Images = helpers.SplitLines(images)
+
+	return room, nil
+}
+
+// joinList stores a list of values as a newline separated text column
+func joinList(items []string) string {
+	return strings.Join(items, "\n")
+}
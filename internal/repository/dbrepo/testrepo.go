@@ -1,31 +1,42 @@
 package dbrepo
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	"time"
 
+	"github.com/adrialopezbou/bookings-go/internal/jobs"
+	"github.com/adrialopezbou/bookings-go/internal/mailer"
 	"github.com/adrialopezbou/bookings-go/internal/models"
+	"github.com/adrialopezbou/bookings-go/internal/pricing"
+	"github.com/adrialopezbou/bookings-go/internal/repository"
+	"github.com/adrialopezbou/bookings-go/internal/waitlist"
 )
 
-func (m *testDBRepo) AllUsers() bool {
-	return true
+// AllUsers returns every user, ordered by name
+func (m *testDBRepo) AllUsers() ([]models.User, error) {
+	var users []models.User
+	users = append(users, models.User{ID: 1, FirstName: "Admin", Email: "admin@here.com", Password: "hash", AccessLevel: 4, Active: true,
+		EmailVerifiedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)})
+	users = append(users, models.User{ID: 5, FirstName: "Invited", Email: "invited@here.com", AccessLevel: 2, Active: true})
+	users = append(users, models.User{ID: 10, FirstName: "Careful", Email: "two-factor@here.com", Password: "hash", AccessLevel: 2,
+		Active: true, EmailVerifiedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), TOTPSecret: "JBSWY3DPEHPK3PXP"})
+	return users, nil
 }
 
-// InsertReservation inserts a reservation into the database
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	// if the room id is 2, then fail; otherwise, pas
-	if res.RoomID == 2 {
+// BookReservation inserts a reservation, books its room and queues its mail. Rooms 2 and 1000
+// fail, and room 3 was just booked by someone else
+func (m *testDBRepo) BookReservation(res models.Reservation, holdID int, payment models.Payment, mail func(res models.Reservation) []models.MailData) (int, error) {
+	switch res.RoomID {
+	case 2, 1000:
 		return 0, errors.New("some error")
+	case 3:
+		return 0, repository.ErrRoomTaken
 	}
-	return 1, nil
-}
-
-// InsertRoomRestrictions inserts a room restriction into the database
-func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	if r.RoomID == 1000 {
-		return errors.New("some error")
-	}
-	return nil
+	res.ID = 1
+	return res.ID, m.QueueMail(mail(res))
 }
 
 // SearchAvailabilityByDates returns true if availability exists
@@ -33,12 +44,16 @@ func (m *testDBRepo) SearchAvailabilityByDatesAndRoomId(start, end time.Time, ro
 	if roomID == 2 {
 		return false, errors.New("some error")
 	}
-	return false, nil
+	// rooms are booked up in 2050 and free afterwards
+	return start.Year() > 2050, nil
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
 func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time)  ([]models.Room, error) {
 	var rooms []models.Room
+	if start.Year() > 2050 {
+		rooms = append(rooms, models.Room{ID: 1, RoomName: "General's Quarters"})
+	}
 	return rooms, nil
 }
 
@@ -49,22 +64,944 @@ func (m *testDBRepo) GetRoomById(id int) (models.Room, error) {
 	if id > 2 {
 		return room, errors.New("some error")
 	}
-	
+
+	room.ID = id
+	room.BaseRate = 10000
+	room.Active = true
+	return room, nil
+}
+
+// GetRoomBySlug gets a room by its url slug
+func (m *testDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	var room models.Room
+
+	switch slug {
+	case "generals-quarters":
+		room.ID = 1
+		room.RoomName = "General's Quarters"
+		room.ICalToken = "calendar-token"
+	case "majors-suite":
+		room.ID = 2
+		room.RoomName = "Major's Suite"
+	default:
+		return room, sql.ErrNoRows
+	}
+
+	room.Slug = slug
+	room.Active = true
 	return room, nil
 }
 
+// InsertRoom inserts a room into the database
+func (m *testDBRepo) InsertRoom(room models.Room) (int, error) {
+	if room.Slug == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// UpdateRoom updates the catalogue details of a room
+func (m *testDBRepo) UpdateRoom(room models.Room) error {
+	if room.ID == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateRoomActive retires or restores a room
+func (m *testDBRepo) UpdateRoomActive(id int, active bool) error {
+	if id == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// GetUserById returns a user by id. User 2 fails, user 3 doesn't exist, user 4 is inactive,
+// user 5 was invited but has no password yet, user 8 changed their password on 2022-02-01 and
+// user 9 hasn't verified their email and users 10 and 11 have two-factor authentication with
+// the secret JBSWY3DPEHPK3PXP, user 11 with a locked account
 func (m *testDBRepo) GetUserById(id int) (models.User, error) {
 	var u models.User
+	if id == 2 {
+		return u, errors.New("some error")
+	}
+	if id == 3 {
+		return u, sql.ErrNoRows
+	}
+	u.ID = id
+	u.FirstName = "Test"
+	u.Email = "test@here.com"
+	u.Password = "hash"
+	u.AccessLevel = 4
+	u.Active = id != 4
+	u.EmailVerifiedAt = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	switch id {
+	case 5:
+		u.Password = ""
+		u.EmailVerifiedAt = time.Time{}
+	case 8:
+		u.PasswordChangedAt = time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	case 9:
+		u.EmailVerifiedAt = time.Time{}
+	case 10:
+		u.Email = "two-factor@here.com"
+		u.TOTPSecret = "JBSWY3DPEHPK3PXP"
+	case 11:
+		u.Email = "locked@here.com"
+		u.TOTPSecret = "JBSWY3DPEHPK3PXP"
+	}
 	return u, nil
 }
 
+// GetUserByEmail returns a user by email. The test repo knows taken@here.com, which is user 6, and
+// unverified@here.com, user 9
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	switch email {
+	case "taken@here.com":
+		return models.User{ID: 6, Email: email, Password: "hash", AccessLevel: 1, Active: true,
+			EmailVerifiedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}, nil
+	case "unverified@here.com":
+		return models.User{ID: 9, Email: email, Password: "hash", AccessLevel: 1, Active: true}, nil
+	case "fail@here.com":
+		return models.User{}, errors.New("some error")
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+// InsertUser inserts a user without a password
+func (m *testDBRepo) InsertUser(u models.User) (int, error) {
+	if u.FirstName == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 7, nil
+}
 
+// UpdateUser updates the name, email and access level of a user
 func (m *testDBRepo) UpdateUser(u models.User) error {
+	if u.FirstName == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateUserActive activates or deactivates a user
+func (m *testDBRepo) UpdateUserActive(id int, active bool) error {
 	return nil
 }
 
+// DeleteUser deletes a user and their tokens
+func (m *testDBRepo) DeleteUser(id int) error {
+	return nil
+}
+
+// UpdatePassword stores the new password of a user
+func (m *testDBRepo) UpdatePassword(id int, password string) error {
+	return nil
+}
 
+// VerifyEmail records that a user has verified their email
+func (m *testDBRepo) VerifyEmail(id int) error {
+	return nil
+}
+
+// Authenticate authenticates a user. Every password but "wrong" and "fail", which fails, is accepted,
+// for user 10 when the email is two-factor@here.com and for user 1 otherwise
 func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+	if testPassword == "wrong" {
+		return 0, "", repository.ErrInvalidCredentials
+	}
+	if testPassword == "fail" {
+		return 0, "", errors.New("some error")
+	}
+	if email == "two-factor@here.com" {
+		return 10, "hash", nil
+	}
+	return 1, "hash", nil
+}
+
+// GetLoginThrottle returns the failed logins counted for key. The test repo only knows
+// email:locked@here.com, which is locked
+func (m *testDBRepo) GetLoginThrottle(key string) (models.LoginThrottle, error) {
+	if key == "email:locked@here.com" {
+		return models.LoginThrottle{ID: 1, Key: key, Failures: 10, LockedUntil: time.Now().Add(time.Hour)}, nil
+	}
+	return models.LoginThrottle{}, sql.ErrNoRows
+}
+
+// RecordLoginFailure counts a failed login for key. It is the first one, but for
+// email:guesser@here.com, which reaches its tenth
+func (m *testDBRepo) RecordLoginFailure(key string, since time.Time) (int, error) {
+	if key == "email:guesser@here.com" {
+		return 10, nil
+	}
+	return 1, nil
+}
+
+// LockLogin refuses logins for key until the given time
+func (m *testDBRepo) LockLogin(key string, until time.Time) error {
+	return nil
+}
+
+// ClearLoginThrottle forgets the failed logins of key
+func (m *testDBRepo) ClearLoginThrottle(key string) error {
+	return nil
+}
+
+// LockedLogins returns the keys that are locked now, which is the account of invited@here.com
+func (m *testDBRepo) LockedLogins() ([]models.LoginThrottle, error) {
+	var throttles []models.LoginThrottle
+	throttles = append(throttles, models.LoginThrottle{ID: 2, Key: "email:invited@here.com", Failures: 10,
+		LockedUntil: time.Now().Add(time.Hour)})
+	return throttles, nil
+}
+
+// InsertLockoutEvent records a lockout
+func (m *testDBRepo) InsertLockoutEvent(e models.LockoutEvent) error {
+	return nil
+}
+
+// InsertAuditEntry appends an entry to the audit log
+func (m *testDBRepo) InsertAuditEntry(e models.AuditEntry) error {
+	return nil
+}
+
+// AuditEntries returns the entries of the audit log that match f. Filtering by user 2 fails, and
+// the users have a failed login of someone who typed a formula as their email
+func (m *testDBRepo) AuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	if f.UserID == 2 {
+		return entries, errors.New("some error")
+	}
+	if f.Entity == "user" {
+		entries = append(entries, models.AuditEntry{ID: 3, Actor: `=HYPERLINK("http://evil.example","open")`,
+			Action: "login-failed", Entity: "user", IP: "192.0.2.9", CreatedAt: time.Date(2022, 2, 22, 10, 0, 0, 0, time.UTC)})
+		return entries, nil
+	}
+	entries = append(entries, models.AuditEntry{ID: 2, UserID: 1, Actor: "admin@here.com", Action: "update",
+		Entity: "room", EntityID: 1, Changes: `[{"field":"Capacity","before":2,"after":3}]`, IP: "192.0.2.1",
+		CreatedAt: time.Date(2022, 2, 21, 10, 0, 0, 0, time.UTC)})
+	entries = append(entries, models.AuditEntry{ID: 1, Actor: "guest", Action: "create", Entity: "reservation",
+		EntityID: 1, CreatedAt: time.Date(2022, 2, 20, 10, 0, 0, 0, time.UTC)})
+	return entries, nil
+}
+
+// EnableTOTP turns on two-factor authentication for a user
+func (m *testDBRepo) EnableTOTP(userID int, secret string, codeHashes []string) error {
+	return nil
+}
+
+// DisableTOTP turns off two-factor authentication for a user
+func (m *testDBRepo) DisableTOTP(userID int) error {
+	return nil
+}
 
-	return 0, "", nil
-}
\ No newline at end of file
+// UseTOTPStep records that the code of step was used. Every step is accepted
+func (m *testDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	return true, nil
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user
+func (m *testDBRepo) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	return nil
+}
+
+// UseRecoveryCode spends a recovery code. The test repo only knows abcde-12345
+func (m *testDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	return codeHash == sha256Hex("abcde-12345"), nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (m *testDBRepo) CountRecoveryCodes(userID int) (int, error) {
+	return 9, nil
+}
+
+// QueueMail adds mail to the outbox. It is sent at once with the mailer of the app, if there is
+// one, so tests can check which emails were sent
+func (m *testDBRepo) QueueMail(mail []models.MailData) error {
+	if m.App.Mailer == nil {
+		return nil
+	}
+	for _, msg := range mail {
+		err := m.App.Mailer.Send(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimMail takes the emails of the outbox that are due. There are none
+func (m *testDBRepo) ClaimMail(limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	var mail []models.OutboxMail
+	return mail, nil
+}
+
+// MarkMailSent records that an email of the outbox was sent
+func (m *testDBRepo) MarkMailSent(id int) error {
+	return nil
+}
+
+// MarkMailFailed records that sending an email of the outbox failed
+func (m *testDBRepo) MarkMailFailed(id int, sendErr string, nextAttemptAt time.Time, dead bool) error {
+	return nil
+}
+
+// OutboxMail returns the latest emails of the outbox with status. Listing the sent ones fails
+func (m *testDBRepo) OutboxMail(status string, limit int) ([]models.OutboxMail, error) {
+	var mail []models.OutboxMail
+	if status == "sent" {
+		return mail, errors.New("some error")
+	}
+	mail = append(mail, models.OutboxMail{
+		ID:        1,
+		Mail:      models.MailData{To: "john@smith.com", From: "me@here.com", Subject: "Reservation Confirmation", Template: mailer.TemplateReservationConfirmation, Data: models.ReservationMail{FirstName: "John"}},
+		Status:    status,
+		Attempts:  8,
+		LastError: "dial tcp: connection refused",
+		CreatedAt: time.Date(2022, 2, 28, 10, 0, 0, 0, time.UTC),
+	})
+	return mail, nil
+}
+
+// GetOutboxMailByID returns an email of the outbox. Email 2 fails and any other but 1 doesn't exist
+func (m *testDBRepo) GetOutboxMailByID(id int) (models.OutboxMail, error) {
+	switch id {
+	case 1:
+		return models.OutboxMail{
+			ID:        1,
+			Mail:      models.MailData{To: "john@smith.com", From: "me@here.com", Subject: "Reservation Confirmation", Template: mailer.TemplateReservationConfirmation, Data: models.ReservationMail{FirstName: "John"}},
+			Status:    "dead",
+			Attempts:  8,
+			LastError: "dial tcp: connection refused",
+			CreatedAt: time.Date(2022, 2, 28, 10, 0, 0, 0, time.UTC),
+		}, nil
+	case 2:
+		return models.OutboxMail{}, errors.New("some error")
+	}
+	return models.OutboxMail{}, sql.ErrNoRows
+}
+
+// ResendMail queues an email of the outbox to be sent again
+func (m *testDBRepo) ResendMail(id int) error {
+	return nil
+}
+
+// testGuestMessages are the guest messages of the test repo. Message 3 is inactive
+var testGuestMessages = []models.GuestMessage{
+	{ID: 1, Name: "Booking reminder", Subject: "Your stay is coming up", Body: "See you next week.", Anchor: "arrival", OffsetDays: -7, Active: true},
+	{ID: 2, Name: "Check-in instructions", Subject: "Check-in instructions", Body: "Ring the bell.", Anchor: "arrival", OffsetDays: 0, Active: true},
+	{ID: 3, Name: "Thank you", Subject: "Thank you for staying with us", Body: "Come again.", Anchor: "departure", OffsetDays: 1},
+}
+
+// AllGuestMessages returns every guest message
+func (m *testDBRepo) AllGuestMessages() ([]models.GuestMessage, error) {
+	messages := make([]models.GuestMessage, len(testGuestMessages))
+	copy(messages, testGuestMessages)
+	return messages, nil
+}
+
+// GetGuestMessageByID returns a guest message. Message 4 fails and any other but 1 to 3 doesn't exist
+func (m *testDBRepo) GetGuestMessageByID(id int) (models.GuestMessage, error) {
+	if id == 4 {
+		return models.GuestMessage{}, errors.New("some error")
+	}
+	for _, g := range testGuestMessages {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+	return models.GuestMessage{}, sql.ErrNoRows
+}
+
+// InsertGuestMessage inserts a guest message. Messages named "fail" fail
+func (m *testDBRepo) InsertGuestMessage(g models.GuestMessage) (int, error) {
+	if g.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 4, nil
+}
+
+// UpdateGuestMessage updates a guest message. Messages named "fail" fail
+func (m *testDBRepo) UpdateGuestMessage(g models.GuestMessage) error {
+	if g.Name == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteGuestMessage deletes a guest message
+func (m *testDBRepo) DeleteGuestMessage(id int) error {
+	return nil
+}
+
+// PendingGuestMessages returns the active guest messages not sent yet for reservations 1 and 2,
+// from 10-01-2050 to 12-01-2050, and for reservation 1000, which fails to send. Message 9 doesn't
+// exist anymore
+func (m *testDBRepo) PendingGuestMessages(from, to time.Time) ([]models.PendingGuestMessage, error) {
+	var pending []models.PendingGuestMessage
+	for _, id := range []int{1, 2, 1000} {
+		res := models.Reservation{
+			ID:        id,
+			FirstName: "adria",
+			LastName:  "lopez",
+			Email:     "adria@lopez.es",
+			StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC),
+			RoomID:    1,
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		}
+		for _, messageID := range []int{1, 2, 9} {
+			pending = append(pending, models.PendingGuestMessage{MessageID: messageID, Reservation: res})
+		}
+	}
+	return pending, nil
+}
+
+// SendGuestMessage records that a guest message was sent and queues mail. It was already sent for
+// reservation 2, and fails for reservation 1000
+func (m *testDBRepo) SendGuestMessage(reservationID, messageID int, mail models.MailData) (bool, error) {
+	switch reservationID {
+	case 2:
+		return false, nil
+	case 1000:
+		return false, errors.New("some error")
+	}
+	return true, m.QueueMail([]models.MailData{mail})
+}
+
+// LockJob takes the lock of a job, which is never held by another server
+func (m *testDBRepo) LockJob(name string) (func() error, bool, error) {
+	return func() error { return nil }, true, nil
+}
+
+// LastJobRun returns the latest run of a job. Job "never-run" never ran, and the others succeeded
+func (m *testDBRepo) LastJobRun(name string) (models.JobRun, error) {
+	if name == "never-run" {
+		return models.JobRun{}, sql.ErrNoRows
+	}
+	return models.JobRun{
+		ID:         1,
+		JobName:    name,
+		Status:     jobs.StatusSucceeded,
+		StartedAt:  time.Date(2022, 3, 21, 10, 0, 0, 0, time.UTC),
+		FinishedAt: time.Date(2022, 3, 21, 10, 0, 2, 0, time.UTC),
+	}, nil
+}
+
+// StartJobRun records that a job started running
+func (m *testDBRepo) StartJobRun(name string, startedAt time.Time) (int, error) {
+	return 1, nil
+}
+
+// FinishJobRun records the end of a run of a job
+func (m *testDBRepo) FinishJobRun(id int, finishedAt time.Time, runErr string) error {
+	return nil
+}
+
+// FailedJobRuns returns the latest runs of any job that failed
+func (m *testDBRepo) FailedJobRuns(limit int) ([]models.JobRun, error) {
+	return []models.JobRun{
+		{
+			ID:         2,
+			JobName:    "calendar-sync",
+			Status:     jobs.StatusFailed,
+			Error:      "dial tcp: connection refused",
+			StartedAt:  time.Date(2022, 3, 21, 9, 45, 0, 0, time.UTC),
+			FinishedAt: time.Date(2022, 3, 21, 9, 45, 10, 0, time.UTC),
+		},
+	}, nil
+}
+
+// DeleteJobRunsBefore deletes the runs of the jobs started before t
+func (m *testDBRepo) DeleteJobRunsBefore(t time.Time) (int64, error) {
+	return 0, nil
+}
+
+// testWaitlistEntries are the waitlist of the test repo. Room 1 is free in 2051, so entry 1 can be
+// offered it, and entry 7 comes after it for the same nights. Entry 4 waits for room 2, which is
+// never free. The offer of entry 2 is open, the one of entry 3 expired, and room 2, offered to
+// entry 6, has been held by another guest since
+var testWaitlistEntries = []models.WaitlistEntry{
+	{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com", StartDate: time.Date(2051, 2, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2051, 2, 12, 0, 0, 0, 0, time.UTC), Status: waitlist.StatusWaiting},
+	{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", StartDate: time.Date(2051, 3, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2051, 3, 12, 0, 0, 0, 0, time.UTC), Status: waitlist.StatusOffered, OfferedRoomID: 1, OfferExpiresAt: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 3, FirstName: "Ann", LastName: "Lee", Email: "ann@lee.com", StartDate: time.Date(2051, 2, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2051, 2, 12, 0, 0, 0, 0, time.UTC), Status: waitlist.StatusOffered, OfferedRoomID: 1, OfferExpiresAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 4, FirstName: "Bob", LastName: "Ray", Email: "bob@ray.com", StartDate: time.Date(2051, 2, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2051, 2, 12, 0, 0, 0, 0, time.UTC), RoomID: 2, Status: waitlist.StatusWaiting},
+	{ID: 6, FirstName: "Eve", LastName: "Kim", Email: "eve@kim.com", StartDate: time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC), Status: waitlist.StatusOffered, OfferedRoomID: 2, OfferExpiresAt: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 7, FirstName: "Max", LastName: "Roe", Email: "max@roe.com", StartDate: time.Date(2051, 2, 11, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2051, 2, 13, 0, 0, 0, 0, time.UTC), Status: waitlist.StatusWaiting},
+}
+
+// InsertWaitlistEntry adds a guest to the waitlist. Guests named "fail" fail
+func (m *testDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	if e.FirstName == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 8, nil
+}
+
+// GetWaitlistEntryByID returns an entry of the waitlist. Entry 99 fails and any other not in
+// testWaitlistEntries doesn't exist
+func (m *testDBRepo) GetWaitlistEntryByID(id int) (models.WaitlistEntry, error) {
+	if id == 99 {
+		return models.WaitlistEntry{}, errors.New("some error")
+	}
+	for _, e := range testWaitlistEntries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return models.WaitlistEntry{}, sql.ErrNoRows
+}
+
+// WaitlistEntries returns the entries of the waitlist with a status. Listing the removed ones fails
+func (m *testDBRepo) WaitlistEntries(status string, limit int) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	if status == waitlist.StatusRemoved {
+		return entries, errors.New("some error")
+	}
+	for _, e := range testWaitlistEntries {
+		if e.Status == status {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// OpenWaitlistEntries returns the entries of the waitlist waiting for a room or offered one
+func (m *testDBRepo) OpenWaitlistEntries() ([]models.WaitlistEntry, error) {
+	entries := make([]models.WaitlistEntry, len(testWaitlistEntries))
+	copy(entries, testWaitlistEntries)
+	return entries, nil
+}
+
+// ExpireWaitlistEntries expires the offers not taken by now
+func (m *testDBRepo) ExpireWaitlistEntries(now time.Time) (int64, error) {
+	return 0, nil
+}
+
+// OfferWaitlistEntry offers a room to a guest waiting on the waitlist and queues mail
+func (m *testDBRepo) OfferWaitlistEntry(id, roomID int, expiresAt time.Time, mail models.MailData) (bool, error) {
+	return true, m.QueueMail([]models.MailData{mail})
+}
+
+// UpdateWaitlistStatus sets the status of an entry of the waitlist
+func (m *testDBRepo) UpdateWaitlistStatus(id int, status string) error {
+	return nil
+}
+
+// HoldRoom holds the nights of a room for a guest booking them. Room 2 is held by another guest
+func (m *testDBRepo) HoldRoom(roomID int, start, end, expiresAt time.Time) (int, error) {
+	if roomID == 2 {
+		return 0, repository.ErrRoomTaken
+	}
+	return 1, nil
+}
+
+// ExtendHold keeps a hold until expiresAt. Hold 1 is the only one that hasn't expired
+func (m *testDBRepo) ExtendHold(id int, expiresAt time.Time) (bool, error) {
+	return id == 1, nil
+}
+
+// ReleaseHold lets go of a hold before it expires
+func (m *testDBRepo) ReleaseHold(id int) error {
+	return nil
+}
+
+// ReleaseExpiredHolds deletes the holds that expired by now
+func (m *testDBRepo) ReleaseExpiredHolds(now time.Time) (int64, error) {
+	return 0, nil
+}
+
+// InsertUserToken stores a new user token
+func (m *testDBRepo) InsertUserToken(t models.UserToken) error {
+	return nil
+}
+
+// GetUserTokenByHash returns the unexpired token for purpose with the given hash. The test repo
+// knows the invitation "invite-token" for user 5 and "deleted-user-token" for user 3, and the
+// password reset "reset-token" and email verification "verify-token" of user 1
+func (m *testDBRepo) GetUserTokenByHash(purpose, hash string) (models.UserToken, error) {
+	switch {
+	case purpose == "invitation" && hash == sha256Hex("invite-token"):
+		return models.UserToken{ID: 1, UserID: 5, Purpose: purpose, TokenHash: hash}, nil
+	case purpose == "invitation" && hash == sha256Hex("deleted-user-token"):
+		return models.UserToken{ID: 2, UserID: 3, Purpose: purpose, TokenHash: hash}, nil
+	case purpose == "password-reset" && hash == sha256Hex("reset-token"):
+		return models.UserToken{ID: 3, UserID: 1, Purpose: purpose, TokenHash: hash}, nil
+	case purpose == "email-verification" && hash == sha256Hex("verify-token"):
+		return models.UserToken{ID: 4, UserID: 1, Purpose: purpose, TokenHash: hash}, nil
+	}
+	return models.UserToken{}, sql.ErrNoRows
+}
+
+// DeleteUserTokens deletes the tokens of a user for purpose
+func (m *testDBRepo) DeleteUserTokens(userID int, purpose string) error {
+	return nil
+}
+
+// AllReservations returns a slice of all reservations
+func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	return reservations, nil
+}
+
+// NewReservations returns a slice of the reservations that have not been processed yet
+func (m *testDBRepo) NewReservations() ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	return reservations, nil
+}
+
+// GetReservationByID returns one reservation by ID
+func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	var res models.Reservation
+
+	if id == 3 {
+		return res, errors.New("some error")
+	} else if id > 3 {
+		return res, sql.ErrNoRows
+	}
+
+	res.ID = id
+	res.FirstName = "adria"
+	res.LastName = "lopez"
+	res.Email = "adria@lopez.es"
+	res.RoomID = 1
+	res.Room.ID = 1
+	res.Room.RoomName = "General's Quarters"
+	res.StartDate = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	res.EndDate = time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)
+	res.Quote = pricing.Quote{
+		Nights: []pricing.Night{
+			{Date: res.StartDate, Rate: 10000},
+			{Date: res.StartDate.AddDate(0, 0, 1), Rate: 10000},
+		},
+		Subtotal: 20000,
+		Total:    20000,
+		Currency: pricing.Currency,
+	}
+	res.APIKeyID = 1
+	return res, nil
+}
+
+// UpdateReservation updates the guest details of a reservation
+func (m *testDBRepo) UpdateReservation(res models.Reservation) error {
+	if res.ID == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteReservation deletes a reservation and the room restriction that belongs to it
+func (m *testDBRepo) DeleteReservation(id int) error {
+	if id == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateProcessed updates processed for a reservation by id
+func (m *testDBRepo) UpdateProcessed(id, processed int) error {
+	if id == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// CancelReservation cancels a reservation, freeing the nights it held, and queues the mail about it
+func (m *testDBRepo) CancelReservation(id int, mail []models.MailData) error {
+	if id == 2 {
+		return errors.New("some error")
+	}
+	return m.QueueMail(mail)
+}
+
+// ChangeReservationDates moves a reservation to new dates
+func (m *testDBRepo) ChangeReservationDates(res models.Reservation) (bool, error) {
+	if res.ID == 2 {
+		return false, errors.New("some error")
+	}
+	return res.StartDate.Year() > 2050, nil
+}
+
+// AllRooms returns all rooms, including retired ones
+func (m *testDBRepo) AllRooms() ([]models.Room, error) {
+	var rooms []models.Room
+	rooms = append(rooms, models.Room{
+		ID:       1,
+		RoomName: "General's Quarters",
+		Slug:     "generals-quarters",
+		Active:   true,
+	})
+	return rooms, nil
+}
+
+// ActiveRooms returns the rooms that are offered to guests
+func (m *testDBRepo) ActiveRooms() ([]models.Room, error) {
+	return m.AllRooms()
+}
+
+// GetRestrictionsForRoomByDate returns restrictions for a room by date range
+func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+	restrictions = append(restrictions, models.RoomRestriction{
+		ID:            1,
+		StartDate:     start,
+		EndDate:       start.AddDate(0, 0, 2),
+		RoomID:        roomID,
+		ReservationID: 1,
+		RestrictionID: 1,
+	})
+	restrictions = append(restrictions, models.RoomRestriction{
+		ID:            2,
+		StartDate:     start.AddDate(0, 0, 3),
+		EndDate:       start.AddDate(0, 0, 4),
+		RoomID:        roomID,
+		RestrictionID: 2,
+	})
+	restrictions = append(restrictions, models.RoomRestriction{
+		ID:             3,
+		StartDate:      start.AddDate(0, 0, 5),
+		EndDate:        start.AddDate(0, 0, 7),
+		RoomID:         roomID,
+		RestrictionID:  3,
+		CalendarFeedID: 1,
+		ExternalUID:    "abc@example.com",
+	})
+	return restrictions, nil
+}
+
+// InsertBlocksForRoom inserts an owner block for every given night of a room. Room 2 is held by
+// another guest
+func (m *testDBRepo) InsertBlocksForRoom(roomID int, dates []time.Time) error {
+	if roomID == 2 {
+		return repository.ErrRoomTaken
+	}
+	return nil
+}
+
+// DeleteBlockNights unblocks nights of a room, given by the id of the owner block each night is in
+func (m *testDBRepo) DeleteBlockNights(roomID int, nights map[int][]time.Time) error {
+	return nil
+}
+
+// GetSeasonalRatesForRoom returns the seasonal rates of a room
+func (m *testDBRepo) GetSeasonalRatesForRoom(roomID int) ([]models.SeasonalRate, error) {
+	var rates []models.SeasonalRate
+	if roomID == 2 {
+		return rates, errors.New("some error")
+	}
+
+	rates = append(rates, models.SeasonalRate{
+		ID:          1,
+		RoomID:      roomID,
+		Name:        "Summer",
+		StartDate:   time.Date(2050, time.July, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:     time.Date(2050, time.August, 31, 0, 0, 0, 0, time.UTC),
+		NightlyRate: 15000,
+	})
+	return rates, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate into the database
+func (m *testDBRepo) InsertSeasonalRate(r models.SeasonalRate) error {
+	if r.RoomID == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate of a room
+func (m *testDBRepo) DeleteSeasonalRate(roomID, id int) error {
+	if id == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// GetStayDiscountsForRoom returns the length of stay discounts of a room
+func (m *testDBRepo) GetStayDiscountsForRoom(roomID int) ([]models.StayDiscount, error) {
+	var discounts []models.StayDiscount
+	discounts = append(discounts, models.StayDiscount{
+		ID:        1,
+		RoomID:    roomID,
+		MinNights: 7,
+		Percent:   10,
+	})
+	return discounts, nil
+}
+
+// InsertStayDiscount inserts a length of stay discount into the database
+func (m *testDBRepo) InsertStayDiscount(d models.StayDiscount) error {
+	if d.RoomID == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteStayDiscount deletes a length of stay discount of a room
+func (m *testDBRepo) DeleteStayDiscount(roomID, id int) error {
+	if id == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdatePaymentStatus updates the status of a payment
+func (m *testDBRepo) UpdatePaymentStatus(id int, status string) error {
+	return nil
+}
+
+// GetPaymentsForReservation returns the payments of a reservation
+func (m *testDBRepo) GetPaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	var payments []models.Payment
+	payments = append(payments, models.Payment{
+		ID:            1,
+		ReservationID: reservationID,
+		Provider:      "fake",
+		ProviderRef:   "fake_1",
+		Amount:        3000,
+		Currency:      "EUR",
+		Status:        "captured",
+	})
+	return payments, nil
+}
+
+// GetPaymentByProviderRef returns a payment by the reference its provider gave it
+func (m *testDBRepo) GetPaymentByProviderRef(provider, ref string) (models.Payment, error) {
+	var p models.Payment
+	if ref != "fake_1" {
+		return p, sql.ErrNoRows
+	}
+
+	p.ID = 1
+	p.Provider = provider
+	p.ProviderRef = ref
+	return p, nil
+}
+
+// UpdateRoomICalToken replaces the secret token of the calendar feed of a room
+func (m *testDBRepo) UpdateRoomICalToken(id int, token string) error {
+	if id == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// AllCalendarFeeds returns the external calendars of every room
+func (m *testDBRepo) AllCalendarFeeds() ([]models.CalendarFeed, error) {
+	return m.GetCalendarFeedsForRoom(1)
+}
+
+// GetCalendarFeedsForRoom returns the external calendars of a room
+func (m *testDBRepo) GetCalendarFeedsForRoom(roomID int) ([]models.CalendarFeed, error) {
+	var feeds []models.CalendarFeed
+	if roomID == 2 {
+		return feeds, errors.New("some error")
+	}
+
+	feeds = append(feeds, models.CalendarFeed{
+		ID:     1,
+		RoomID: roomID,
+		Name:   "Other site",
+		URL:    "https://example.com/calendar.ics",
+	})
+	return feeds, nil
+}
+
+// GetCalendarFeedByID returns one external calendar by id
+func (m *testDBRepo) GetCalendarFeedByID(id int) (models.CalendarFeed, error) {
+	if id != 1 {
+		return models.CalendarFeed{}, sql.ErrNoRows
+	}
+
+	// a local file that doesn't exist, so syncing fails without going to the network
+	return models.CalendarFeed{
+		ID:     1,
+		RoomID: 1,
+		Name:   "Other site",
+		URL:    "missing-calendar.ics",
+	}, nil
+}
+
+// InsertCalendarFeed adds an external calendar to a room
+func (m *testDBRepo) InsertCalendarFeed(f models.CalendarFeed) error {
+	if f.RoomID == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DeleteCalendarFeed removes an external calendar from a room
+func (m *testDBRepo) DeleteCalendarFeed(roomID, id int) error {
+	if id == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateCalendarFeedSynced records the time and outcome of the last import of an external calendar
+func (m *testDBRepo) UpdateCalendarFeedSynced(id int, syncErr string) error {
+	return nil
+}
+
+// GetExternalRestrictionsForFeed returns the restrictions imported from an external calendar
+func (m *testDBRepo) GetExternalRestrictionsForFeed(feedID int) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+	return restrictions, nil
+}
+
+// SyncExternalRestrictions applies the changes found in an external calendar
+func (m *testDBRepo) SyncExternalRestrictions(feedID int, insert, update []models.RoomRestriction, remove []int) error {
+	return nil
+}
+
+// AllAPIKeys returns every api key, revoked ones included
+func (m *testDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	var keys []models.APIKey
+	keys = append(keys, models.APIKey{
+		ID:      1,
+		Name:    "Front-end",
+		Prefix:  "bk_test",
+		KeyHash: sha256Hex("test-api-key"),
+	})
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the api key with the given hash. The test repo knows the keys
+// "test-api-key", which made every reservation, "partner-api-key" and "revoked-api-key", which is revoked
+func (m *testDBRepo) GetAPIKeyByHash(hash string) (models.APIKey, error) {
+	switch hash {
+	case sha256Hex("test-api-key"):
+		return models.APIKey{ID: 1, Name: "Front-end", KeyHash: hash}, nil
+	case sha256Hex("partner-api-key"):
+		return models.APIKey{ID: 3, Name: "Partner", KeyHash: hash}, nil
+	case sha256Hex("revoked-api-key"):
+		return models.APIKey{ID: 2, Name: "Old partner", KeyHash: hash, Revoked: true}, nil
+	}
+	return models.APIKey{}, sql.ErrNoRows
+}
+
+// InsertAPIKey stores a new api key
+func (m *testDBRepo) InsertAPIKey(k models.APIKey) error {
+	if k.Name == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// RevokeAPIKey stops an api key from being accepted
+func (m *testDBRepo) RevokeAPIKey(id int) error {
+	if id == 2 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateAPIKeyUsed records when an api key was last used
+func (m *testDBRepo) UpdateAPIKeyUsed(id int) error {
+	return nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
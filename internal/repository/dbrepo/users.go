@@ -0,0 +1,120 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// passwordCost is the bcrypt cost passwords are hashed with
+const passwordCost = 12
+
+// dummyPasswordHash is compared against when no user has the email given to Authenticate. It is the
+// hash of a password nobody has, at passwordCost
+const dummyPasswordHash = "$2a$12$nfiPJEQKqSkO3v/SZzVwGOVz9o7ML5WEy2Plajn6qXvTxbJhX2g9e"
+
+// userColumns are the columns scanned by scanUser, in order
+const userColumns = `id, first_name, last_name, email, password, access_level, active, email_verified_at,
+	password_changed_at, totp_secret, created_at, updated_at`
+
+// scanUser scans a row selected with userColumns into a user
+func scanUser(row scanner) (models.User, error) {
+	var u models.User
+	var emailVerified, passwordChanged sql.NullTime
+
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.Active,
+		&emailVerified,
+		&passwordChanged,
+		&u.TOTPSecret,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return u, err
+	}
+
+	// unverified emails and passwords never changed keep a zero time
+	if emailVerified.Valid {
+		u.EmailVerifiedAt = emailVerified.Time
+	}
+	if passwordChanged.Valid {
+		u.PasswordChangedAt = passwordChanged.Time
+	}
+
+	return u, nil
+}
+
+// userTokenColumns are the columns scanned by scanUserToken, in order
+const userTokenColumns = `id, user_id, purpose, token_hash, expires_at, created_at, updated_at`
+
+// scanUserToken scans a row selected with userTokenColumns into a user token
+func scanUserToken(row scanner) (models.UserToken, error) {
+	var t models.UserToken
+
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Purpose,
+		&t.TokenHash,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+
+	return t, err
+}
+
+// loginThrottleColumns are the columns scanned by scanLoginThrottle, in order
+const loginThrottleColumns = `id, key, failures, last_failure_at, locked_until, created_at, updated_at`
+
+// scanLoginThrottle scans a row selected with loginThrottleColumns into a login throttle
+func scanLoginThrottle(row scanner) (models.LoginThrottle, error) {
+	var t models.LoginThrottle
+	var lockedUntil sql.NullTime
+
+	err := row.Scan(
+		&t.ID,
+		&t.Key,
+		&t.Failures,
+		&t.LastFailureAt,
+		&lockedUntil,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err != nil {
+		return t, err
+	}
+
+	if lockedUntil.Valid {
+		t.LockedUntil = lockedUntil.Time
+	}
+
+	return t, nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of a user and stores codeHashes instead, within tx
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codeHashes []string) error {
+	_, err := tx.ExecContext(ctx, "delete from recovery_codes where user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into recovery_codes (user_id, code_hash, created_at, updated_at) values ($1, $2, $3, $4)`
+	for _, hash := range codeHashes {
+		_, err = tx.ExecContext(ctx, stmt, userID, hash, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package dbrepo
+
+import (
+	"database/sql"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// waitlistEntryColumns are the columns scanned by scanWaitlistEntry, in order, selected from
+// waitlistEntryTables
+const waitlistEntryColumns = `w.id, w.first_name, w.last_name, w.email, w.phone, w.start_date, w.end_date,
+	coalesce(w.room_id, 0), w.status, coalesce(w.offered_room_id, 0), w.offer_expires_at, w.created_at,
+	w.updated_at, coalesce(r.room_name, ''), coalesce(o.room_name, '')`
+
+// waitlistEntryTables are the entries of the waitlist with the room they wait for and the one they
+// are offered
+const waitlistEntryTables = `waitlist_entries w
+	left join rooms r on (r.id = w.room_id)
+	left join rooms o on (o.id = w.offered_room_id)`
+
+// scanWaitlistEntry scans a row selected with waitlistEntryColumns into an entry of the waitlist
+func scanWaitlistEntry(row scanner) (models.WaitlistEntry, error) {
+	var e models.WaitlistEntry
+	var offerExpiresAt sql.NullTime
+
+	err := row.Scan(
+		&e.ID,
+		&e.FirstName,
+		&e.LastName,
+		&e.Email,
+		&e.Phone,
+		&e.StartDate,
+		&e.EndDate,
+		&e.RoomID,
+		&e.Status,
+		&e.OfferedRoomID,
+		&offerExpiresAt,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+		&e.Room.RoomName,
+		&e.OfferedRoom.RoomName,
+	)
+	if err != nil {
+		return e, err
+	}
+
+	e.Room.ID = e.RoomID
+	e.OfferedRoom.ID = e.OfferedRoomID
+	if offerExpiresAt.Valid {
+		e.OfferExpiresAt = offerExpiresAt.Time
+	}
+
+	return e, nil
+}
@@ -1,21 +1,137 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/adrialopezbou/bookings-go/internal/models"
 )
 
-type DatabaseRepo interface {
-	AllUsers() bool
+// ErrInvalidCredentials is returned by Authenticate for an unknown email, a wrong password or an
+// inactive user alike, so callers can't tell them apart
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrRoomTaken is returned by BookReservation and HoldRoom when a night of the stay was booked,
+// blocked or held before, like by another guest booking the same room at the same time, and by
+// InsertBlocksForRoom when a night to block is booked or held
+var ErrRoomTaken = errors.New("the room is no longer available for these dates")
 
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
+type DatabaseRepo interface {
+	BookReservation(res models.Reservation, holdID int, payment models.Payment, mail func(res models.Reservation) []models.MailData) (int, error)
 	SearchAvailabilityByDatesAndRoomId(start, end time.Time, roomID int) (bool, error)
 	SearchAvailabilityForAllRooms(start, end time.Time)  ([]models.Room, error)
 	GetRoomById(id int) (models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	ActiveRooms() ([]models.Room, error)
+	InsertRoom(room models.Room) (int, error)
+	UpdateRoom(room models.Room) error
+	UpdateRoomActive(id int, active bool) error
+
+	GetSeasonalRatesForRoom(roomID int) ([]models.SeasonalRate, error)
+	InsertSeasonalRate(r models.SeasonalRate) error
+	DeleteSeasonalRate(roomID, id int) error
+	GetStayDiscountsForRoom(roomID int) ([]models.StayDiscount, error)
+	InsertStayDiscount(d models.StayDiscount) error
+	DeleteStayDiscount(roomID, id int) error
+
+	UpdatePaymentStatus(id int, status string) error
+	GetPaymentsForReservation(reservationID int) ([]models.Payment, error)
+	GetPaymentByProviderRef(provider, ref string) (models.Payment, error)
+
+	UpdateRoomICalToken(id int, token string) error
+	AllCalendarFeeds() ([]models.CalendarFeed, error)
+	GetCalendarFeedsForRoom(roomID int) ([]models.CalendarFeed, error)
+	GetCalendarFeedByID(id int) (models.CalendarFeed, error)
+	InsertCalendarFeed(f models.CalendarFeed) error
+	DeleteCalendarFeed(roomID, id int) error
+	UpdateCalendarFeedSynced(id int, syncErr string) error
+	GetExternalRestrictionsForFeed(feedID int) ([]models.RoomRestriction, error)
+	SyncExternalRestrictions(feedID int, insert, update []models.RoomRestriction, remove []int) error
+
+	AllAPIKeys() ([]models.APIKey, error)
+	GetAPIKeyByHash(hash string) (models.APIKey, error)
+	InsertAPIKey(k models.APIKey) error
+	RevokeAPIKey(id int) error
+	UpdateAPIKeyUsed(id int) error
+
+	AllReservations() ([]models.Reservation, error)
+	NewReservations() ([]models.Reservation, error)
+	GetReservationByID(id int) (models.Reservation, error)
+	UpdateReservation(res models.Reservation) error
+	DeleteReservation(id int) error
+	UpdateProcessed(id, processed int) error
+	CancelReservation(id int, mail []models.MailData) error
+	ChangeReservationDates(res models.Reservation) (bool, error)
+
+	AllRooms() ([]models.Room, error)
+	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlocksForRoom(roomID int, dates []time.Time) error
+	DeleteBlockNights(roomID int, nights map[int][]time.Time) error
 
+	AllUsers() ([]models.User, error)
 	GetUserById(id int) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
+	InsertUser(u models.User) (int, error)
 	UpdateUser(u models.User) error
+	UpdateUserActive(id int, active bool) error
+	DeleteUser(id int) error
+	UpdatePassword(id int, password string) error
+	VerifyEmail(id int) error
 	Authenticate(email, testPassword string) (int, string, error)
+
+	EnableTOTP(userID int, secret string, codeHashes []string) error
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+
+	GetLoginThrottle(key string) (models.LoginThrottle, error)
+	RecordLoginFailure(key string, since time.Time) (int, error)
+	LockLogin(key string, until time.Time) error
+	ClearLoginThrottle(key string) error
+	LockedLogins() ([]models.LoginThrottle, error)
+	InsertLockoutEvent(e models.LockoutEvent) error
+
+	InsertAuditEntry(e models.AuditEntry) error
+	AuditEntries(f models.AuditFilter) ([]models.AuditEntry, error)
+
+	QueueMail(mail []models.MailData) error
+	ClaimMail(limit int, lease time.Duration) ([]models.OutboxMail, error)
+	MarkMailSent(id int) error
+	MarkMailFailed(id int, sendErr string, nextAttemptAt time.Time, dead bool) error
+	OutboxMail(status string, limit int) ([]models.OutboxMail, error)
+	GetOutboxMailByID(id int) (models.OutboxMail, error)
+	ResendMail(id int) error
+
+	AllGuestMessages() ([]models.GuestMessage, error)
+	GetGuestMessageByID(id int) (models.GuestMessage, error)
+	InsertGuestMessage(g models.GuestMessage) (int, error)
+	UpdateGuestMessage(g models.GuestMessage) error
+	DeleteGuestMessage(id int) error
+	PendingGuestMessages(from, to time.Time) ([]models.PendingGuestMessage, error)
+	SendGuestMessage(reservationID, messageID int, mail models.MailData) (bool, error)
+
+	LockJob(name string) (func() error, bool, error)
+	LastJobRun(name string) (models.JobRun, error)
+	StartJobRun(name string, startedAt time.Time) (int, error)
+	FinishJobRun(id int, finishedAt time.Time, runErr string) error
+	FailedJobRuns(limit int) ([]models.JobRun, error)
+	DeleteJobRunsBefore(t time.Time) (int64, error)
+
+	InsertWaitlistEntry(e models.WaitlistEntry) (int, error)
+	GetWaitlistEntryByID(id int) (models.WaitlistEntry, error)
+	WaitlistEntries(status string, limit int) ([]models.WaitlistEntry, error)
+	OpenWaitlistEntries() ([]models.WaitlistEntry, error)
+	ExpireWaitlistEntries(now time.Time) (int64, error)
+	OfferWaitlistEntry(id, roomID int, expiresAt time.Time, mail models.MailData) (bool, error)
+	UpdateWaitlistStatus(id int, status string) error
+	HoldRoom(roomID int, start, end, expiresAt time.Time) (int, error)
+	ExtendHold(id int, expiresAt time.Time) (bool, error)
+	ReleaseHold(id int) error
+	ReleaseExpiredHolds(now time.Time) (int64, error)
+
+	InsertUserToken(t models.UserToken) error
+	GetUserTokenByHash(purpose, hash string) (models.UserToken, error)
+	DeleteUserTokens(userID int, purpose string) error
 }
@@ -0,0 +1,108 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 test vectors of RFC 6238, appendix B
+var rfcTests = []struct {
+	unix     int64
+	expected string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestGenerate(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	for _, e := range rfcTests {
+		got := generate(key, Step(time.Unix(e.unix, 0)), 8)
+		if got != e.expected {
+			t.Errorf("for %d, expected %s but got %s", e.unix, e.expected, got)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+
+	code, err := Code(secret, time.Unix(59, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "287082" {
+		t.Errorf("expected the last six digits of the rfc vector but got %s", code)
+	}
+
+	// apps show secrets in groups of four, in lowercase
+	code, err = Code(strings.ToLower(secret[:4]+" "+secret[4:]), time.Unix(59, 0))
+	if err != nil || code != "287082" {
+		t.Errorf("expected spaces and case to be ignored but got %s, %v", code, err)
+	}
+
+	_, err = Code("not base32!", time.Now())
+	if err == nil {
+		t.Error("expected an error for a bad secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1643700000, 0)
+	code, _ := Code(secret, now)
+
+	step, ok := Validate(secret, code, now)
+	if !ok || step != Step(now) {
+		t.Errorf("expected the current code to be valid for step %d but got %d, %t", Step(now), step, ok)
+	}
+
+	_, ok = Validate(secret, code, now.Add(Period))
+	if !ok {
+		t.Error("expected the previous code to be accepted")
+	}
+
+	_, ok = Validate(secret, code, now.Add(3*Period))
+	if ok {
+		t.Error("expected an old code to be refused")
+	}
+
+	_, ok = Validate(secret, "12345", now)
+	if ok {
+		t.Error("expected a short code to be refused")
+	}
+}
+
+func TestValidateBadSecret(t *testing.T) {
+	now := time.Unix(1643700000, 0)
+
+	// an empty secret is an empty key, whose codes anyone can compute
+	code := generate([]byte{}, Step(now), Digits)
+
+	for _, secret := range []string{"", " ", "==", "not base32!"} {
+		if _, ok := Validate(secret, code, now); ok {
+			t.Errorf("expected a code to be refused for the secret %q", secret)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	u := URL("JBSWY3DPEHPK3PXP", "Bookings", "admin@here.com")
+
+	if !strings.HasPrefix(u, "otpauth://totp/Bookings:admin@here.com?") {
+		t.Errorf("unexpected label in %s", u)
+	}
+	if !strings.Contains(u, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(u, "issuer=Bookings") {
+		t.Errorf("expected the secret and issuer in %s", u)
+	}
+}
@@ -0,0 +1,84 @@
+// Package waitlist keeps the guests waiting for dates that are fully booked, and decides which of
+// them to offer a room when one frees up
+package waitlist
+
+import (
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+// Statuses of the entries of the waitlist
+const (
+	StatusWaiting = "waiting"
+	StatusOffered = "offered"
+	StatusBooked  = "booked"
+	StatusExpired = "expired"
+	StatusRemoved = "removed"
+)
+
+// Statuses lists the statuses, in the order the admin pages show them
+var Statuses = []string{StatusWaiting, StatusOffered, StatusBooked, StatusExpired, StatusRemoved}
+
+// OfferValidFor is how long a guest has to book the room they are offered. The room is kept from
+// the guests after them until then
+const OfferValidFor = 24 * time.Hour
+
+// Offer is a room to offer to the guest of an entry
+type Offer struct {
+	Entry  models.WaitlistEntry
+	RoomID int
+}
+
+// FreeRooms returns the ids of the rooms free from start to end
+type FreeRooms func(start, end time.Time) ([]int, error)
+
+// Offers returns the rooms to offer to the waiting entries, going through them in the order they
+// joined. Rooms offered to earlier entries, now or by an offer that hasn't expired at now, aren't
+// offered again for the same nights
+func Offers(entries []models.WaitlistEntry, now time.Time, free FreeRooms) ([]Offer, error) {
+	var taken []Offer
+	for _, e := range entries {
+		if e.Status == StatusOffered && now.Before(e.OfferExpiresAt) {
+			taken = append(taken, Offer{Entry: e, RoomID: e.OfferedRoomID})
+		}
+	}
+
+	var offers []Offer
+	for _, e := range entries {
+		if e.Status != StatusWaiting {
+			continue
+		}
+
+		rooms, err := free(e.StartDate, e.EndDate)
+		if err != nil {
+			return offers, err
+		}
+
+		for _, roomID := range rooms {
+			if e.RoomID != 0 && roomID != e.RoomID {
+				continue
+			}
+			if isTaken(taken, roomID, e.StartDate, e.EndDate) {
+				continue
+			}
+
+			offer := Offer{Entry: e, RoomID: roomID}
+			offers = append(offers, offer)
+			taken = append(taken, offer)
+			break
+		}
+	}
+
+	return offers, nil
+}
+
+// isTaken reports whether a room is offered for any night from start to end
+func isTaken(taken []Offer, roomID int, start, end time.Time) bool {
+	for _, o := range taken {
+		if o.RoomID == roomID && start.Before(o.Entry.EndDate) && end.After(o.Entry.StartDate) {
+			return true
+		}
+	}
+	return false
+}
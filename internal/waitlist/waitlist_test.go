@@ -0,0 +1,122 @@
+package waitlist
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adrialopezbou/bookings-go/internal/models"
+)
+
+func day(d int) time.Time {
+	return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestOffers(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// room 1 is free from the 10th to the 14th, room 2 from the 12th to the 20th
+	free := func(start, end time.Time) ([]int, error) {
+		var rooms []int
+		if !start.Before(day(10)) && !end.After(day(14)) {
+			rooms = append(rooms, 1)
+		}
+		if !start.Before(day(12)) && !end.After(day(20)) {
+			rooms = append(rooms, 2)
+		}
+		return rooms, nil
+	}
+
+	var tests = []struct {
+		name     string
+		entries  []models.WaitlistEntry
+		expected map[int]int
+	}{
+		{
+			"first in line gets the room",
+			[]models.WaitlistEntry{
+				{ID: 1, StartDate: day(10), EndDate: day(12), Status: StatusWaiting},
+				{ID: 2, StartDate: day(11), EndDate: day(13), Status: StatusWaiting},
+			},
+			map[int]int{1: 1},
+		},
+		{
+			"different nights of the same room",
+			[]models.WaitlistEntry{
+				{ID: 1, StartDate: day(10), EndDate: day(12), Status: StatusWaiting},
+				{ID: 2, StartDate: day(12), EndDate: day(14), Status: StatusWaiting},
+			},
+			map[int]int{1: 1, 2: 1},
+		},
+		{
+			"waiting for a room",
+			[]models.WaitlistEntry{
+				{ID: 1, StartDate: day(12), EndDate: day(14), RoomID: 2, Status: StatusWaiting},
+				{ID: 2, StartDate: day(12), EndDate: day(14), Status: StatusWaiting},
+			},
+			map[int]int{1: 2, 2: 1},
+		},
+		{
+			"no room free",
+			[]models.WaitlistEntry{
+				{ID: 1, StartDate: day(8), EndDate: day(11), Status: StatusWaiting},
+				{ID: 2, StartDate: day(10), EndDate: day(12), RoomID: 2, Status: StatusWaiting},
+			},
+			map[int]int{},
+		},
+		{
+			"room kept for an open offer",
+			[]models.WaitlistEntry{
+				{ID: 1, StartDate: day(10), EndDate: day(12), Status: StatusOffered, OfferedRoomID: 1, OfferExpiresAt: now.Add(time.Hour)},
+				{ID: 2, StartDate: day(11), EndDate: day(13), Status: StatusWaiting},
+			},
+			map[int]int{},
+		},
+		{
+			"room of an expired offer",
+			[]models.WaitlistEntry{
+				{ID: 1, StartDate: day(10), EndDate: day(12), Status: StatusOffered, OfferedRoomID: 1, OfferExpiresAt: now.Add(-time.Hour)},
+				{ID: 2, StartDate: day(11), EndDate: day(13), Status: StatusWaiting},
+			},
+			map[int]int{2: 1},
+		},
+		{
+			"other statuses are skipped",
+			[]models.WaitlistEntry{
+				{ID: 1, StartDate: day(10), EndDate: day(12), Status: StatusRemoved},
+				{ID: 2, StartDate: day(10), EndDate: day(12), Status: StatusBooked},
+			},
+			map[int]int{},
+		},
+	}
+
+	for _, e := range tests {
+		offers, err := Offers(e.entries, now, free)
+		if err != nil {
+			t.Errorf("for %s, unexpected error: %s", e.name, err)
+			continue
+		}
+
+		if len(offers) != len(e.expected) {
+			t.Errorf("for %s, expected %d offers but got %+v", e.name, len(e.expected), offers)
+			continue
+		}
+		for _, o := range offers {
+			if roomID, ok := e.expected[o.Entry.ID]; !ok || roomID != o.RoomID {
+				t.Errorf("for %s, expected no room %d for entry %d", e.name, o.RoomID, o.Entry.ID)
+			}
+		}
+	}
+}
+
+func TestOffersFail(t *testing.T) {
+	entries := []models.WaitlistEntry{{ID: 1, StartDate: day(10), EndDate: day(12), Status: StatusWaiting}}
+	free := func(start, end time.Time) ([]int, error) {
+		return nil, errors.New("some error")
+	}
+
+	_, err := Offers(entries, day(1), free)
+	if err == nil {
+		t.Error("expected an error when the free rooms can't be searched")
+	}
+}
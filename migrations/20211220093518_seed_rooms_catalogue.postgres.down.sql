@@ -0,0 +1 @@
+UPDATE public.rooms SET slug = '', images = '', description = '';
\ No newline at end of file
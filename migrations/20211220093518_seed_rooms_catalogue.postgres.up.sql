@@ -0,0 +1,7 @@
+UPDATE public.rooms SET slug = 'generals-quarters', images = '/static/images/generals-quarters.png',
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.'
+	WHERE room_name = 'General''s Quarters';
+UPDATE public.rooms SET slug = 'majors-suite', images = '/static/images/marjors-suite.png',
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.'
+	WHERE room_name = 'Major''s Suite';
+UPDATE public.rooms SET slug = 'room-' || id WHERE slug = '';
\ No newline at end of file
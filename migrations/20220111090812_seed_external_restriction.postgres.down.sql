@@ -0,0 +1,2 @@
+DELETE FROM public.restrictions WHERE id = 3;
+UPDATE public.rooms SET ical_token = '';
\ No newline at end of file
@@ -0,0 +1,5 @@
+INSERT INTO public.restrictions (id,restriction_name,created_at,updated_at) VALUES
+	 (3,'External','2022-01-11 00:00:00.000','2022-01-11 00:00:00.000');
+SELECT setval(pg_get_serial_sequence('restrictions', 'id'), (SELECT max(id) FROM public.restrictions));
+
+UPDATE public.rooms SET ical_token = md5(random()::text || id::text) WHERE ical_token = '';
\ No newline at end of file
@@ -0,0 +1 @@
+-- owners can no longer be told apart from the users this promoted, so they keep their role
\ No newline at end of file
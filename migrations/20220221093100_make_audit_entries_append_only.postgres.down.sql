@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS audit_entries_append_only ON public.audit_entries;
+DROP FUNCTION IF EXISTS audit_entries_append_only();
\ No newline at end of file
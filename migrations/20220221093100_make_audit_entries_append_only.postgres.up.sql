@@ -0,0 +1,10 @@
+-- entries can be added to the audit log but never changed or removed
+CREATE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_entries_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON public.audit_entries
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_entries_append_only();
\ No newline at end of file
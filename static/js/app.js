@@ -160,6 +160,9 @@ function AddCheckAvailabilityButtonListener(csrfToken, roomId) {
                             attention.custom({
                                 icon: "success",
                                 msg: "<p>Room is avaialble!</p>"
+                                    + (data.quote
+                                        ? "<p>Total: " + (data.quote.total / 100).toFixed(2) + " " + data.quote.currency + "</p>"
+                                        : "")
                                     + "<p><a href='/book-room?id="
                                     + data.room_id
                                     + "&s="